@@ -0,0 +1,294 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/e-gov/SiGa-Go/archive"
+	"github.com/e-gov/SiGa-Go/siga"
+)
+
+// Sirvikule saadetavate vigade koodid.
+const (
+	codeMethodNotAllowed     = "METHOD_NOT_ALLOWED"
+	codeUnsupportedMediaType = "UNSUPPORTED_MEDIA_TYPE"
+	codeBodyTooLarge         = "BODY_TOO_LARGE"
+	codeInvalidJSON          = "INVALID_JSON"
+	codeInvalidForm          = "INVALID_FORM"
+	codeNothingToSign        = "NOTHING_TO_SIGN"
+	codeTooManyFiles         = "TOO_MANY_FILES"
+	codeFileTooLarge         = "FILE_TOO_LARGE"
+	codeInvalidFileName      = "INVALID_FILE_NAME"
+	codeDuplicateFileName    = "DUPLICATE_FILE_NAME"
+	codeInvalidFile          = "INVALID_FILE"
+	codeInvalidCertificate   = "INVALID_CERTIFICATE"
+	codeInvalidSignature     = "INVALID_SIGNATURE"
+	codeInvalidPersonalCode  = "INVALID_PERSONAL_CODE"
+	codeInvalidPhone         = "INVALID_PHONE"
+	codeInvalidSigningKind   = "INVALID_SIGNING_KIND"
+	codeNotStarted           = "SIGNING_NOT_STARTED"
+	codeNotSigned            = "NOT_SIGNED"
+	codeDocumentNotFound     = "DOCUMENT_NOT_FOUND"
+	codeInvalidContainer     = "INVALID_CONTAINER"
+	codeUnsupportedContainer = "UNSUPPORTED_CONTAINER"
+	codeNoContainer          = "NO_CONTAINER"
+	codeSigningFailed        = "SIGNING_FAILED"
+	codeServiceUnavailable   = "SERVICE_UNAVAILABLE"
+	codeTooManySessions      = "TOO_MANY_SESSIONS"
+	codeTooManyContainers    = "TOO_MANY_CONTAINERS"
+	codeShuttingDown         = "SHUTTING_DOWN"
+	codeSigaError            = "SIGA_ERROR"
+	codeInternalError        = "INTERNAL_ERROR"
+)
+
+// Toetatud keeled. Vaikimisi kasutatakse eesti keelt.
+const (
+	langET = "et"
+	langEN = "en"
+)
+
+// errorMessages sisaldab veakoodide teateid keelte kaupa. Teated on
+// fmt.Sprintf vormingus, argumentidega apiError.args.
+var errorMessages = map[string]map[string]string{
+	codeMethodNotAllowed: {
+		langET: "Päringumeetod %s ei ole lubatud",
+		langEN: "Request method %s is not allowed",
+	},
+	codeUnsupportedMediaType: {
+		langET: "Päringu keha vorming %s ei ole toetatud",
+		langEN: "Request body format %s is not supported",
+	},
+	codeBodyTooLarge: {
+		langET: "Päringu keha on liiga suur (lubatud kuni %d baiti)",
+		langEN: "Request body is too large (up to %d bytes allowed)",
+	},
+	codeInvalidJSON: {
+		langET: "Päringu keha ei ole korrektne JSON",
+		langEN: "Request body is not valid JSON",
+	},
+	codeInvalidForm: {
+		langET: "Päringu vormi lugemine ebaõnnestus",
+		langEN: "Reading the request form failed",
+	},
+	codeNothingToSign: {
+		langET: "Allkirjastamiseks tuleb sisestada tekst või valida fail",
+		langEN: "Enter text or choose a file to sign",
+	},
+	codeTooManyFiles: {
+		langET: "Liiga palju faile: %d (lubatud kuni %d)",
+		langEN: "Too many files: %d (up to %d allowed)",
+	},
+	codeFileTooLarge: {
+		langET: "Fail %s on liiga suur: %d baiti (lubatud kuni %d)",
+		langEN: "File %s is too large: %d bytes (up to %d allowed)",
+	},
+	codeInvalidFileName: {
+		langET: "Vigane failinimi: %q",
+		langEN: "Invalid file name: %q",
+	},
+	codeDuplicateFileName: {
+		langET: "Korduv failinimi: %s",
+		langEN: "Duplicate file name: %s",
+	},
+	codeInvalidFile: {
+		langET: "Faili %s lugemine ebaõnnestus",
+		langEN: "Reading file %s failed",
+	},
+	codeInvalidCertificate: {
+		langET: "Allkirjastaja sert puudub või on vigane",
+		langEN: "The signer's certificate is missing or invalid",
+	},
+	codeInvalidSignature: {
+		langET: "Allkirjaväärtus puudub või on vigane",
+		langEN: "The signature value is missing or invalid",
+	},
+	codeInvalidPersonalCode: {
+		langET: "Vigane isikukood",
+		langEN: "Invalid personal identification code",
+	},
+	codeInvalidPhone: {
+		langET: "Vigane mobiilinumber",
+		langEN: "Invalid mobile phone number",
+	},
+	codeInvalidSigningKind: {
+		langET: "Tundmatu allkirjastamisviis: %q",
+		langEN: "Unknown signing method: %q",
+	},
+	codeNotStarted: {
+		langET: "Allkirjastamist ei ole alustatud",
+		langEN: "Signing has not been started",
+	},
+	codeNotSigned: {
+		langET: "Seansis ei ole allkirjastatud konteinerit",
+		langEN: "There is no signed container in the session",
+	},
+	codeDocumentNotFound: {
+		langET: "Arhiveeritud dokumenti ei leitud",
+		langEN: "Archived document not found",
+	},
+	codeInvalidContainer: {
+		langET: "Fail %s ei ole korrektne allkirjakonteiner",
+		langEN: "File %s is not a valid signature container",
+	},
+	codeUnsupportedContainer: {
+		langET: "Allkirja saab lisada ainult ASiC-E (.asice) konteinerile",
+		langEN: "A signature can only be added to an ASiC-E (.asice) container",
+	},
+	codeNoContainer: {
+		langET: "Seansis ei ole üles laaditud konteinerit",
+		langEN: "There is no uploaded container in the session",
+	},
+	codeSigningFailed: {
+		langET: "Allkirjastamine ebaõnnestus",
+		langEN: "Signing failed",
+	},
+	codeServiceUnavailable: {
+		langET: "Allkirjastamisteenus ei ole ajutiselt kättesaadav, proovi hiljem uuesti",
+		langEN: "The signing service is temporarily unavailable, please try again later",
+	},
+	codeTooManySessions: {
+		langET: "Server on ülekoormatud, proovi hiljem uuesti",
+		langEN: "The server is overloaded, please try again later",
+	},
+	codeTooManyContainers: {
+		langET: "Serveris on liiga palju üles laaditud konteinereid, proovi hiljem uuesti",
+		langEN: "Too many containers have been uploaded to the server, please try again later",
+	},
+	codeShuttingDown: {
+		langET: "Server lõpetab tööd, proovi mõne aja pärast uuesti",
+		langEN: "The server is shutting down, please try again in a while",
+	},
+	codeSigaError: {
+		langET: "Pöördumine allkirjastamisteenuse poole ebaõnnestus",
+		langEN: "Request to the signing service failed",
+	},
+	codeInternalError: {
+		langET: "Serveri sisemine viga",
+		langEN: "Internal server error",
+	},
+}
+
+// apiError on sirvikule saadetav viga: HTTP olekukood, veakood ja teate
+// argumendid. Teade moodustatakse päringu keeles alles vastuse saatmisel.
+type apiError struct {
+	status int
+	code   string
+	args   []interface{}
+	// cause on vea põhjus, mis logitakse, kuid sirvikule ei saadeta.
+	cause error
+}
+
+func newAPIError(status int, code string, args ...interface{}) *apiError {
+	return &apiError{status: status, code: code, args: args}
+}
+
+// withCause lisab veale logitava põhjuse.
+func (e *apiError) withCause(cause error) *apiError {
+	e.cause = cause
+	return e
+}
+
+func (e *apiError) Error() string {
+	msg := e.message(langEN)
+	if e.cause != nil {
+		msg += ": " + e.cause.Error()
+	}
+	return msg
+}
+
+// message tagastab vea teate keeles lang.
+func (e *apiError) message(lang string) string {
+	format, ok := errorMessages[e.code][lang]
+	if !ok {
+		format = errorMessages[e.code][langET]
+	}
+	if len(e.args) == 0 {
+		return format
+	}
+	return fmt.Sprintf(format, e.args...)
+}
+
+// errorBody on vea JSON kuju vastuses.
+type errorBody struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// errorEnvelope on veavastuse keha: {"error": {"code": ..., "message": ...}}.
+type errorEnvelope struct {
+	Error *errorBody `json:"error"`
+}
+
+// toAPIError teisendab vea sirvikule saadetavaks veaks. SiGa klienti
+// kaitsva kaitselüliti ja päringute piiraja vead teisendatakse ajutise
+// kättesaamatuse veaks, arhiivi puuduva dokumendi viga 404 veaks, muud vead
+// sisemiseks veaks.
+func toAPIError(err error) *apiError {
+	var apiErr *apiError
+	switch {
+	case errors.As(err, &apiErr):
+		return apiErr
+	case errors.Is(err, siga.ErrCircuitOpen), errors.Is(err, siga.ErrRateLimited):
+		return newAPIError(http.StatusServiceUnavailable, codeServiceUnavailable).withCause(err)
+	case errors.Is(err, archive.ErrNotFound):
+		return newAPIError(http.StatusNotFound, codeDocumentNotFound).withCause(err)
+	default:
+		return newAPIError(http.StatusInternalServerError, codeInternalError).withCause(err)
+	}
+}
+
+// sigaError teisendab SiGa kliendi vea sirvikule saadetavaks veaks.
+func sigaError(err error) *apiError {
+	apiErr := toAPIError(err)
+	if apiErr.code == codeInternalError {
+		apiErr = newAPIError(http.StatusBadGateway, codeSigaError).withCause(err)
+	}
+	return apiErr
+}
+
+// errorStatus tagastab veale vastava HTTP olekukoodi.
+func errorStatus(err error) int {
+	return toAPIError(err).status
+}
+
+// localize tagastab vea JSON kuju päringu req keeles.
+func localize(req *http.Request, err error) *errorBody {
+	apiErr := toAPIError(err)
+	return &errorBody{Code: apiErr.code, Message: apiErr.message(requestLanguage(req))}
+}
+
+// writeError logib vea ja saadab selle sirvikule ühtses JSON ümbrikus.
+func writeError(w http.ResponseWriter, req *http.Request, handler string, err error) {
+	log.Printf("%s: %s %s: %v", handler, req.Method, req.URL.Path, err)
+	writeJSON(w, errorStatus(err), errorEnvelope{Error: localize(req, err)})
+}
+
+// writeJSON saadab sirvikule JSON vastuse olekukoodiga status.
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Println("writeJSON: Vastuse saatmine ebaõnnestus: ", err)
+	}
+}
+
+// requestLanguage valib päringu Accept-Language päise järgi vastuse keele.
+// Kui päises ei ole ühtki toetatud keelt, siis on vastuse keel eesti keel.
+func requestLanguage(req *http.Request) string {
+	for _, tag := range strings.Split(req.Header.Get("Accept-Language"), ",") {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if i := strings.IndexAny(tag, "-;"); i >= 0 {
+			tag = tag[:i]
+		}
+		switch tag {
+		case langET, langEN:
+			return tag
+		}
+	}
+	return langET
+}
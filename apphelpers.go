@@ -1,15 +1,18 @@
 package main
 
 import (
-	// "encoding/json"
+	"crypto/tls"
+	"encoding/json"
 	// "fmt"
-	// "io/ioutil"
+	"io/ioutil"
 	"log"
 	"net/http"
-	// "os"
+	"os"
 
+	"github.com/e-gov/SiGa-Go/confutil"
+	"github.com/e-gov/SiGa-Go/https"
 	"github.com/e-gov/SiGa-Go/siga"
-	// "github.com/e-gov/SiGa-Go/https"
+	"github.com/e-gov/SiGa-Go/tracing"
 )
 
 // CreateSIGAClient moodustab HTTPS kliendi SiGa poole pöördumiseks.
@@ -24,20 +27,66 @@ func CreateSIGAClient(conf siga.Conf) siga.Client {
 	return c
 }
 
-// CreateServer moodustab HTTPS serveri sirvikust tulevate päringute teenindamiseks.
-func CreateServer() {
+// Serveri vaikeseadistus, kui serveri seadistusfaili ei ole.
+const (
+	defaultListen   = ":8080"
+	defaultTLSChain = "certs/localhostchain.cert"
+	defaultTLSKey   = "certs/localhost.key"
+)
+
+// LoadServerConf loeb failist path HTTPS serveri seadistuse (vt
+// https.ServerConf). Kui faili ei ole, siis kasutatakse aadressi :8080 ning
+// serti ja võtit failidest certs/localhostchain.cert ja certs/localhost.key.
+func LoadServerConf(path string) *https.ServerConf {
+	bytes, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		log.Println("LoadServerConf: Serveri seadistusfaili ei ole, kasutan vaikeseadistust")
+		cert, err := tls.LoadX509KeyPair(defaultTLSChain, defaultTLSKey)
+		if err != nil {
+			log.Fatal("LoadServerConf: Viga serveri serdi lugemisel: ", err)
+		}
+		return &https.ServerConf{Listen: defaultListen, TLS: confutil.TLS(cert)}
+	}
+	if err != nil {
+		log.Fatal("LoadServerConf: Viga serveri seadistusfaili lugemisel: ", err)
+	}
+	var conf https.ServerConf
+	if err := json.Unmarshal(bytes, &conf); err != nil {
+		log.Fatal("LoadServerConf: Viga serveri seadistusfaili parsimisel: ", err)
+	}
+	return &conf
+}
+
+// CreateServer moodustab HTTPS serveri sirvikust tulevate päringute
+// teenindamiseks. Serveri pöördumislogi kirjutatakse rakenduse logisse.
+func CreateServer(conf *https.ServerConf) *https.Server {
+	mux := http.NewServeMux()
 
 	// API käsitlejad
-	http.Handle("/", http.FileServer(http.Dir("./static")))
-	http.HandleFunc("/p1", p1Handler)
-	http.HandleFunc("/p2", p2Handler)
-	http.HandleFunc("/mid", midHandler)
-
-	log.Fatal(http.ListenAndServeTLS(
-		":8080",
-		"certs/localhostchain.cert",
-		"certs/localhost.key",
-		nil))
+	mux.Handle("/", http.FileServer(http.Dir("./static")))
+	// Käsitlejad jätkavad sirvikust traceparent päises saabunud jälge ja
+	// töötavad päringu sirvikuseansis.
+	mux.Handle("/p1", tracing.Handler(sigaTracer, "p1", sessions.handler(http.HandlerFunc(p1Handler))))
+	mux.Handle("/p2", tracing.Handler(sigaTracer, "p2", sessions.handler(http.HandlerFunc(p2Handler))))
+	mux.Handle("/mid", tracing.Handler(sigaTracer, "mid", sessions.handler(http.HandlerFunc(midHandler))))
+	mux.Handle("/mid/status", tracing.Handler(sigaTracer, "mid_status", sessions.handler(http.HandlerFunc(midStatusHandler))))
+	mux.Handle("/container", tracing.Handler(sigaTracer, "container", sessions.handler(http.HandlerFunc(containerHandler))))
+	mux.Handle("/download", tracing.Handler(sigaTracer, "download", sessions.handler(http.HandlerFunc(downloadHandler))))
+	// Arhiivi REST liides näitab ainult sirvikuseansi enda dokumente.
+	mux.Handle(archivePath, sessions.handler(http.HandlerFunc(archiveHandler)))
+	mux.Handle(archivePath+"/", sessions.handler(http.HandlerFunc(archiveHandler)))
+	// Mõõdikud ei ole kasutajatele mõeldud: need avaldatakse ainult siis, kui
+	// juurdepääsuloend lubab /metrics lugeda ainult kindlatel TLS-klientidel.
+	// Tühi loend lubaks kõik päringud.
+	if whitelist, ok := conf.AllowedClients.Lookup(http.MethodGet, "/metrics"); ok && len(whitelist) > 0 {
+		mux.Handle("/metrics", sigaMetrics)
+	} else {
+		log.Println("CreateServer: Juurdepääsuloend ei piira /metrics lugejaid, mõõdikud ei ole kättesaadavad")
+	}
+
+	srv := https.NewServer(conf, mux, log.Writer())
+	log.Println("CreateServer: HTTPS server loodud, aadress: ", srv.Addr)
+	return srv
 }
 
 // Märkmed
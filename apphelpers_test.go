@@ -0,0 +1,67 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/e-gov/SiGa-Go/https"
+)
+
+// metricsRequest teeb serverile srv /metrics päringu TLS-kliendina, kelle
+// serdi nimi on cn.
+func metricsRequest(srv *https.Server, cn string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	req.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{
+		{Subject: pkix.Name{CommonName: cn}},
+	}}}
+	rec := httptest.NewRecorder()
+	srv.Handler.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestCreateServer_NoAllowedClients_MetricsNotServed(t *testing.T) {
+	// given
+	srv := CreateServer(&https.ServerConf{})
+
+	// when
+	rec := metricsRequest(srv, "monitor.example.com")
+
+	// then
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("unexpected status: %d", rec.Code)
+	}
+}
+
+func TestCreateServer_AllowedClientsAllowAll_MetricsNotServed(t *testing.T) {
+	// given
+	srv := CreateServer(&https.ServerConf{AllowedClients: https.AccessControlList{
+		"*": {"*": https.Whitelist{}},
+	}})
+
+	// when
+	rec := metricsRequest(srv, "monitor.example.com")
+
+	// then
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("unexpected status: %d", rec.Code)
+	}
+}
+
+func TestCreateServer_AllowedClientsMetrics_MetricsServed(t *testing.T) {
+	// given
+	srv := CreateServer(&https.ServerConf{AllowedClients: https.AccessControlList{
+		"/metrics": {http.MethodGet: https.Whitelist{"monitor.example.com"}},
+	}})
+
+	// when
+	rec := metricsRequest(srv, "monitor.example.com")
+
+	// then
+	if rec.Code != http.StatusOK {
+		t.Errorf("unexpected status: %d", rec.Code)
+	}
+}
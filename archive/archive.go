@@ -0,0 +1,320 @@
+// Package archive stores signed containers together with their metadata.
+//
+// Each container is stored in the archive directory under a unique random
+// identifier as <id>.asice and its metadata as <id>.json. Every document has
+// an owner and is only visible to that owner: documents of other owners are
+// reported as not found.
+package archive
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// ErrNotFound is returned if there is no archived document with the
+// requested identifier and owner.
+var ErrNotFound = errors.New("document not found")
+
+// Method is the method used to sign an archived container.
+type Method string
+
+// Supported signing methods.
+const (
+	IDCard   Method = "idcard"
+	MobileID Method = "mid"
+)
+
+// Signer identifies the owner of a signature in an archived container.
+type Signer struct {
+	// CommonName is the common name on the signer's certificate, e.g.,
+	// "MÄNNIK,MARI-LIIS,61709210125".
+	CommonName string `json:"commonName"`
+
+	// SerialNumber is the serial number on the signer's certificate, e.g.,
+	// "PNOEE-61709210125".
+	SerialNumber string `json:"serialNumber,omitempty"`
+}
+
+// Document is the metadata of an archived container.
+type Document struct {
+	ID string `json:"id"`
+
+	// Owner identifies the owner of the document, e.g., the session which
+	// signed it.
+	Owner string `json:"owner"`
+
+	// DataFiles are the names of the data files in the container.
+	DataFiles []string `json:"dataFiles"`
+
+	// Signers are the owners of all signatures in the container, in the
+	// order of the signature files.
+	Signers []Signer `json:"signers"`
+
+	// SignedAt is the time when the container was archived after signing.
+	SignedAt time.Time `json:"signedAt"`
+
+	// Method is the method used to add the latest signature.
+	Method Method `json:"method"`
+
+	// Size is the size of the container in bytes.
+	Size int64 `json:"size"`
+}
+
+// idPattern matches valid document identifiers. Identifiers are used in file
+// names, so anything else must be rejected.
+var idPattern = regexp.MustCompile(`^[0-9a-f]{32}$`)
+
+const (
+	containerExt = ".asice"
+	metadataExt  = ".json"
+)
+
+// Archive is a directory of signed containers. It is safe for concurrent
+// use by multiple goroutines.
+type Archive struct {
+	dir string
+	now func() time.Time
+	mu  sync.RWMutex
+}
+
+// New opens the archive in directory dir, creating it if it does not exist.
+func New(dir string) (*Archive, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, errors.Wrap(err, "create archive directory")
+	}
+	return &Archive{dir: dir, now: time.Now}, nil
+}
+
+// Add stores the signed container of owner with a new unique identifier and
+// returns its metadata. The data files and signers are read from the
+// container.
+func (a *Archive) Add(owner string, method Method, container []byte) (*Document, error) {
+	if owner == "" {
+		return nil, errors.New("missing owner")
+	}
+	datafiles, signers, err := Inspect(container)
+	if err != nil {
+		return nil, err
+	}
+	id, err := newID()
+	if err != nil {
+		return nil, err
+	}
+	doc := &Document{
+		ID:        id,
+		Owner:     owner,
+		DataFiles: datafiles,
+		Signers:   signers,
+		SignedAt:  a.now().UTC(),
+		Method:    method,
+		Size:      int64(len(container)),
+	}
+	metadata, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	// The metadata file is written last: a document is only listed once
+	// its container has been stored.
+	if err := writeFile(a.path(id, containerExt), container); err != nil {
+		return nil, err
+	}
+	if err := writeFile(a.path(id, metadataExt), metadata); err != nil {
+		os.Remove(a.path(id, containerExt))
+		return nil, err
+	}
+	return doc, nil
+}
+
+// List returns the metadata of all archived documents of owner, the most
+// recently signed first.
+func (a *Archive) List(owner string) ([]*Document, error) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	infos, err := ioutil.ReadDir(a.dir)
+	if err != nil {
+		return nil, errors.Wrap(err, "read archive directory")
+	}
+	docs := []*Document{}
+	for _, info := range infos {
+		id := strings.TrimSuffix(info.Name(), metadataExt)
+		if info.IsDir() || id == info.Name() || !idPattern.MatchString(id) {
+			continue
+		}
+		doc, err := a.get(owner, id)
+		if err == ErrNotFound {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		docs = append(docs, doc)
+	}
+	sort.Slice(docs, func(i, j int) bool { return docs[i].SignedAt.After(docs[j].SignedAt) })
+	return docs, nil
+}
+
+// Get returns the metadata of the archived document id of owner.
+func (a *Archive) Get(owner, id string) (*Document, error) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return a.get(owner, id)
+}
+
+// Open opens the container of the archived document id of owner for
+// reading. The caller must close the returned file.
+func (a *Archive) Open(owner, id string) (*os.File, error) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	if _, err := a.get(owner, id); err != nil {
+		return nil, err
+	}
+	f, err := os.Open(a.path(id, containerExt))
+	if os.IsNotExist(err) {
+		return nil, ErrNotFound
+	}
+	return f, errors.Wrap(err, "open container")
+}
+
+// Delete removes the archived document id of owner.
+func (a *Archive) Delete(owner, id string) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if _, err := a.get(owner, id); err != nil {
+		return err
+	}
+	return a.remove(id)
+}
+
+// remove removes the files of document id. a.mu must be held for writing.
+func (a *Archive) remove(id string) error {
+	// Remove the metadata file first, so that a partially removed document
+	// is no longer listed.
+	if err := os.Remove(a.path(id, metadataExt)); err != nil {
+		if os.IsNotExist(err) {
+			return ErrNotFound
+		}
+		return errors.Wrap(err, "remove metadata")
+	}
+	if err := os.Remove(a.path(id, containerExt)); err != nil && !os.IsNotExist(err) {
+		return errors.Wrap(err, "remove container")
+	}
+	return nil
+}
+
+// Prune removes all archived documents for which keep returns false and
+// returns the number of removed documents. It is used to remove documents
+// which no owner can access anymore.
+func (a *Archive) Prune(keep func(doc *Document) bool) (int, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	infos, err := ioutil.ReadDir(a.dir)
+	if err != nil {
+		return 0, errors.Wrap(err, "read archive directory")
+	}
+	var removed int
+	for _, info := range infos {
+		id := strings.TrimSuffix(info.Name(), metadataExt)
+		if info.IsDir() || id == info.Name() || !idPattern.MatchString(id) {
+			continue
+		}
+		doc, err := a.read(id)
+		if err == ErrNotFound {
+			continue
+		}
+		if err != nil {
+			return removed, err
+		}
+		if keep(doc) {
+			continue
+		}
+		if err := a.remove(id); err != nil && err != ErrNotFound {
+			return removed, err
+		}
+		removed++
+	}
+	return removed, nil
+}
+
+// get returns the metadata of document id of owner. a.mu must be held.
+func (a *Archive) get(owner, id string) (*Document, error) {
+	doc, err := a.read(id)
+	if err != nil {
+		return nil, err
+	}
+	if doc.Owner == "" || doc.Owner != owner {
+		return nil, ErrNotFound
+	}
+	return doc, nil
+}
+
+// read returns the metadata of document id of any owner. a.mu must be held.
+func (a *Archive) read(id string) (*Document, error) {
+	if !idPattern.MatchString(id) {
+		return nil, ErrNotFound
+	}
+	data, err := ioutil.ReadFile(a.path(id, metadataExt))
+	if os.IsNotExist(err) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "read metadata")
+	}
+	var doc Document
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, errors.Wrapf(err, "parse metadata of %s", id)
+	}
+	return &doc, nil
+}
+
+func (a *Archive) path(id, ext string) string {
+	return filepath.Join(a.dir, id+ext)
+}
+
+// newID returns a new random document identifier.
+func newID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", errors.Wrap(err, "generate id")
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// writeFile writes data to a temporary file in the same directory and
+// renames it to name, so that readers never see a partially written file.
+func writeFile(name string, data []byte) error {
+	f, err := ioutil.TempFile(filepath.Dir(name), ".tmp-")
+	if err != nil {
+		return errors.Wrap(err, "create temporary file")
+	}
+	_, err = f.Write(data)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), name)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return errors.Wrapf(err, "write %s", filepath.Base(name))
+	}
+	return nil
+}
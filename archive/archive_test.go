@@ -0,0 +1,350 @@
+package archive
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// testCertificate returns a DER-encoded self-signed certificate with the
+// given subject.
+func testCertificate(t *testing.T, cn, serial string) []byte {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: cn, SerialNumber: serial},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return der
+}
+
+// testContainer returns an ASiC-E container with the given data files and
+// one signature file per signer certificate.
+func testContainer(t *testing.T, datafiles []string, certs ...[]byte) []byte {
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	write := func(header *zip.FileHeader, contents string) {
+		f, err := w.CreateHeader(header)
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.Write([]byte(contents))
+	}
+	write(&zip.FileHeader{Name: "mimetype", Method: zip.Store}, "application/vnd.etsi.asic-e+zip")
+	for _, name := range datafiles {
+		write(&zip.FileHeader{Name: name, Method: zip.Deflate}, "Tere")
+	}
+	for i, cert := range certs {
+		write(&zip.FileHeader{Name: fmt.Sprintf("META-INF/signatures%d.xml", i), Method: zip.Deflate},
+			`<asic:XAdESSignatures xmlns:asic="http://uri.etsi.org/02918/v1.2.1#" `+
+				`xmlns:ds="http://www.w3.org/2000/09/xmldsig#"><ds:Signature Id="S0">`+
+				`<ds:KeyInfo><ds:X509Data><ds:X509Certificate>`+
+				base64.StdEncoding.EncodeToString(cert)+
+				`</ds:X509Certificate></ds:X509Data></ds:KeyInfo></ds:Signature></asic:XAdESSignatures>`)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// owner is the owner of test documents.
+const owner = "sess1"
+
+// testArchive opens an archive in a new temporary directory. The caller
+// must remove the directory.
+func testArchive(t *testing.T) (*Archive, string) {
+	dir, err := ioutil.TempDir("", "archive")
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, err := New(dir)
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	return a, dir
+}
+
+func TestArchive_Add_MetadataFromContainer(t *testing.T) {
+	// given
+	a, dir := testArchive(t)
+	defer os.RemoveAll(dir)
+	container := testContainer(t, []string{"leping.pdf", "lisa.txt"},
+		testCertificate(t, "MÄNNIK,MARI-LIIS,61709210125", "PNOEE-61709210125"),
+		testCertificate(t, "TAMM,JAAN,38001085718", "PNOEE-38001085718"))
+
+	// when
+	doc, err := a.Add(owner, MobileID, container)
+
+	// then
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !idPattern.MatchString(doc.ID) {
+		t.Errorf("invalid id: %q", doc.ID)
+	}
+	if !reflect.DeepEqual(doc.DataFiles, []string{"leping.pdf", "lisa.txt"}) {
+		t.Errorf("unexpected data files: %v", doc.DataFiles)
+	}
+	expected := []Signer{
+		{CommonName: "MÄNNIK,MARI-LIIS,61709210125", SerialNumber: "PNOEE-61709210125"},
+		{CommonName: "TAMM,JAAN,38001085718", SerialNumber: "PNOEE-38001085718"},
+	}
+	if !reflect.DeepEqual(doc.Signers, expected) {
+		t.Errorf("unexpected signers: %v", doc.Signers)
+	}
+	if doc.Method != MobileID || doc.Size != int64(len(container)) || doc.SignedAt.IsZero() {
+		t.Errorf("unexpected metadata: %+v", doc)
+	}
+
+	stored, err := a.Get(owner, doc.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(stored, doc) {
+		t.Errorf("stored metadata differs: %+v", stored)
+	}
+	f, err := a.Open(owner, doc.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	contents, err := ioutil.ReadAll(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(contents, container) {
+		t.Error("stored container differs")
+	}
+}
+
+func TestArchive_AddSameContainerTwice_UniqueIDs(t *testing.T) {
+	// given
+	a, dir := testArchive(t)
+	defer os.RemoveAll(dir)
+	container := testContainer(t, []string{"fail.txt"}, testCertificate(t, "TAMM,JAAN,38001085718", ""))
+
+	// when
+	first, err := a.Add(owner, IDCard, container)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := a.Add(owner, IDCard, container)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// then
+	if first.ID == second.ID {
+		t.Fatalf("duplicate id: %s", first.ID)
+	}
+	docs, err := a.List(owner)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(docs) != 2 {
+		t.Errorf("unexpected number of documents: %d", len(docs))
+	}
+}
+
+func TestArchive_List_MostRecentFirst(t *testing.T) {
+	// given
+	a, dir := testArchive(t)
+	defer os.RemoveAll(dir)
+	container := testContainer(t, []string{"fail.txt"}, testCertificate(t, "TAMM,JAAN,38001085718", ""))
+	now := time.Date(2020, 5, 1, 12, 0, 0, 0, time.UTC)
+	var ids []string
+	for i := 0; i < 3; i++ {
+		a.now = func() time.Time { return now.Add(time.Duration(i) * time.Minute) }
+		doc, err := a.Add(owner, IDCard, container)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append([]string{doc.ID}, ids...)
+	}
+
+	// when
+	docs, err := a.List(owner)
+
+	// then
+	if err != nil {
+		t.Fatal(err)
+	}
+	var listed []string
+	for _, doc := range docs {
+		listed = append(listed, doc.ID)
+	}
+	if !reflect.DeepEqual(listed, ids) {
+		t.Errorf("unexpected order: %v, expected %v", listed, ids)
+	}
+}
+
+func TestArchive_Delete_NotFound(t *testing.T) {
+	// given
+	a, dir := testArchive(t)
+	defer os.RemoveAll(dir)
+	container := testContainer(t, []string{"fail.txt"}, testCertificate(t, "TAMM,JAAN,38001085718", ""))
+	doc, err := a.Add(owner, IDCard, container)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// when
+	err = a.Delete(owner, doc.ID)
+
+	// then
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := a.Get(owner, doc.ID); err != ErrNotFound {
+		t.Errorf("unexpected Get error: %v", err)
+	}
+	if _, err := a.Open(owner, doc.ID); err != ErrNotFound {
+		t.Errorf("unexpected Open error: %v", err)
+	}
+	if err := a.Delete(owner, doc.ID); err != ErrNotFound {
+		t.Errorf("unexpected second Delete error: %v", err)
+	}
+}
+
+func TestArchive_InvalidID_NotFound(t *testing.T) {
+	// given
+	a, dir := testArchive(t)
+	defer os.RemoveAll(dir)
+
+	// when
+	_, err := a.Open(owner, "../"+dir)
+
+	// then
+	if err != ErrNotFound {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestArchive_AddNotContainer_Error(t *testing.T) {
+	// given
+	a, dir := testArchive(t)
+	defer os.RemoveAll(dir)
+
+	// when
+	_, err := a.Add(owner, IDCard, []byte("Tere"))
+
+	// then
+	if err == nil {
+		t.Fatal("expected error")
+	}
+	if docs, _ := a.List(owner); len(docs) != 0 {
+		t.Errorf("invalid container archived: %v", docs)
+	}
+}
+
+func TestArchive_OtherOwner_NotFound(t *testing.T) {
+	// given
+	a, dir := testArchive(t)
+	defer os.RemoveAll(dir)
+	container := testContainer(t, []string{"fail.txt"}, testCertificate(t, "TAMM,JAAN,38001085718", ""))
+	doc, err := a.Add(owner, IDCard, container)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const other = "sess2"
+
+	// when
+	docs, err := a.List(other)
+
+	// then
+	if err != nil || len(docs) != 0 {
+		t.Errorf("unexpected documents of other owner: %v %v", docs, err)
+	}
+	if _, err := a.Get(other, doc.ID); err != ErrNotFound {
+		t.Errorf("unexpected Get error: %v", err)
+	}
+	if _, err := a.Open(other, doc.ID); err != ErrNotFound {
+		t.Errorf("unexpected Open error: %v", err)
+	}
+	if err := a.Delete(other, doc.ID); err != ErrNotFound {
+		t.Errorf("unexpected Delete error: %v", err)
+	}
+	if _, err := a.Get(owner, doc.ID); err != nil {
+		t.Errorf("document of owner removed: %v", err)
+	}
+}
+
+func TestArchive_Prune_RemovesNotKept(t *testing.T) {
+	// given
+	a, dir := testArchive(t)
+	defer os.RemoveAll(dir)
+	container := testContainer(t, []string{"fail.txt"}, testCertificate(t, "TAMM,JAAN,38001085718", ""))
+	kept, err := a.Add(owner, IDCard, container)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const orphan = "sess2"
+	pruned, err := a.Add(orphan, MobileID, container)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// when
+	removed, err := a.Prune(func(doc *Document) bool { return doc.Owner != orphan })
+
+	// then
+	if err != nil || removed != 1 {
+		t.Fatalf("unexpected result: %d %v", removed, err)
+	}
+	if _, err := a.Get(orphan, pruned.ID); err != ErrNotFound {
+		t.Errorf("pruned document not removed: %v", err)
+	}
+	if _, err := os.Stat(a.path(pruned.ID, containerExt)); !os.IsNotExist(err) {
+		t.Errorf("pruned container not removed: %v", err)
+	}
+	if _, err := a.Get(owner, kept.ID); err != nil {
+		t.Errorf("kept document removed: %v", err)
+	}
+}
+
+func TestInspect_ASiCS_ErrUnsupportedContainer(t *testing.T) {
+	// given
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	for _, name := range []string{"mimetype", "leping.pdf"} {
+		f, err := w.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Store})
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.Write([]byte("application/vnd.etsi.asic-s+zip"))
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// when
+	_, _, err := Inspect(buf.Bytes())
+
+	// then
+	if !errors.Is(err, ErrUnsupportedContainer) {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
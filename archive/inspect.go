@@ -0,0 +1,100 @@
+package archive
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/xml"
+	"io/ioutil"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/e-gov/SiGa-Go/siga"
+)
+
+// xadesSignatures is a signature file of an ASiC-E container. Only the
+// fields we are interested in are unmarshaled.
+type xadesSignatures struct {
+	Signatures []struct {
+		Certificates []string `xml:"KeyInfo>X509Data>X509Certificate"`
+	} `xml:"Signature"`
+}
+
+// ErrUnsupportedContainer is returned by Inspect and Archive.Add for
+// complete signature containers other than ASiC-E.
+var ErrUnsupportedContainer = errors.New("unsupported container type")
+
+// Inspect returns the names of the data files in the ASiC-E container and the
+// signers of its signatures. It does not validate the signatures.
+func Inspect(container []byte) ([]string, []Signer, error) {
+	info, err := siga.InspectContainer(bytes.NewReader(container))
+	if err != nil {
+		return nil, nil, errors.WithMessage(err, "inspect container")
+	}
+	if info.Type != siga.ASiCE {
+		return nil, nil, errors.Wrapf(ErrUnsupportedContainer, "%s", info.Type)
+	}
+
+	reader, err := zip.NewReader(bytes.NewReader(container), int64(len(container)))
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "open zip")
+	}
+	signatureFiles := make(map[string]bool, len(info.Signatures))
+	for _, name := range info.Signatures {
+		signatureFiles[name] = true
+	}
+	signers := []Signer{}
+	for _, file := range reader.File {
+		if !signatureFiles[file.Name] || !strings.HasSuffix(file.Name, ".xml") {
+			continue
+		}
+		fileSigners, err := readSigners(file)
+		if err != nil {
+			return nil, nil, err
+		}
+		signers = append(signers, fileSigners...)
+	}
+	return info.DataFiles, signers, nil
+}
+
+// readSigners returns the signers of the signatures in the XAdES signature
+// file. The signer of a signature is the subject of the first certificate in
+// its KeyInfo.
+func readSigners(file *zip.File) ([]Signer, error) {
+	r, err := file.Open()
+	if err != nil {
+		return nil, errors.Wrapf(err, "open %s", file.Name)
+	}
+	defer r.Close()
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, errors.Wrapf(err, "read %s", file.Name)
+	}
+
+	var signatures xadesSignatures
+	if err := xml.Unmarshal(data, &signatures); err != nil {
+		return nil, errors.Wrapf(err, "parse %s", file.Name)
+	}
+	var signers []Signer
+	for _, signature := range signatures.Signatures {
+		if len(signature.Certificates) == 0 {
+			return nil, errors.Errorf("%s: signature without certificate", file.Name)
+		}
+		// Certificates may be wrapped on multiple lines.
+		der, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(signature.Certificates[0]), ""))
+		if err != nil {
+			return nil, errors.Wrapf(err, "%s: decode certificate", file.Name)
+		}
+		cert, err := x509.ParseCertificate(der)
+		if err != nil {
+			return nil, errors.Wrapf(err, "%s: parse certificate", file.Name)
+		}
+		signers = append(signers, Signer{
+			CommonName:   cert.Subject.CommonName,
+			SerialNumber: cert.Subject.SerialNumber,
+		})
+	}
+	return signers, nil
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"mime"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/e-gov/SiGa-Go/archive"
+)
+
+// archivePath on arhiivi REST liidese tee.
+const archivePath = "/archive"
+
+// archiveDocument on arhiveeritud dokumendi JSON kuju vastuses: metaandmed
+// ja konteineri allalaadimise aadress.
+type archiveDocument struct {
+	*archive.Document
+	Container string `json:"container"`
+}
+
+func newArchiveDocument(doc *archive.Document) archiveDocument {
+	return archiveDocument{Document: doc, Container: archiveURL(doc.ID) + "/container"}
+}
+
+// archiveURL tagastab arhiveeritud dokumendi id aadressi.
+func archiveURL(id string) string {
+	return archivePath + "/" + id
+}
+
+// archiveSigned salvestab sirvikuseansis allkirjastamisviisiga kind
+// allkirjastatud konteineri arhiivi ja tagastab arhiveeritud dokumendi
+// aadressi. Allkirjastamine on selleks ajaks õnnestunud, seepärast vead
+// ainult logitakse ja tagastatakse tühi aadress.
+func archiveSigned(ctx context.Context, sess *session, kind string) string {
+	if signedArchive == nil {
+		return ""
+	}
+	var buf bytes.Buffer
+	if err := sigaClient.WriteContainer(ctx, sess.sigaSession(kind), &buf); err != nil {
+		log.Println("archiveSigned: WriteContainer: ", err)
+		return ""
+	}
+	doc, err := signedArchive.Add(sess.sigaID, archive.Method(kind), buf.Bytes())
+	if err != nil {
+		log.Println("archiveSigned: Konteineri arhiveerimine ebaõnnestus: ", err)
+		return ""
+	}
+	log.Println("archiveSigned: Konteiner arhiveeritud: ", doc.ID)
+	return archiveURL(doc.ID)
+}
+
+// archivePruneInterval on omanikuta dokumentide arhiivist eemaldamise
+// intervall.
+const archivePruneInterval = 10 * time.Minute
+
+// pruneArchive eemaldab arhiivist dokumendid, mille omanikseanssi seansihoidlas
+// store enam ei ole (seanss aegus või server käivitati uuesti): neid ei saa
+// keegi enam loendada, alla laadida ega kustutada. Dokumente, mis arhiveeriti
+// pärast seansside loendamist, ei eemaldata, sest nende seanss võib olla
+// loodud hiljem.
+func pruneArchive(store *sessionStore) {
+	if signedArchive == nil {
+		return
+	}
+	since := time.Now()
+	owners := store.sigaIDs()
+	removed, err := signedArchive.Prune(func(doc *archive.Document) bool {
+		return owners[doc.Owner] || !doc.SignedAt.Before(since)
+	})
+	if err != nil {
+		log.Println("pruneArchive: Omanikuta dokumentide eemaldamine ebaõnnestus: ", err)
+	}
+	if removed > 0 {
+		log.Println("pruneArchive: Eemaldatud omanikuta dokumente: ", removed)
+	}
+}
+
+// runArchivePruning eemaldab arhiivist omanikuta dokumendid kohe ja seejärel
+// iga interval järel, kuni ctx lõpeb.
+func runArchivePruning(ctx context.Context, store *sessionStore, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		pruneArchive(store)
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// archiveHandler teenindab arhiivi REST liidest:
+//
+//	GET    /archive                  arhiveeritud dokumentide loend
+//	GET    /archive/{id}             dokumendi metaandmed
+//	GET    /archive/{id}/container   allkirjastatud konteiner
+//	DELETE /archive/{id}             dokumendi kustutamine
+//
+// Liides töötab päringu sirvikuseansis: seansile on nähtavad ainult selles
+// seansis arhiveeritud dokumendid, teiste seansside dokumentide kohta
+// vastatakse, et dokumenti ei leitud. Pärast seansi aegumist eemaldatakse
+// selle dokumendid arhiivist (vt pruneArchive).
+func archiveHandler(w http.ResponseWriter, req *http.Request) {
+	rest := strings.Trim(strings.TrimPrefix(req.URL.Path, archivePath), "/")
+	parts := strings.Split(rest, "/")
+	switch {
+	case rest == "":
+		listArchive(w, req)
+	case len(parts) == 1:
+		archivedDocument(w, req, parts[0])
+	case len(parts) == 2 && parts[1] == "container":
+		archivedContainer(w, req, parts[0])
+	default:
+		writeError(w, req, "archiveHandler", newAPIError(http.StatusNotFound, codeDocumentNotFound))
+	}
+}
+
+// listArchive saadab sirvikuseansi arhiveeritud dokumentide metaandmed,
+// viimati allkirjastatud esimesena.
+func listArchive(w http.ResponseWriter, req *http.Request) {
+	if err := requireMethod(w, req, http.MethodGet); err != nil {
+		writeError(w, req, "listArchive", err)
+		return
+	}
+	docs, err := signedArchive.List(sessionFromContext(req.Context()).sigaID)
+	if err != nil {
+		writeError(w, req, "listArchive", err)
+		return
+	}
+	var resp struct {
+		Documents []archiveDocument `json:"documents"`
+	}
+	resp.Documents = make([]archiveDocument, 0, len(docs))
+	for _, doc := range docs {
+		resp.Documents = append(resp.Documents, newArchiveDocument(doc))
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// archivedDocument saadab dokumendi id metaandmed või kustutab dokumendi.
+func archivedDocument(w http.ResponseWriter, req *http.Request, id string) {
+	if err := requireMethod(w, req, http.MethodGet, http.MethodDelete); err != nil {
+		writeError(w, req, "archivedDocument", err)
+		return
+	}
+	owner := sessionFromContext(req.Context()).sigaID
+	if req.Method == http.MethodDelete {
+		if err := signedArchive.Delete(owner, id); err != nil {
+			writeError(w, req, "archivedDocument", err)
+			return
+		}
+		log.Println("archivedDocument: Dokument kustutatud: ", id)
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	doc, err := signedArchive.Get(owner, id)
+	if err != nil {
+		writeError(w, req, "archivedDocument", err)
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, http.StatusOK, newArchiveDocument(doc))
+}
+
+// archivedContainer saadab dokumendi id allkirjastatud konteineri.
+func archivedContainer(w http.ResponseWriter, req *http.Request, id string) {
+	if err := requireMethod(w, req, http.MethodGet, http.MethodHead); err != nil {
+		writeError(w, req, "archivedContainer", err)
+		return
+	}
+	f, err := signedArchive.Open(sessionFromContext(req.Context()).sigaID, id)
+	if err != nil {
+		writeError(w, req, "archivedContainer", err)
+		return
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		writeError(w, req, "archivedContainer", err)
+		return
+	}
+
+	name := id + ".asice"
+	header := w.Header()
+	header.Set("Content-Type", asiceMediaType)
+	header.Set("Content-Disposition", mime.FormatMediaType(
+		"attachment", map[string]string{"filename": name}))
+	header.Set("Cache-Control", "no-store")
+	header.Set("X-Content-Type-Options", "nosniff")
+	http.ServeContent(w, req, name, info.ModTime(), f)
+}
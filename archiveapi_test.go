@@ -0,0 +1,202 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/e-gov/SiGa-Go/archive"
+)
+
+// testASiCE tagastab allkirjadeta ASiC-E konteineri failiga fail.txt.
+func testASiCE(t *testing.T) []byte {
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	for _, file := range [][2]string{
+		{"mimetype", "application/vnd.etsi.asic-e+zip"},
+		{textFileName, "Tere"},
+	} {
+		f, err := w.CreateHeader(&zip.FileHeader{Name: file[0], Method: zip.Store})
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.Write([]byte(file[1]))
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// useTestArchive seab signedArchive-ks ajutises kaustas oleva arhiivi. Kutsuja
+// peab tagastatud funktsiooniga arhiivi eemaldama.
+func useTestArchive(t *testing.T) func() {
+	dir, err := ioutil.TempDir("", "archive")
+	if err != nil {
+		t.Fatal(err)
+	}
+	signedArchive, err = archive.New(dir)
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	return func() {
+		signedArchive = nil
+		os.RemoveAll(dir)
+	}
+}
+
+// archiveRequest teeb arhiivi REST liidese päringu seansi sess nimel.
+func archiveRequest(store *sessionStore, sess *session, method, target string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, nil)
+	return sessionRequest(store, sess, http.HandlerFunc(archiveHandler), req)
+}
+
+func TestArchiveSigned_SignedContainer_ListFetchDelete(t *testing.T) {
+	// given
+	defer useTestArchive(t)()
+	container := testASiCE(t)
+	defer withSigaClient(&fakeClient{container: container})()
+	store := newSessionStore(time.Minute, nil)
+	sess, _ := store.get("")
+
+	// when
+	url := archiveSigned(context.Background(), sess, "mid")
+
+	// then
+	if url == "" {
+		t.Fatal("container not archived")
+	}
+	rec := archiveRequest(store, sess, http.MethodGet, archivePath)
+	var list struct {
+		Documents []archiveDocument `json:"documents"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&list); err != nil {
+		t.Fatal(err)
+	}
+	if len(list.Documents) != 1 {
+		t.Fatalf("unexpected documents: %v", list.Documents)
+	}
+	doc := list.Documents[0]
+	if archiveURL(doc.ID) != url || doc.Method != archive.MobileID ||
+		len(doc.DataFiles) != 1 || doc.DataFiles[0] != textFileName {
+		t.Errorf("unexpected document: %+v", doc.Document)
+	}
+
+	rec = archiveRequest(store, sess, http.MethodGet, doc.Container)
+	if rec.Code != http.StatusOK || !bytes.Equal(rec.Body.Bytes(), container) {
+		t.Errorf("unexpected container response: %d", rec.Code)
+	}
+	if ct := rec.Header().Get("Content-Type"); ct != asiceMediaType {
+		t.Errorf("unexpected Content-Type: %s", ct)
+	}
+
+	if rec = archiveRequest(store, sess, http.MethodDelete, url); rec.Code != http.StatusNoContent {
+		t.Errorf("unexpected delete status: %d", rec.Code)
+	}
+	rec = archiveRequest(store, sess, http.MethodGet, url)
+	var resp errorEnvelope
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	if rec.Code != http.StatusNotFound || resp.Error == nil || resp.Error.Code != codeDocumentNotFound {
+		t.Errorf("unexpected response after delete: %d %v", rec.Code, resp.Error)
+	}
+}
+
+func TestArchiveHandler_OtherSession_NotFound(t *testing.T) {
+	// given
+	defer useTestArchive(t)()
+	defer withSigaClient(&fakeClient{container: testASiCE(t)})()
+	store := newSessionStore(time.Minute, nil)
+	owner, _ := store.get("")
+	other, _ := store.get("")
+	url := archiveSigned(context.Background(), owner, "idcard")
+
+	// when
+	list := archiveRequest(store, other, http.MethodGet, archivePath)
+	fetch := archiveRequest(store, other, http.MethodGet, url+"/container")
+	remove := archiveRequest(store, other, http.MethodDelete, url)
+
+	// then
+	if list.Code != http.StatusOK || bytes.Contains(list.Body.Bytes(), []byte(url)) {
+		t.Errorf("other session's document listed: %s", list.Body)
+	}
+	if fetch.Code != http.StatusNotFound || remove.Code != http.StatusNotFound {
+		t.Errorf("unexpected responses: fetch %d, delete %d", fetch.Code, remove.Code)
+	}
+	if _, err := signedArchive.Get(owner.sigaID, strings.TrimPrefix(url, archivePath+"/")); err != nil {
+		t.Errorf("document removed by other session: %v", err)
+	}
+}
+
+func TestPruneArchive_SessionExpired_DocumentsRemoved(t *testing.T) {
+	// given
+	defer useTestArchive(t)()
+	defer withSigaClient(&fakeClient{container: testASiCE(t)})()
+	now := time.Now()
+	store := newSessionStore(time.Minute, nil)
+	store.now = func() time.Time { return now }
+	expired, _ := store.get("")
+	live, _ := store.get("")
+	expiredURL := archiveSigned(context.Background(), expired, "mid")
+	liveURL := archiveSigned(context.Background(), live, "idcard")
+	now = now.Add(50 * time.Second)
+	store.get(live.token)
+	now = now.Add(20 * time.Second)
+	store.sweep()
+
+	// when
+	again := archiveRequest(store, expired, http.MethodGet, expiredURL)
+	pruneArchive(store)
+
+	// then
+	if again.Code != http.StatusNotFound {
+		t.Errorf("document of expired session accessible: %d", again.Code)
+	}
+	expiredID := strings.TrimPrefix(expiredURL, archivePath+"/")
+	if _, err := signedArchive.Get(expired.sigaID, expiredID); err != archive.ErrNotFound {
+		t.Errorf("document of expired session not removed: %v", err)
+	}
+	if rec := archiveRequest(store, live, http.MethodGet, liveURL); rec.Code != http.StatusOK {
+		t.Errorf("document of live session removed: %d", rec.Code)
+	}
+}
+
+func TestArchiveHandler_Post_MethodNotAllowed(t *testing.T) {
+	// given
+	defer useTestArchive(t)()
+	store := newSessionStore(time.Minute, nil)
+	sess, _ := store.get("")
+
+	// when
+	rec := archiveRequest(store, sess, http.MethodPost, archivePath)
+
+	// then
+	if rec.Code != http.StatusMethodNotAllowed || rec.Header().Get("Allow") != http.MethodGet {
+		t.Errorf("unexpected response: %d %v", rec.Code, rec.Header())
+	}
+}
+
+func TestArchiveHandler_UnknownPath_NotFound(t *testing.T) {
+	// given
+	defer useTestArchive(t)()
+	store := newSessionStore(time.Minute, nil)
+	sess, _ := store.get("")
+
+	// when
+	rec := archiveRequest(store, sess, http.MethodGet, archivePath+"/../certs/localhost.key")
+
+	// then
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("unexpected status: %d", rec.Code)
+	}
+}
@@ -1,23 +0,0 @@
-/* func TestClient_UploadContainer_Succeeds(t *testing.T) {
-	// given
-	c := TestClient(t)
-	defer c.Close()
-
-	ctx := context.Background()
-	const session = "TestClient_UploadContainer_Succeeds"
-	container, err := os.Open("allkirjad/mobile-id.asice")
-	if err != nil {
-		t.Fatal(err) // Will fail if TestClient_MobileIDSigning_Succeeds was skipped.
-	}
-	defer container.Close()
-
-	// when
-	err = c.UploadContainer(ctx, session, container)
-	defer c.CloseContainer(ctx, session) // Attempt to clean-up SiGa regardless.
-
-	// then
-	if err != nil {
-		t.Fatal("upload container:", err)
-	}
-}
-*/
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"log"
+	"net/http"
+
+	"github.com/e-gov/SiGa-Go/archive"
+	"github.com/e-gov/SiGa-Go/siga"
+)
+
+// containerField on üles laaditavat konteinerit sisaldava multipart välja
+// nimi.
+const containerField = "konteiner"
+
+// containerContents on üles laaditud konteineri sisu kirjeldus vastuses.
+type containerContents struct {
+	DataFiles []string         `json:"dataFiles"`
+	Signers   []archive.Signer `json:"signers"`
+}
+
+// containerHandler võtab sirvikust vastu olemasoleva ASiC-E konteineri
+// (POST, multipart/form-data väli konteiner), jätab selle sirvikuseansis
+// meelde ja saadab sirvikule konteineri andmefailide nimed ja
+// allkirjastajad. Seejärel saab konteinerile lisada allkirja ID-kaardiga või
+// m-ID-ga, saates allkirjastamise alustamise päringus "konteiner": true (vt
+// prepareContainer). DELETE eemaldab konteineri seansist.
+func containerHandler(w http.ResponseWriter, req *http.Request) {
+	if err := requireMethod(w, req, http.MethodPost, http.MethodDelete); err != nil {
+		writeError(w, req, "containerHandler", err)
+		return
+	}
+	sess := sessionFromContext(req.Context())
+	if req.Method == http.MethodDelete {
+		sessions.setContainer(sess, nil)
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	name, container, err := readContainerUpload(w, req)
+	if err != nil {
+		writeError(w, req, "containerHandler", err)
+		return
+	}
+	datafiles, signers, err := archive.Inspect(container)
+	if err != nil {
+		if errors.Is(err, archive.ErrUnsupportedContainer) {
+			err = newAPIError(http.StatusBadRequest, codeUnsupportedContainer).withCause(err)
+		} else {
+			err = newAPIError(http.StatusBadRequest, codeInvalidContainer, name).withCause(err)
+		}
+		writeError(w, req, "containerHandler", err)
+		return
+	}
+	// Konteinerit hoitakse mälus seansi aegumiseni, kõigi seansside
+	// konteinerite kogumaht on piiratud (vt maxContainerBytes).
+	if err := sessions.setContainer(sess, container); err != nil {
+		writeError(w, req, "containerHandler", err)
+		return
+	}
+	log.Println("containerHandler: Konteiner üles laaditud: ", name,
+		", andmefaile: ", len(datafiles), ", allkirju: ", len(signers))
+
+	writeJSON(w, http.StatusOK, containerContents{DataFiles: datafiles, Signers: signers})
+}
+
+// readContainerUpload loeb multipart/form-data päringu väljast containerField
+// üles laaditud konteineri nime ja sisu.
+func readContainerUpload(w http.ResponseWriter, req *http.Request) (string, []byte, error) {
+	if !isMultipart(req) {
+		return "", nil, newAPIError(http.StatusUnsupportedMediaType,
+			codeUnsupportedMediaType, req.Header.Get("Content-Type"))
+	}
+	if err := parseMultipart(w, req); err != nil {
+		return "", nil, err
+	}
+	defer req.MultipartForm.RemoveAll()
+
+	files := req.MultipartForm.File[containerField]
+	if len(files) != 1 {
+		return "", nil, newAPIError(http.StatusBadRequest, codeInvalidForm)
+	}
+	fh := files[0]
+	name := uploadFileName(fh.Filename)
+	if fh.Size > maxUploadFileSize {
+		return "", nil, newAPIError(http.StatusRequestEntityTooLarge,
+			codeFileTooLarge, name, fh.Size, maxUploadFileSize)
+	}
+	f, err := fh.Open()
+	if err != nil {
+		return "", nil, err
+	}
+	defer f.Close()
+	container, err := ioutil.ReadAll(f)
+	if err != nil {
+		return "", nil, newAPIError(http.StatusBadRequest, codeInvalidFile, name).withCause(err)
+	}
+	return name, container, nil
+}
+
+// prepareContainer koostab sirvikuseansi allkirjastamisviisi kind SiGa
+// seansis allkirjastatava konteineri: failidest datafiles uue konteineri
+// (CreateContainer) või, kui faile ei ole, sirvikuseansis üles laaditud
+// konteineri (UploadContainer), millele allkiri lisatakse.
+func prepareContainer(ctx context.Context, sess *session, kind string, datafiles []*siga.DataFile) error {
+	session := sess.sigaSession(kind)
+	if len(datafiles) > 0 {
+		if err := sigaClient.CreateContainer(ctx, session, datafiles...); err != nil {
+			return sigaError(err)
+		}
+		return nil
+	}
+
+	container := sess.uploadedContainer()
+	if container == nil {
+		return newAPIError(http.StatusConflict, codeNoContainer)
+	}
+	if err := sigaClient.UploadContainer(ctx, session, bytes.NewReader(container)); err != nil {
+		return sigaError(err)
+	}
+	return nil
+}
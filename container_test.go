@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// uploadContainer laadib seansi sess nimel üles konteineri failinimega name.
+func uploadContainer(t *testing.T, store *sessionStore, sess *session, name string, container []byte) *httptest.ResponseRecorder {
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	fw, err := mw.CreateFormFile(containerField, name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fw.Write(container)
+	if err := mw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest(http.MethodPost, "/container", &body)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	return sessionRequest(store, sess, http.HandlerFunc(containerHandler), req)
+}
+
+func TestContainerHandler_ASiCE_ContentsAndStoredInSession(t *testing.T) {
+	// given
+	store := newSessionStore(time.Minute, nil)
+	sess, _ := store.get("")
+	container := testASiCE(t)
+
+	// when
+	rec := uploadContainer(t, store, sess, "leping.asice", container)
+
+	// then
+	if rec.Code != http.StatusOK {
+		t.Fatalf("unexpected status: %d %s", rec.Code, rec.Body)
+	}
+	var contents containerContents
+	if err := json.NewDecoder(rec.Body).Decode(&contents); err != nil {
+		t.Fatal(err)
+	}
+	if len(contents.DataFiles) != 1 || contents.DataFiles[0] != textFileName || len(contents.Signers) != 0 {
+		t.Errorf("unexpected contents: %+v", contents)
+	}
+	if !bytes.Equal(sess.uploadedContainer(), container) {
+		t.Error("container not stored in session")
+	}
+}
+
+func TestContainerHandler_NotContainer_BadRequest(t *testing.T) {
+	// given
+	store := newSessionStore(time.Minute, nil)
+	sess, _ := store.get("")
+
+	// when
+	rec := uploadContainer(t, store, sess, "leping.pdf", []byte("%PDF-1.4"))
+
+	// then
+	var resp errorEnvelope
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	if rec.Code != http.StatusBadRequest || resp.Error == nil || resp.Error.Code != codeInvalidContainer {
+		t.Errorf("unexpected response: %d %v", rec.Code, resp.Error)
+	}
+	if sess.uploadedContainer() != nil {
+		t.Error("invalid container stored in session")
+	}
+}
+
+func TestMidHandler_UploadedContainer_SignatureAddedToContainer(t *testing.T) {
+	// given
+	client := &fakeClient{polls: 1}
+	defer withSigaClient(client)()
+	store := newSessionStore(time.Minute, nil)
+	sess, _ := store.get("")
+	container := testASiCE(t)
+	uploadContainer(t, store, sess, "leping.asice", container)
+
+	// when
+	code, start := midRequest(t, store, sess, midHandler, http.MethodPost,
+		`{"isikukood": "60001019906", "nr": "+37200000766", "konteiner": true}`)
+	_, status := midRequest(t, store, sess, midStatusHandler, http.MethodGet, "")
+
+	// then
+	if code != http.StatusOK || start.Challenge != "1234" {
+		t.Fatalf("unexpected start response: %d %+v", code, start)
+	}
+	if !bytes.Equal(client.uploaded, container) {
+		t.Error("uploaded container not sent to SiGa")
+	}
+	if status.Status != midSigned {
+		t.Errorf("unexpected status: %+v", status)
+	}
+}
+
+func TestMidHandler_NoUploadedContainer_Conflict(t *testing.T) {
+	// given
+	defer withSigaClient(&fakeClient{})()
+	store := newSessionStore(time.Minute, nil)
+	sess, _ := store.get("")
+
+	// when
+	code, resp := midRequest(t, store, sess, midHandler, http.MethodPost,
+		`{"isikukood": "60001019906", "nr": "+37200000766", "konteiner": true}`)
+
+	// then
+	if code != http.StatusConflict || resp.Error == nil || resp.Error.Code != codeNoContainer {
+		t.Errorf("unexpected response: %d %+v", code, resp)
+	}
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+)
+
+// asiceMediaType on ASiC-E allkirjakonteineri meediatüüp.
+const asiceMediaType = "application/vnd.etsi.asic-e+zip"
+
+// downloadFileName on allalaaditava allkirjakonteineri failinimi.
+const downloadFileName = "allkirjastatud.asice"
+
+// downloadHandler saadab sirvikule kasutaja seansis allkirjastatud
+// konteineri. Allkirjastamisviis antakse päringuparameetris viis ("idcard"
+// või "mid"). Konteiner voogedastatakse WriteContainer-ist otse vastusesse.
+func downloadHandler(w http.ResponseWriter, req *http.Request) {
+	if err := requireMethod(w, req, http.MethodGet); err != nil {
+		writeError(w, req, "downloadHandler", err)
+		return
+	}
+
+	kind := req.URL.Query().Get("viis")
+	if !validSigaSessionKind(kind) {
+		writeError(w, req, "downloadHandler",
+			newAPIError(http.StatusBadRequest, codeInvalidSigningKind, kind))
+		return
+	}
+
+	ctx := req.Context()
+	sess := sessionFromContext(ctx)
+	if sess.state(kind) != signingSigned {
+		writeError(w, req, "downloadHandler", newAPIError(http.StatusNotFound, codeNotSigned))
+		return
+	}
+
+	// Päised saadetakse alles konteineri esimese baidi kirjutamisel, nii et
+	// kuni selleni saab sirvikule veel veateate saata.
+	dw := &downloadWriter{w: w}
+	if err := sigaClient.WriteContainer(ctx, sess.sigaSession(kind), dw); err != nil {
+		if !dw.started {
+			writeError(w, req, "downloadHandler", sigaError(err))
+			return
+		}
+		log.Println("downloadHandler: WriteContainer: ", err)
+		return
+	}
+	log.Println("downloadHandler: Konteiner saadetud sirvikusse")
+}
+
+// downloadWriter kirjutab allalaaditava konteineri vastusesse. Esimesel
+// kirjutamisel seab see vastuse päised.
+type downloadWriter struct {
+	w       http.ResponseWriter
+	started bool
+}
+
+func (d *downloadWriter) Write(p []byte) (int, error) {
+	if !d.started {
+		d.started = true
+		header := d.w.Header()
+		header.Set("Content-Type", asiceMediaType)
+		header.Set("Content-Disposition", mime.FormatMediaType(
+			"attachment", map[string]string{"filename": downloadFileName}))
+		header.Set("Cache-Control", "no-store")
+		header.Set("X-Content-Type-Options", "nosniff")
+		d.w.WriteHeader(http.StatusOK)
+	}
+	return d.w.Write(p)
+}
+
+// downloadURL tagastab allkirjastamisviisi kind konteineri allalaadimise
+// aadressi.
+func downloadURL(kind string) string {
+	return "/download?viis=" + url.QueryEscape(kind)
+}
+
+// validSigaSessionKind teatab, kas kind on tuntud allkirjastamisviis.
+func validSigaSessionKind(kind string) bool {
+	for _, k := range sigaSessionKinds {
+		if k == kind {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// download teeb allalaadimispäringu seansi sess nimel.
+func download(store *sessionStore, sess *session, kind string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, downloadURL(kind), nil)
+	return sessionRequest(store, sess, http.HandlerFunc(downloadHandler), req)
+}
+
+func TestDownloadHandler_Signed_StreamsContainer(t *testing.T) {
+	// given
+	defer withSigaClient(&fakeClient{})()
+	store := newSessionStore(time.Minute, nil)
+	sess, _ := store.get("")
+	sess.setState("idcard", signingSigned)
+
+	// when
+	rec := download(store, sess, "idcard")
+
+	// then
+	if rec.Code != http.StatusOK {
+		t.Fatalf("unexpected status: %d", rec.Code)
+	}
+	if ct := rec.Header().Get("Content-Type"); ct != asiceMediaType {
+		t.Errorf("unexpected Content-Type: %s", ct)
+	}
+	if cd := rec.Header().Get("Content-Disposition"); cd != `attachment; filename=allkirjastatud.asice` {
+		t.Errorf("unexpected Content-Disposition: %s", cd)
+	}
+	if body := rec.Body.String(); body != sess.sigaSession("idcard") {
+		t.Errorf("unexpected container: %s", body)
+	}
+}
+
+func TestDownloadHandler_OtherSession_NotFound(t *testing.T) {
+	// given
+	defer withSigaClient(&fakeClient{})()
+	store := newSessionStore(time.Minute, nil)
+	signer, _ := store.get("")
+	signer.setState("mid", signingSigned)
+	other, _ := store.get("")
+
+	// when
+	rec := download(store, other, "mid")
+
+	// then
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("unexpected status: %d", rec.Code)
+	}
+}
+
+func TestDownloadHandler_UnknownKind_BadRequest(t *testing.T) {
+	// given
+	defer withSigaClient(&fakeClient{})()
+	store := newSessionStore(time.Minute, nil)
+	sess, _ := store.get("")
+
+	// when
+	rec := download(store, sess, "paber")
+
+	// then
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("unexpected status: %d", rec.Code)
+	}
+}
+
+func TestDownloadHandler_WriteContainerError_BadGateway(t *testing.T) {
+	// given
+	defer withSigaClient(&fakeClient{writeErr: errors.New("siga unavailable")})()
+	store := newSessionStore(time.Minute, nil)
+	sess, _ := store.get("")
+	sess.setState("idcard", signingSigned)
+
+	// when
+	rec := download(store, sess, "idcard")
+
+	// then
+	if rec.Code != http.StatusBadGateway {
+		t.Errorf("unexpected status: %d", rec.Code)
+	}
+	if ct := rec.Header().Get("Content-Type"); ct == asiceMediaType {
+		t.Error("container headers sent with error response")
+	}
+}
@@ -0,0 +1,152 @@
+package https
+
+import (
+	"log"
+	"net/http"
+	"strings"
+)
+
+// Wildcard matches any path, method, or common name in an AccessControlList.
+// It can also be used as part of a pattern, e.g., "/api/*" or "*.example.com",
+// where it matches any sequence of characters.
+const Wildcard = "*"
+
+// AccessDenial describes an HTTP request which was denied by an
+// AccessControlList.
+type AccessDenial struct {
+	Method     string
+	Path       string
+	RemoteAddr string
+	// CommonName is the common name on the verified client certificate or
+	// empty if the client did not present one.
+	CommonName string
+	// Status is the HTTP status code of the response.
+	Status int
+	// Reason describes why the request was denied.
+	Reason string
+}
+
+// AuditFunc is called for every request denied by an AccessControlList.
+type AuditFunc func(AccessDenial)
+
+// LogAudit is the default AuditFunc which writes the denial to the standard
+// logger as a single line of key-value pairs.
+func LogAudit(d AccessDenial) {
+	log.Printf("access denied: method=%q path=%q remote=%q cn=%q status=%d reason=%q",
+		d.Method, d.Path, d.RemoteAddr, d.CommonName, d.Status, d.Reason)
+}
+
+// Lookup returns the whitelist configured for the request method and path
+// and whether one was found.
+//
+// An exact path match takes precedence over patterns with wildcards; of the
+// matching patterns, the longest one is used. Within the path, an exact method
+// match takes precedence over Wildcard.
+func (acl AccessControlList) Lookup(method, path string) (Whitelist, bool) {
+	methods, ok := acl[path]
+	if !ok {
+		best := -1
+		for pattern, m := range acl {
+			if len(pattern) > best && matchWildcard(pattern, path) {
+				methods, best = m, len(pattern)
+			}
+		}
+		if best < 0 {
+			return nil, false
+		}
+	}
+	if list, ok := methods[method]; ok {
+		return list, true
+	}
+	list, ok := methods[Wildcard]
+	return list, ok
+}
+
+// Allows reports whether the common name cn matches an element of w. An empty
+// Whitelist allows any subject, even one without a common name.
+func (w Whitelist) Allows(cn string) bool {
+	if len(w) == 0 {
+		return true
+	}
+	if cn == "" {
+		return false
+	}
+	for _, pattern := range w {
+		if matchWildcard(pattern, cn) {
+			return true
+		}
+	}
+	return false
+}
+
+// Handler returns an http.Handler which checks the common name on the
+// verified TLS client certificate of each request against the whitelist
+// configured for the request method and path before passing it to h.
+//
+// If no whitelist is configured for the request, it is denied with 403
+// Forbidden. If the whitelist is not empty and the client did not present a
+// verified certificate, the request is denied with 401 Unauthorized, and if
+// the common name is not whitelisted, with 403 Forbidden. Denials are
+// reported to audit; if it is nil, LogAudit is used.
+func (acl AccessControlList) Handler(h http.Handler, audit AuditFunc) http.Handler {
+	if audit == nil {
+		audit = LogAudit
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cn := clientCommonName(r)
+		deny := func(status int, reason string) {
+			audit(AccessDenial{
+				Method:     r.Method,
+				Path:       r.URL.Path,
+				RemoteAddr: r.RemoteAddr,
+				CommonName: cn,
+				Status:     status,
+				Reason:     reason,
+			})
+			http.Error(w, http.StatusText(status), status)
+		}
+
+		list, ok := acl.Lookup(r.Method, r.URL.Path)
+		switch {
+		case !ok:
+			deny(http.StatusForbidden, "no access-control list entry")
+		case list.Allows(cn):
+			h.ServeHTTP(w, r)
+		case cn == "":
+			deny(http.StatusUnauthorized, "no verified client certificate")
+		default:
+			deny(http.StatusForbidden, "common name not whitelisted")
+		}
+	})
+}
+
+// clientCommonName returns the common name on the verified client certificate
+// of r or an empty string if there is none.
+func clientCommonName(r *http.Request) string {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return ""
+	}
+	return r.TLS.VerifiedChains[0][0].Subject.CommonName
+}
+
+// matchWildcard reports whether s matches pattern, where each Wildcard in
+// pattern matches any sequence of characters.
+func matchWildcard(pattern, s string) bool {
+	parts := strings.Split(pattern, Wildcard)
+	if len(parts) == 1 {
+		return pattern == s
+	}
+	if !strings.HasPrefix(s, parts[0]) {
+		return false
+	}
+	s = s[len(parts[0]):]
+	last := parts[len(parts)-1]
+	for _, part := range parts[1 : len(parts)-1] {
+		i := strings.Index(s, part)
+		if i < 0 {
+			return false
+		}
+		s = s[i+len(part):]
+	}
+	return len(s) >= len(last) && strings.HasSuffix(s, last)
+}
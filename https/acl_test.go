@@ -0,0 +1,111 @@
+package https
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// aclRequest makes a request through acl with a verified client certificate
+// for cn (none if empty) and returns the response status and denials.
+func aclRequest(acl AccessControlList, method, path, cn string) (int, []AccessDenial) {
+	var denials []AccessDenial
+	h := acl.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}),
+		func(d AccessDenial) { denials = append(denials, d) })
+	r := httptest.NewRequest(method, path, nil)
+	if cn != "" {
+		cert := &x509.Certificate{Subject: pkix.Name{CommonName: cn}}
+		r.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{cert}}}
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w.Code, denials
+}
+
+func TestMatchWildcard_Patterns_Matched(t *testing.T) {
+	tests := []struct {
+		pattern, s string
+		match      bool
+	}{
+		{"*", "", true},
+		{"*", "anything", true},
+		{"client.example.com", "client.example.com", true},
+		{"client.example.com", "other.example.com", false},
+		{"*.example.com", "client.example.com", true},
+		{"*.example.com", "example.com", false},
+		{"/api/*", "/api/v1/heartbeat", true},
+		{"/api/*", "/apiv1", false},
+		{"a*b*c", "aXbYc", true},
+		{"a*b*c", "acb", false},
+		{"ab*ba", "aba", false},
+	}
+	for _, test := range tests {
+		if match := matchWildcard(test.pattern, test.s); match != test.match {
+			t.Errorf("matchWildcard(%q, %q) = %t, expected %t",
+				test.pattern, test.s, match, test.match)
+		}
+	}
+}
+
+func TestAccessControlListHandler_Requests_AllowedOrDenied(t *testing.T) {
+	// given
+	acl := AccessControlList{
+		"/api/v1/heartbeat": {"GET": {}},
+		"/api/*": {
+			"GET":    {"*.example.com"},
+			Wildcard: {"admin.example.com"},
+		},
+		"/api/v1/archive/*": {"DELETE": {"archiver.example.com"}},
+	}
+	tests := []struct {
+		method, path, cn string
+		status           int
+	}{
+		{"GET", "/api/v1/heartbeat", "", http.StatusOK},
+		{"GET", "/api/v1/documents", "client.example.com", http.StatusOK},
+		{"GET", "/api/v1/documents", "client.example.org", http.StatusForbidden},
+		{"GET", "/api/v1/documents", "", http.StatusUnauthorized},
+		{"POST", "/api/v1/documents", "client.example.com", http.StatusForbidden},
+		{"POST", "/api/v1/documents", "admin.example.com", http.StatusOK},
+		{"DELETE", "/api/v1/archive/1", "archiver.example.com", http.StatusOK},
+		{"DELETE", "/api/v1/archive/1", "admin.example.com", http.StatusForbidden},
+		{"GET", "/api/v1/archive/1", "admin.example.com", http.StatusForbidden},
+		{"GET", "/other", "admin.example.com", http.StatusForbidden},
+	}
+
+	for _, test := range tests {
+		// when
+		status, denials := aclRequest(acl, test.method, test.path, test.cn)
+
+		// then
+		if status != test.status {
+			t.Errorf("%s %s as %q: status %d, expected %d",
+				test.method, test.path, test.cn, status, test.status)
+		}
+		if denied := status != http.StatusOK; denied != (len(denials) == 1) {
+			t.Errorf("%s %s as %q: unexpected denials: %v",
+				test.method, test.path, test.cn, denials)
+		}
+	}
+}
+
+func TestAccessControlListHandler_Denied_Audited(t *testing.T) {
+	// given
+	acl := AccessControlList{Wildcard: {Wildcard: {"allowed"}}}
+
+	// when
+	_, denials := aclRequest(acl, http.MethodPost, "/p1", "intruder")
+
+	// then
+	if len(denials) != 1 {
+		t.Fatalf("unexpected denials: %v", denials)
+	}
+	d := denials[0]
+	if d.Method != http.MethodPost || d.Path != "/p1" || d.CommonName != "intruder" ||
+		d.Status != http.StatusForbidden || d.Reason == "" || d.RemoteAddr == "" {
+		t.Errorf("unexpected denial: %+v", d)
+	}
+}
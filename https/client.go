@@ -6,7 +6,11 @@ import (
 	"encoding/json"
 	"encoding/xml"
 	"io"
+	"io/ioutil"
+	"mime/multipart"
 	"net/http"
+	"net/textproto"
+	"net/url"
 	"path"
 	"strings"
 
@@ -26,34 +30,61 @@ type Client interface {
 	Do(req *Req) error
 }
 
+// Middleware wraps the http.RoundTripper used by a Client, e.g., to add
+// logging, tracing, or authentication to all requests.
+type Middleware func(http.RoundTripper) http.RoundTripper
+
+// ErrResponseTooLarge is returned when reading a response body which exceeds
+// the maximum response size.
+var ErrResponseTooLarge = errors.New("response too large")
+
 // client implements the https.Client interface.
 type client struct {
-	url    string
-	client *http.Client
+	url     string
+	client  *http.Client
+	maxBody int64
 }
 
-// NewClient creates a new HTTPS client for the specified configuration.
-func NewClient(conf ClientConf) Client {
+// NewClient creates a new HTTPS client for the specified configuration. The
+// middleware is applied to the transport in order, so that the first
+// middleware is the outermost.
+func NewClient(conf ClientConf, middleware ...Middleware) Client {
+	transport := httpsutil.Transport(conf.RootCAs, &conf.ClientTLS, conf.TransportOptions())
+	for i := len(middleware) - 1; i >= 0; i-- {
+		transport = middleware[i](transport)
+	}
+	maxBody := conf.MaxResponseSize
+	if maxBody <= 0 {
+		maxBody = DefaultMaxResponseSize
+	}
 	return &client{
 		url: conf.URL.Raw,
 		client: &http.Client{
-			Transport: httpsutil.Transport(conf.RootCAs, &conf.ClientTLS),
+			Transport: transport,
 			Timeout:   conf.Timeout.Or(DefaultClientTimeout),
 		},
+		maxBody: maxBody,
 	}
 }
 
 // Req implements the https.Client interface.
 //
 // Parts are joined together with '/'. It is ensured that single slash is used
-// for appending parts to the configured base URL.
+// for appending parts to the configured base URL. If no parts are given,
+// then the base URL is used as is.
 func (c *client) Req(ctx context.Context, parts ...string) *Req {
-	url := singleJoiningSlash(c.url, path.Join(parts...))
-	// log.Debug().WithString("url", url).Log(ctx, "new_request")
+	reqURL := c.url
+	if len(parts) > 0 {
+		reqURL = singleJoiningSlash(c.url, path.Join(parts...))
+	}
+	// log.Debug().WithString("url", reqURL).Log(ctx, "new_request")
 	return &Req{
-		Client: c,
-		ctx:    ctx,
-		url:    url,
+		Client:  c,
+		ctx:     ctx,
+		url:     reqURL,
+		header:  make(http.Header),
+		query:   make(url.Values),
+		maxBody: c.maxBody,
 	}
 }
 
@@ -64,19 +95,55 @@ func (c *client) Do(r *Req) error {
 		return r.Err
 	}
 
-	req, err := http.NewRequest(r.method, r.url, r.body)
+	body := r.body
+	if r.parts != nil {
+		// The parts are written only while the request is sent. Closing the
+		// reader when the request finishes stops the writer even if the
+		// body was not read to the end.
+		pr, pw := io.Pipe()
+		defer pr.CloseWithError(errRequestFinished)
+		writer := multipart.NewWriter(pw)
+		if err := writer.SetBoundary(r.boundary); err != nil {
+			return errors.WithStack(err)
+		}
+		go func() {
+			pw.CloseWithError(writeParts(writer, r.parts))
+		}()
+		body = pr
+	}
+
+	req, err := http.NewRequest(r.method, r.url, body)
 	if err != nil {
 		return err
 	}
+	if r.ctx != nil {
+		req = req.WithContext(r.ctx)
+	}
+	if len(r.query) > 0 {
+		query := req.URL.Query()
+		for key, values := range r.query {
+			query[key] = append(query[key], values...)
+		}
+		req.URL.RawQuery = query.Encode()
+	}
+	for key, values := range r.header {
+		req.Header[key] = values
+	}
 	if r.contentType != "" {
 		req.Header.Set("Content-Type", r.contentType)
 	}
+	if r.username != "" || r.password != "" {
+		req.SetBasicAuth(r.username, r.password)
+	}
 
 	res, err := c.client.Do(req)
 	if err != nil {
 		return err
 	}
 	defer res.Body.Close()
+	if r.maxBody > 0 {
+		res.Body = &limitedBody{ReadCloser: res.Body, left: r.maxBody}
+	}
 
 	// log.Debug().
 	//	WithString("url", r.url).
@@ -94,11 +161,18 @@ type Req struct {
 	url         string    // The URL of the request.
 	contentType string    // The content type.
 	body        io.Reader // The request body.
-	successCB   Callback  // The callback for 2XX response status codes.
-	clientErrCB Callback  // The callback for 4XX response status codes.
-	responseCB  Callback  // The callback for any response.
-	Err         error     // The error stored during build-up of the request.
-	Status      int       // The HTTP response status code.
+	parts       []Part    // The multipart/form-data parts, written in Do.
+	boundary    string    // The boundary of the multipart parts.
+	header      http.Header
+	query       url.Values
+	username    string
+	password    string
+	maxBody     int64    // The maximum response body size, 0 if unlimited.
+	successCB   Callback // The callback for 2XX response status codes.
+	clientErrCB Callback // The callback for 4XX response status codes.
+	responseCB  Callback // The callback for any response.
+	Err         error    // The error stored during build-up of the request.
+	Status      int      // The HTTP response status code.
 }
 
 // WithJSON evaluates the request body and content type as a JSON data with the specified content.
@@ -123,6 +197,94 @@ func (r *Req) WithXML(body interface{}) *Req {
 	return r
 }
 
+// WithBody sets the request body to be streamed from body with the specified
+// content type.
+func (r *Req) WithBody(contentType string, body io.Reader) *Req {
+	r.body = body
+	r.contentType = contentType
+	return r
+}
+
+// Part is a part of a multipart/form-data request body. If FileName is
+// empty, then the part is a form field, otherwise a file.
+type Part struct {
+	Name        string
+	FileName    string
+	ContentType string // Defaults to application/octet-stream for files.
+	Body        io.Reader
+}
+
+// WithMultipart evaluates the request body as multipart/form-data with the
+// specified parts. The parts are streamed while the request is sent, so
+// nothing is read from them if the request is never sent.
+func (r *Req) WithMultipart(parts ...Part) *Req {
+	writer := multipart.NewWriter(ioutil.Discard)
+	r.body = nil
+	r.parts = parts
+	r.boundary = writer.Boundary()
+	r.contentType = writer.FormDataContentType()
+	return r
+}
+
+// errRequestFinished stops writing multipart parts which were not read
+// before the request finished.
+var errRequestFinished = errors.New("request finished")
+
+func writeParts(writer *multipart.Writer, parts []Part) error {
+	for _, part := range parts {
+		header := make(textproto.MIMEHeader)
+		disposition := `form-data; name="` + quoteEscaper.Replace(part.Name) + `"`
+		contentType := part.ContentType
+		if part.FileName != "" {
+			disposition += `; filename="` + quoteEscaper.Replace(part.FileName) + `"`
+			if contentType == "" {
+				contentType = "application/octet-stream"
+			}
+		}
+		header.Set("Content-Disposition", disposition)
+		if contentType != "" {
+			header.Set("Content-Type", contentType)
+		}
+		w, err := writer.CreatePart(header)
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(w, part.Body); err != nil {
+			return errors.Wrapf(err, "write part %s", part.Name)
+		}
+	}
+	return writer.Close()
+}
+
+var quoteEscaper = strings.NewReplacer("\\", "\\\\", `"`, "\\\"")
+
+// WithHeader adds a header to the request.
+func (r *Req) WithHeader(key, value string) *Req {
+	r.header.Add(key, value)
+	return r
+}
+
+// WithQuery adds a query parameter to the request URL.
+func (r *Req) WithQuery(key, value string) *Req {
+	r.query.Add(key, value)
+	return r
+}
+
+// WithBasicAuth sets the request to use HTTP Basic Authentication.
+func (r *Req) WithBasicAuth(username, password string) *Req {
+	r.username = username
+	r.password = password
+	return r
+}
+
+// WithMaxResponseSize sets the maximum size of the response body in bytes.
+// Reading more fails with ErrResponseTooLarge. If max is zero or negative,
+// then the response size is not limited.
+func (r *Req) WithMaxResponseSize(max int64) *Req {
+	r.maxBody = max
+	return r
+}
+
 // OnSuccess registeres the callback for 2XX response codes.
 func (r *Req) OnSuccess(cb Callback) *Req {
 	r.successCB = cb
@@ -148,6 +310,13 @@ func (r *Req) GetJSON(ret interface{}) error {
 	}).Get()
 }
 
+// GetXML makes a GET request and parses the response as XML into the given object.
+func (r *Req) GetXML(ret interface{}) error {
+	return r.OnSuccess(func(res *http.Response) error {
+		return xml.NewDecoder(res.Body).Decode(ret)
+	}).Get()
+}
+
 // Get makes a GET request using the given function as response handler.
 func (r *Req) Get() error {
 	r.method = http.MethodGet
@@ -199,6 +368,24 @@ func (r *Req) Handle(res *http.Response) error {
 	return nil
 }
 
+// limitedBody fails with ErrResponseTooLarge if more than left bytes are
+// read from it.
+type limitedBody struct {
+	io.ReadCloser
+	left int64
+}
+
+func (l *limitedBody) Read(p []byte) (int, error) {
+	if int64(len(p)) > l.left+1 {
+		p = p[:l.left+1]
+	}
+	n, err := l.ReadCloser.Read(p)
+	if l.left -= int64(n); l.left < 0 {
+		return 0, errors.WithStack(ErrResponseTooLarge)
+	}
+	return n, err
+}
+
 // singleJoiningSlash joins the given paths with single slash in between.
 // Copied from net/http/httputil/reverseproxy.go.
 func singleJoiningSlash(a, b string) string {
@@ -0,0 +1,250 @@
+package https
+
+import (
+	"context"
+	"encoding/xml"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/e-gov/SiGa-Go/confutil"
+)
+
+func testClient(t *testing.T, handler http.HandlerFunc, middleware ...Middleware) (Client, func()) {
+	srv := httptest.NewServer(handler)
+	parsed, err := url.Parse(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conf := ClientConf{URL: confutil.URL{Raw: srv.URL, URL: parsed}, MaxResponseSize: 32}
+	return NewClient(conf, middleware...), srv.Close
+}
+
+func TestReqDo_HeadersQueryAndBasicAuth_Sent(t *testing.T) {
+	// given
+	var req *http.Request
+	client, done := testClient(t, func(w http.ResponseWriter, r *http.Request) {
+		req = r
+	})
+	defer done()
+
+	// when
+	err := client.Req(context.Background(), "api", "items").
+		WithHeader("X-Test", "value").
+		WithQuery("q", "a b").
+		WithQuery("q", "c").
+		WithBasicAuth("user", "secret").
+		Get()
+
+	// then
+	if err != nil {
+		t.Fatal(err)
+	}
+	if req.URL.Path != "/api/items" || req.URL.Query()["q"][1] != "c" || req.URL.Query().Get("q") != "a b" {
+		t.Errorf("unexpected URL: %s", req.URL)
+	}
+	if req.Header.Get("X-Test") != "value" {
+		t.Errorf("unexpected headers: %v", req.Header)
+	}
+	if user, password, ok := req.BasicAuth(); !ok || user != "user" || password != "secret" {
+		t.Errorf("unexpected basic auth: %s, %s", user, password)
+	}
+}
+
+func TestReqDo_CanceledContext_Error(t *testing.T) {
+	// given
+	client, done := testClient(t, func(w http.ResponseWriter, r *http.Request) {})
+	defer done()
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	// when
+	err := client.Req(ctx).Get()
+
+	// then
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+}
+
+func TestReqWithMultipart_Parts_Streamed(t *testing.T) {
+	// given
+	var field, file, contentType string
+	client, done := testClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseMultipartForm(1 << 20); err != nil {
+			t.Error(err)
+			return
+		}
+		field = r.FormValue("field")
+		f, header, err := r.FormFile("file")
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		data, _ := ioutil.ReadAll(f)
+		file, contentType = header.Filename+":"+string(data), header.Header.Get("Content-Type")
+	})
+	defer done()
+
+	// when
+	err := client.Req(context.Background()).WithMultipart(
+		Part{Name: "field", Body: strings.NewReader("value")},
+		Part{Name: "file", FileName: "test.txt", ContentType: "text/plain", Body: strings.NewReader("data")},
+	).Post()
+
+	// then
+	if err != nil {
+		t.Fatal(err)
+	}
+	if field != "value" || file != "test.txt:data" || contentType != "text/plain" {
+		t.Errorf("unexpected parts: %q, %q, %q", field, file, contentType)
+	}
+}
+
+// readSignal is a reader which closes read when it is first read.
+type readSignal struct {
+	read chan struct{}
+}
+
+func (r readSignal) Read(p []byte) (int, error) {
+	select {
+	case <-r.read:
+	default:
+		close(r.read)
+	}
+	return 0, io.EOF
+}
+
+func TestReqWithMultipart_NotSent_PartsNotRead(t *testing.T) {
+	// given
+	client, done := testClient(t, func(w http.ResponseWriter, r *http.Request) {})
+	defer done()
+	body := readSignal{read: make(chan struct{})}
+
+	// when
+	req := client.Req(context.Background()).WithMultipart(Part{Name: "file", FileName: "test.txt", Body: body})
+
+	// then
+	select {
+	case <-body.read:
+		t.Error("parts read before the request was sent")
+	case <-time.After(50 * time.Millisecond):
+	}
+	if !strings.HasPrefix(req.contentType, "multipart/form-data; boundary=") {
+		t.Errorf("unexpected content type: %s", req.contentType)
+	}
+}
+
+func TestReqWithMultipart_QuotedNames_Escaped(t *testing.T) {
+	// given
+	var name, fileName string
+	client, done := testClient(t, func(w http.ResponseWriter, r *http.Request) {
+		mr, err := r.MultipartReader()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		part, err := mr.NextPart()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		name, fileName = part.FormName(), part.FileName()
+	})
+	defer done()
+
+	// when
+	err := client.Req(context.Background()).WithMultipart(
+		Part{Name: `a"b`, FileName: `c\"d.txt`, Body: strings.NewReader("data")},
+	).Post()
+
+	// then
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name != `a"b` || fileName != `c\"d.txt` {
+		t.Errorf("unexpected names: %q, %q", name, fileName)
+	}
+}
+
+func TestReqGetXML_LargeResponse_ErrResponseTooLarge(t *testing.T) {
+	// given
+	client, done := testClient(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("<a><b>" + strings.Repeat("x", 64) + "</b></a>"))
+	})
+	defer done()
+
+	// when
+	var v struct {
+		B string `xml:"b"`
+	}
+	err := client.Req(context.Background()).GetXML(&v)
+
+	// then
+	if !errors.Is(err, ErrResponseTooLarge) {
+		t.Errorf("expected ErrResponseTooLarge, got %v", err)
+	}
+}
+
+func TestReqGetXML_Response_Decoded(t *testing.T) {
+	// given
+	client, done := testClient(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("<a><b>value</b></a>"))
+	})
+	defer done()
+
+	// when
+	var v struct {
+		XMLName xml.Name `xml:"a"`
+		B       string   `xml:"b"`
+	}
+	err := client.Req(context.Background()).GetXML(&v)
+
+	// then
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v.B != "value" {
+		t.Errorf("unexpected value: %s", v.B)
+	}
+}
+
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(r *http.Request) (*http.Response, error) {
+	return f(r)
+}
+
+func TestNewClient_Middleware_AppliedInOrder(t *testing.T) {
+	// given
+	var order []string
+	middleware := func(name string) Middleware {
+		return func(next http.RoundTripper) http.RoundTripper {
+			return roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+				order = append(order, name)
+				return next.RoundTrip(r)
+			})
+		}
+	}
+	client, done := testClient(t, func(w http.ResponseWriter, r *http.Request) {},
+		middleware("outer"), middleware("inner"))
+	defer done()
+
+	// when
+	err := client.Req(context.Background()).Get()
+
+	// then
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(order, ",") != "outer,inner" {
+		t.Errorf("unexpected order: %v", order)
+	}
+}
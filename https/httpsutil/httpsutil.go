@@ -5,18 +5,75 @@ https and the standard library net/http/httputil packages.
 package httpsutil
 
 import (
+	"context"
 	"crypto/tls"
 	"crypto/x509"
+	"net"
 	"net/http"
+	"net/url"
+	"time"
 
 	"github.com/e-gov/SiGa-Go/confutil"
 )
 
+// Default dialer values used if the corresponding values in Options are zero.
+// They match the values used by http.DefaultTransport.
+const (
+	DefaultDialTimeout = 30 * time.Second
+	DefaultKeepAlive   = 30 * time.Second
+)
+
+// Options contains optional network configuration for Transport. The zero
+// value results in the same behavior as http.DefaultTransport.
+type Options struct {
+	// Proxy, if not nil, is the URL of the proxy used for all requests,
+	// except to hosts matched by NoProxy. Credentials in the URL are
+	// used for proxy authentication. If Proxy is nil, then the proxy is
+	// determined by environment variables (see http.ProxyFromEnvironment).
+	Proxy *url.URL
+
+	// NoProxy is the list of hosts which are connected to directly when
+	// Proxy is set. See MatchNoProxy for the format of the elements.
+	NoProxy []string
+
+	// DialTimeout is the maximum amount of time a dial waits for a
+	// connection to complete.
+	DialTimeout time.Duration
+
+	// KeepAlive is the interval between TCP keep-alive probes. If
+	// KeepAlive is negative, then keep-alive probes are disabled.
+	KeepAlive time.Duration
+
+	// SourceAddress, if not nil, is the local IP address used for
+	// outgoing connections.
+	SourceAddress net.IP
+
+	// Resolve overrides DNS resolution: it maps hostnames to the IP
+	// addresses or "host:port" pairs dialed instead. Hostnames used for
+	// TLS server verification are unchanged.
+	Resolve map[string]string
+
+	// Pins, if not empty, restricts the accepted server certificate
+	// chains to those where a certificate matches one of Pins or
+	// BackupPins. The chain is verified against the root certificates
+	// first. BackupPins are for keys which are not deployed yet, allowing
+	// the server key to be replaced without updating the configuration.
+	Pins       []Pin
+	BackupPins []Pin
+}
+
+func (o *Options) isZero() bool {
+	return o == nil || (o.Proxy == nil && len(o.NoProxy) == 0 && o.DialTimeout == 0 &&
+		o.KeepAlive == 0 && o.SourceAddress == nil && len(o.Resolve) == 0 &&
+		len(o.Pins) == 0 && len(o.BackupPins) == 0)
+}
+
 // Transport returns a copy of http.DefaultTransport with custom TLS
-// configuration for server and client authentication. The returned Transport
-// has transparent HTTP/2 support.
-func Transport(rootCAs *confutil.CertPool, clientCert *confutil.TLS) http.RoundTripper {
-	if rootCAs == nil && (clientCert == nil || clientCert.Certificate == nil) {
+// configuration for server and client authentication, certificate pinning,
+// and network options. Pin verification failures result in a *PinError.
+// opts may be nil. The returned Transport has transparent HTTP/2 support.
+func Transport(rootCAs *confutil.CertPool, clientCert *confutil.TLS, opts *Options) http.RoundTripper {
+	if rootCAs == nil && (clientCert == nil || clientCert.Certificate == nil) && opts.isZero() {
 		return http.DefaultTransport
 	}
 
@@ -27,5 +84,62 @@ func Transport(rootCAs *confutil.CertPool, clientCert *confutil.TLS) http.RoundT
 			tls.Certificate(*clientCert),
 		}
 	}
+	if opts.isZero() {
+		return transport
+	}
+
+	if len(opts.Pins) > 0 || len(opts.BackupPins) > 0 {
+		pins := append(append([]Pin(nil), opts.Pins...), opts.BackupPins...)
+		transport.TLSClientConfig.VerifyPeerCertificate = verifyPins(pins)
+	}
+
+	if opts.Proxy != nil {
+		proxy, noProxy := opts.Proxy, opts.NoProxy
+		transport.Proxy = func(req *http.Request) (*url.URL, error) {
+			if MatchNoProxy(req.URL.Host, noProxy) {
+				return nil, nil
+			}
+			return proxy, nil
+		}
+	}
+
+	dialer := &net.Dialer{
+		Timeout:   DefaultDialTimeout,
+		KeepAlive: DefaultKeepAlive,
+	}
+	if opts.DialTimeout > 0 {
+		dialer.Timeout = opts.DialTimeout
+	}
+	if opts.KeepAlive != 0 {
+		dialer.KeepAlive = opts.KeepAlive
+	}
+	if opts.SourceAddress != nil {
+		dialer.LocalAddr = &net.TCPAddr{IP: opts.SourceAddress}
+	}
+	transport.DialContext = resolvingDialer(dialer, opts.Resolve)
 	return transport
 }
+
+// resolvingDialer returns a DialContext function which replaces hostnames
+// found in resolve before dialing with dialer.
+func resolvingDialer(dialer *net.Dialer, resolve map[string]string) func(
+	ctx context.Context, network, addr string) (net.Conn, error) {
+
+	if len(resolve) == 0 {
+		return dialer.DialContext
+	}
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		host, port, err := net.SplitHostPort(addr)
+		if err != nil {
+			return nil, err
+		}
+		if override, ok := resolve[host]; ok {
+			if _, _, err := net.SplitHostPort(override); err == nil {
+				addr = override
+			} else {
+				addr = net.JoinHostPort(override, port)
+			}
+		}
+		return dialer.DialContext(ctx, network, addr)
+	}
+}
@@ -0,0 +1,94 @@
+package httpsutil
+
+import (
+	"encoding/base64"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func TestMatchNoProxy_Patterns_Matched(t *testing.T) {
+	noProxy := []string{"example.com", ".internal", "10.0.0.0/8", "192.168.1.1", "service:8443"}
+	for host, expected := range map[string]bool{
+		"example.com":         true,
+		"www.example.com:443": true,
+		"notexample.com":      false,
+		"internal":            false,
+		"db.internal":         true,
+		"10.1.2.3:80":         true,
+		"11.1.2.3":            false,
+		"192.168.1.1":         true,
+		"service:8443":        true,
+		"service:443":         false,
+		"siga.example.org":    false,
+	} {
+		if got := MatchNoProxy(host, noProxy); got != expected {
+			t.Errorf("MatchNoProxy(%q) = %t, expected %t", host, got, expected)
+		}
+	}
+	if !MatchNoProxy("anything", []string{"*"}) {
+		t.Error("wildcard did not match")
+	}
+}
+
+func TestTransport_Proxy_RequestProxiedWithCredentials(t *testing.T) {
+	// given
+	var auth, target string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth = r.Header.Get("Proxy-Authorization")
+		target = r.URL.String()
+	}))
+	defer proxy.Close()
+	proxyURL, _ := url.Parse(proxy.URL)
+	proxyURL.User = url.UserPassword("user", "secret")
+	transport := Transport(nil, nil, &Options{Proxy: proxyURL, NoProxy: []string{"direct.example"}})
+
+	// when
+	resp, err := (&http.Client{Transport: transport}).Get("http://siga.example/test")
+
+	// then
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if target != "http://siga.example/test" {
+		t.Errorf("unexpected proxied URL: %s", target)
+	}
+	if expected := "Basic " + base64.StdEncoding.EncodeToString([]byte("user:secret")); auth != expected {
+		t.Errorf("unexpected Proxy-Authorization: %s", auth)
+	}
+}
+
+func TestTransport_Resolve_OverriddenAddressDialed(t *testing.T) {
+	// given
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.Host))
+	}))
+	defer srv.Close()
+	addr := srv.Listener.Addr().(*net.TCPAddr)
+	transport := Transport(nil, nil, &Options{
+		Resolve:       map[string]string{"siga.example": addr.String()},
+		SourceAddress: net.ParseIP("127.0.0.1"),
+	})
+
+	// when
+	resp, err := (&http.Client{Transport: transport}).Get("http://siga.example/test")
+
+	// then
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if body, _ := ioutil.ReadAll(resp.Body); string(body) != "siga.example" {
+		t.Errorf("unexpected Host: %s", body)
+	}
+}
+
+func TestTransport_NoOptions_DefaultTransport(t *testing.T) {
+	if Transport(nil, nil, &Options{}) != http.DefaultTransport {
+		t.Error("expected http.DefaultTransport")
+	}
+}
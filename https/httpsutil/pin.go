@@ -0,0 +1,108 @@
+package httpsutil
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Pin is the SHA-256 hash of the DER-encoded SubjectPublicKeyInfo of a
+// certificate. It is represented as a Base64 string, as in the pin-sha256
+// directive of HTTP Public Key Pinning (RFC 7469).
+type Pin [sha256.Size]byte
+
+// PinOf returns the Pin of the public key of cert.
+func PinOf(cert *x509.Certificate) Pin {
+	return sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+}
+
+// ParsePin parses a Pin from a Base64 string. An optional "sha256/" prefix
+// is accepted.
+func ParsePin(s string) (Pin, error) {
+	var pin Pin
+	decoded, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(s, "sha256/"))
+	if err != nil {
+		return pin, errors.Wrap(err, "parse pin")
+	}
+	if len(decoded) != len(pin) {
+		return pin, errors.Errorf("parse pin: expected %d bytes, got %d", len(pin), len(decoded))
+	}
+	copy(pin[:], decoded)
+	return pin, nil
+}
+
+// String returns pin encoded in Base64.
+func (pin Pin) String() string {
+	return base64.StdEncoding.EncodeToString(pin[:])
+}
+
+// MarshalText encodes pin in Base64.
+func (pin Pin) MarshalText() ([]byte, error) {
+	return []byte(pin.String()), nil
+}
+
+// UnmarshalText decodes pin from Base64.
+func (pin *Pin) UnmarshalText(text []byte) error {
+	parsed, err := ParsePin(string(text))
+	if err != nil {
+		return err
+	}
+	*pin = parsed
+	return nil
+}
+
+// ErrPinMismatch is the cause of a PinError.
+var ErrPinMismatch = errors.New("certificate pin mismatch")
+
+// PinError is returned from the TLS handshake if the certificate chain of
+// the server was valid, but no certificate in it matched a configured pin.
+// Errors caused by an invalid chain are returned by the crypto/x509 and
+// crypto/tls packages instead.
+type PinError struct {
+	// Pins are the pins of the certificates in the verified chains.
+	Pins []Pin
+}
+
+func (e *PinError) Error() string {
+	pins := make([]string, len(e.Pins))
+	for i, pin := range e.Pins {
+		pins[i] = pin.String()
+	}
+	return ErrPinMismatch.Error() + ": server presented " + strings.Join(pins, ", ")
+}
+
+// Unwrap returns ErrPinMismatch.
+func (e *PinError) Unwrap() error {
+	return ErrPinMismatch
+}
+
+// Cause returns ErrPinMismatch.
+func (e *PinError) Cause() error {
+	return ErrPinMismatch
+}
+
+// verifyPins returns a function for tls.Config.VerifyPeerCertificate which
+// checks that at least one certificate in the verified chains matches one
+// of pins. It is only called after the chain has been verified.
+func verifyPins(pins []Pin) func([][]byte, [][]*x509.Certificate) error {
+	allowed := make(map[Pin]bool, len(pins))
+	for _, pin := range pins {
+		allowed[pin] = true
+	}
+	return func(_ [][]byte, verifiedChains [][]*x509.Certificate) error {
+		var seen []Pin
+		for _, chain := range verifiedChains {
+			for _, cert := range chain {
+				pin := PinOf(cert)
+				if allowed[pin] {
+					return nil
+				}
+				seen = append(seen, pin)
+			}
+		}
+		return &PinError{Pins: seen}
+	}
+}
@@ -0,0 +1,102 @@
+package httpsutil
+
+import (
+	"crypto/x509"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/pkg/errors"
+
+	"github.com/e-gov/SiGa-Go/confutil"
+)
+
+// pinnedGet performs a GET request to srv with the server certificate as
+// the only root and the given pins.
+func pinnedGet(srv *httptest.Server, roots *x509.CertPool, pins, backup []Pin) error {
+	transport := Transport((*confutil.CertPool)(roots), nil, &Options{Pins: pins, BackupPins: backup})
+	resp, err := (&http.Client{Transport: transport}).Get(srv.URL)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+func testServerRoots(srv *httptest.Server) *x509.CertPool {
+	roots := x509.NewCertPool()
+	roots.AddCert(srv.Certificate())
+	return roots
+}
+
+func TestTransport_MatchingPin_Succeeds(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+	defer srv.Close()
+	if err := pinnedGet(srv, testServerRoots(srv), []Pin{PinOf(srv.Certificate())}, nil); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTransport_MatchingBackupPin_Succeeds(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+	defer srv.Close()
+	err := pinnedGet(srv, testServerRoots(srv), []Pin{{1}}, []Pin{PinOf(srv.Certificate())})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTransport_MismatchingPin_PinError(t *testing.T) {
+	// given
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+	defer srv.Close()
+
+	// when
+	err := pinnedGet(srv, testServerRoots(srv), []Pin{{1}}, []Pin{{2}})
+
+	// then
+	if !errors.Is(err, ErrPinMismatch) {
+		t.Fatalf("expected pin mismatch, got %v", err)
+	}
+	var pinErr *PinError
+	if !errors.As(err, &pinErr) || len(pinErr.Pins) == 0 || pinErr.Pins[0] != PinOf(srv.Certificate()) {
+		t.Errorf("unexpected pin error: %v", err)
+	}
+}
+
+func TestTransport_UntrustedChainWithMatchingPin_ChainError(t *testing.T) {
+	// given
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+	defer srv.Close()
+
+	// when
+	err := pinnedGet(srv, x509.NewCertPool(), []Pin{PinOf(srv.Certificate())}, nil)
+
+	// then
+	if err == nil {
+		t.Fatal("expected error")
+	}
+	if errors.Is(err, ErrPinMismatch) {
+		t.Errorf("chain failure reported as pin mismatch: %v", err)
+	}
+}
+
+func TestPinUnmarshalText_Base64_RoundTrips(t *testing.T) {
+	// given
+	const encoded = `["sha256/47DEQpj8HBSa+/TImW+5JCeuQeRkm5NMpJWZG3hSuFU="]`
+
+	// when
+	var pins []Pin
+	err := json.Unmarshal([]byte(encoded), &pins)
+
+	// then
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pins[0].String() != "47DEQpj8HBSa+/TImW+5JCeuQeRkm5NMpJWZG3hSuFU=" {
+		t.Errorf("unexpected pin: %s", pins[0])
+	}
+	if err := json.Unmarshal([]byte(`["AAAA"]`), &pins); err == nil {
+		t.Error("expected error for short pin")
+	}
+}
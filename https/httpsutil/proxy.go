@@ -0,0 +1,67 @@
+package httpsutil
+
+import (
+	"net"
+	"strings"
+)
+
+// MatchNoProxy reports if host, optionally with a port, is matched by an
+// element of noProxy. The elements use the same format as the NO_PROXY
+// environment variable:
+//
+//   - "*" matches all hosts,
+//   - an IP address matches the address,
+//   - a CIDR block (e.g., "10.0.0.0/8") matches IP addresses in the block,
+//   - a domain name matches the domain and all of its subdomains; a leading
+//     "." (e.g., ".example.com") only matches subdomains.
+//
+// Elements can have a port, in which case only that port is matched.
+func MatchNoProxy(host string, noProxy []string) bool {
+	hostname, port := host, ""
+	if h, p, err := net.SplitHostPort(host); err == nil {
+		hostname, port = h, p
+	}
+	hostname = strings.ToLower(strings.TrimSuffix(hostname, "."))
+	ip := net.ParseIP(hostname)
+
+	for _, pattern := range noProxy {
+		pattern = strings.ToLower(strings.TrimSpace(pattern))
+		switch {
+		case pattern == "":
+			continue
+		case pattern == "*":
+			return true
+		}
+
+		if _, block, err := net.ParseCIDR(pattern); err == nil {
+			if ip != nil && block.Contains(ip) {
+				return true
+			}
+			continue
+		}
+
+		if p, pp, err := net.SplitHostPort(pattern); err == nil {
+			if pp != port {
+				continue
+			}
+			pattern = p
+		}
+		if patternIP := net.ParseIP(pattern); patternIP != nil {
+			if ip != nil && patternIP.Equal(ip) {
+				return true
+			}
+			continue
+		}
+
+		if strings.HasPrefix(pattern, ".") {
+			if strings.HasSuffix(hostname, pattern) {
+				return true
+			}
+			continue
+		}
+		if hostname == pattern || strings.HasSuffix(hostname, "."+pattern) {
+			return true
+		}
+	}
+	return false
+}
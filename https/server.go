@@ -0,0 +1,97 @@
+package https
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"io"
+	"net"
+	"net/http"
+
+	"github.com/gorilla/handlers"
+)
+
+// CORS settings used by Server if ServerConf.AllowedOrigins is not empty.
+var (
+	corsMethods = []string{http.MethodGet, http.MethodHead, http.MethodPost, http.MethodDelete}
+	corsHeaders = []string{"Content-Type", "Traceparent"}
+)
+
+// Server is an HTTPS server configured from a ServerConf.
+type Server struct {
+	*http.Server
+}
+
+// NewServer creates a new HTTPS server for the specified configuration which
+// serves requests using handler. The server
+//
+//   - uses conf.TLS as its certificate chain and private key,
+//
+//   - requests and verifies client certificates against conf.ClientCAs if
+//     it is specified: connections without a client certificate are
+//     accepted and left for handlers to authorize,
+//
+//   - authorizes requests by the common name on the client certificate
+//     using conf.AllowedClients if it is specified (see
+//     AccessControlList.Handler; denials are logged with LogAudit),
+//
+//   - allows cross-origin requests from conf.AllowedOrigins if any are
+//     specified, and
+//
+//   - writes an access log entry in the Apache Combined Log Format for each
+//     request into accessLog if it is not nil.
+//
+// Timeouts which are zero in conf are replaced with the package defaults.
+func NewServer(conf *ServerConf, handler http.Handler, accessLog io.Writer) *Server {
+	listen := conf.Listen
+	if listen == "" {
+		listen = ":https"
+	}
+
+	tlsConf := &tls.Config{
+		Certificates: []tls.Certificate{tls.Certificate(conf.TLS)},
+		MinVersion:   tls.VersionTLS12,
+	}
+	if conf.ClientCAs != nil {
+		tlsConf.ClientCAs = (*x509.CertPool)(conf.ClientCAs)
+		tlsConf.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+
+	// Access control is applied after CORS, so that preflight requests,
+	// which are answered by the CORS handler, need no whitelist entry.
+	if conf.AllowedClients != nil {
+		handler = conf.AllowedClients.Handler(handler, nil)
+	}
+	if len(conf.AllowedOrigins) > 0 {
+		handler = handlers.CORS(
+			handlers.AllowedOrigins(conf.AllowedOrigins),
+			handlers.AllowedMethods(corsMethods),
+			handlers.AllowedHeaders(corsHeaders),
+			handlers.AllowCredentials(),
+		)(handler)
+	}
+	if accessLog != nil {
+		handler = handlers.CombinedLoggingHandler(accessLog, handler)
+	}
+
+	return &Server{&http.Server{
+		Addr:              listen,
+		Handler:           handler,
+		TLSConfig:         tlsConf,
+		ReadTimeout:       conf.ReadTimeout.Or(DefaultReadTimeout),
+		ReadHeaderTimeout: conf.ReadHeaderTimeout.Or(DefaultReadHeaderTimeout),
+		WriteTimeout:      conf.WriteTimeout.Or(DefaultWriteTimeout),
+		IdleTimeout:       conf.IdleTimeout.Or(DefaultIdleTimeout),
+	}}
+}
+
+// ListenAndServe listens on the configured TCP address and serves HTTPS
+// requests. It always returns a non-nil error; after Shutdown or Close it is
+// http.ErrServerClosed.
+func (s *Server) ListenAndServe() error {
+	return s.Server.ListenAndServeTLS("", "")
+}
+
+// Serve accepts connections on l and serves HTTPS requests on them.
+func (s *Server) Serve(l net.Listener) error {
+	return s.Server.ServeTLS(l, "", "")
+}
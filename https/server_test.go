@@ -0,0 +1,190 @@
+package https
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/e-gov/SiGa-Go/confutil"
+)
+
+// testCertificate creates a certificate for cn signed by parent (self-signed
+// if parent is nil).
+func testCertificate(t *testing.T, cn string, parent *tls.Certificate) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: cn},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  parent == nil,
+	}
+	issuer, signer := template, interface{}(key)
+	if parent != nil {
+		issuer, signer = parent.Leaf, parent.PrivateKey
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, issuer, &key.PublicKey, signer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}
+}
+
+// startServer starts srv on a local port and returns its base URL and a
+// client trusting the server certificate.
+func startServer(t *testing.T, srv *Server, serverCert *x509.Certificate) (string, *http.Client, func()) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go srv.Serve(l)
+	roots := x509.NewCertPool()
+	roots.AddCert(serverCert)
+	client := &http.Client{Transport: &http.Transport{
+		TLSClientConfig: &tls.Config{RootCAs: roots},
+	}}
+	return "https://" + l.Addr().String(), client, func() { srv.Close() }
+}
+
+// testServerConf returns a ServerConf using the certificate of httptest.
+func testServerConf() (*ServerConf, *x509.Certificate) {
+	ts := httptest.NewTLSServer(http.NotFoundHandler())
+	defer ts.Close()
+	return &ServerConf{TLS: confutil.TLS(ts.TLS.Certificates[0])}, ts.Certificate()
+}
+
+func TestNewServer_Request_ServedAndAccessLogged(t *testing.T) {
+	// given
+	conf, cert := testServerConf()
+	conf.ReadTimeout = confutil.Seconds(5 * time.Second)
+	var accessLog bytes.Buffer
+	srv := NewServer(conf, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}), &accessLog)
+	url, client, stop := startServer(t, srv, cert)
+	defer stop()
+
+	// when
+	resp, err := client.Get(url + "/p1")
+
+	// then
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(body) != "ok" {
+		t.Errorf("unexpected body: %s", body)
+	}
+	if !strings.Contains(accessLog.String(), `"GET /p1 HTTP/1.1" 200 2`) {
+		t.Errorf("unexpected access log: %s", accessLog.String())
+	}
+	if srv.ReadTimeout != 5*time.Second || srv.WriteTimeout != DefaultWriteTimeout {
+		t.Errorf("unexpected timeouts: %v %v", srv.ReadTimeout, srv.WriteTimeout)
+	}
+	if srv.Addr != ":https" {
+		t.Errorf("unexpected default address: %s", srv.Addr)
+	}
+}
+
+func TestNewServer_AllowedOrigin_CORSHeaders(t *testing.T) {
+	// given
+	conf, cert := testServerConf()
+	conf.AllowedOrigins = []string{"https://example.com"}
+	srv := NewServer(conf, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}), nil)
+	url, client, stop := startServer(t, srv, cert)
+	defer stop()
+
+	// when
+	check := func(origin string) string {
+		req, _ := http.NewRequest(http.MethodOptions, url+"/p1", nil)
+		req.Header.Set("Origin", origin)
+		req.Header.Set("Access-Control-Request-Method", http.MethodPost)
+		req.Header.Set("Access-Control-Request-Headers", "content-type,traceparent")
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.Header.Get("Access-Control-Allow-Origin")
+	}
+	allowed := check("https://example.com")
+	denied := check("https://evil.example")
+
+	// then
+	if allowed != "https://example.com" {
+		t.Errorf("allowed origin not allowed: %q", allowed)
+	}
+	if denied != "" {
+		t.Errorf("unknown origin allowed: %q", denied)
+	}
+}
+
+func TestNewServer_ClientCAs_ClientCertificateVerified(t *testing.T) {
+	// given
+	ca := testCertificate(t, "Test CA", nil)
+	clientCert := testCertificate(t, "client.example", &ca)
+	other := testCertificate(t, "other.example", nil)
+	conf, cert := testServerConf()
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.Leaf)
+	conf.ClientCAs = (*confutil.CertPool)(pool)
+	srv := NewServer(conf, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if len(r.TLS.PeerCertificates) > 0 {
+			w.Write([]byte(r.TLS.PeerCertificates[0].Subject.CommonName))
+		}
+	}), nil)
+	url, client, stop := startServer(t, srv, cert)
+	defer stop()
+	get := func(certs ...tls.Certificate) (string, error) {
+		transport := client.Transport.(*http.Transport).Clone()
+		transport.TLSClientConfig.Certificates = certs
+		resp, err := (&http.Client{Transport: transport}).Get(url)
+		if err != nil {
+			return "", err
+		}
+		defer resp.Body.Close()
+		body, err := ioutil.ReadAll(resp.Body)
+		return string(body), err
+	}
+
+	// when
+	cn, err := get(clientCert)
+	anonymous, anonymousErr := get()
+	// The client does not send a certificate which is not issued by one of
+	// the ClientCAs, so the connection is anonymous.
+	otherCN, otherErr := get(other)
+
+	// then
+	if err != nil || cn != "client.example" {
+		t.Errorf("unexpected client CN: %q, error: %v", cn, err)
+	}
+	if anonymousErr != nil || anonymous != "" {
+		t.Errorf("unexpected anonymous response: %q, error: %v", anonymous, anonymousErr)
+	}
+	if otherErr == nil && otherCN != "" {
+		t.Errorf("untrusted client certificate accepted: %q", otherCN)
+	}
+}
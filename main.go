@@ -2,27 +2,44 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"flag"
 	"fmt"
 	"io/ioutil"
 	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
 
+	"github.com/e-gov/SiGa-Go/archive"
+	"github.com/e-gov/SiGa-Go/https"
 	"github.com/e-gov/SiGa-Go/siga"
+	"github.com/e-gov/SiGa-Go/tracing"
 )
 
 // SiGa-ga suhtlemise klient valmistatakse rakenduse töö algul. Klient on
-// globaalmuutujas. ID-kaardiga ja m-ID-ga allkirjastamisele on eraldi,
-// fikseeritud seansi ID väärtused (session).
-// See on vastuvõetav, kuna näidisrakendus on mõeldud kasutamiseks
-// lokaalses masinas, ühe, aeglaselt tegutseva kasutaja poolt.
-// Tootmisrakendus muidugi vajab korralikku seansihaldust, kogu ahela
-// Sirvikurakendus <-> Serverirakendus <-> SiGa vahel.
+// globaalmuutujas. Iga sirvik saab oma seansi (vt sessions); ID-kaardiga ja
+// m-ID-ga allkirjastamisele on seansis eraldi SiGa seansi ID väärtused.
 var sigaClient siga.Client
 
-// Loo seansid.
-const isession = "SiGA_Go_IDCard_Signing"
-const msession = "SiGA_Go_mID_Signing"
+// sessions hoiab sirvikuseansse. Aegunud seansi SiGa konteinerid suletakse.
+var sessions = newSessionStore(sessionTTL, closeSigaSessions)
+
+// sigaMetrics kogub SiGa poole pöördumiste mõõdikuid. Mõõdikud on
+// Prometheus-e vormingus kättesaadavad aadressil /metrics.
+var sigaMetrics = siga.NewMetrics()
+
+// signedArchive hoiab allkirjastatud konteinereid koos metaandmetega (vt
+// archiveHandler). nil väärtuse korral konteinereid ei arhiveerita.
+var signedArchive *archive.Archive
+
+// sigaTracer salvestab sirviku, serverirakenduse ja SiGa vaheliste
+// pöördumiste jälgi (span). nil väärtuse korral jälgi ei salvestata.
+var sigaTracer *tracing.Tracer
 
 // main loeb sisse SiGa-Go seadistuse, loob ja käivitab HTTPS kliendi Riigi
 // allkirjastamisteenusega suhtlemiseks ja HTTPS serveri kasutajaliidesest
@@ -33,8 +50,21 @@ func main() {
 	cFilePtr := flag.String(
 		"conf",
 		"certs/siga-conf-PParmakson.json", "Seadistusfaili asukoht")
+	serverConfPath := flag.String(
+		"server",
+		"certs/server-conf.json", "HTTPS serveri seadistusfaili asukoht")
+	archiveDir := flag.String(
+		"archive",
+		"allkirjad/arhiiv", "Allkirjastatud konteinerite arhiivi kausta asukoht")
+	traceDest := flag.String(
+		"trace",
+		"", "Jälgede salvestamise faili asukoht või kollektori URL")
 	flag.Parse()
 
+	// Seadista jälgimine.
+	var exporter traceExporter
+	sigaTracer, exporter = createTracer(*traceDest)
+
 	// Loe seadistusfail.
 	bytes, err := ioutil.ReadFile(*cFilePtr)
 	if err != nil {
@@ -49,14 +79,123 @@ func main() {
 	}
 
 	// Loo SiGa klient.
+	conf.Metrics = sigaMetrics
+	conf.Tracer = sigaTracer
 	sigaClient = CreateSIGAClient(conf)
 
-	// Loo HTTPS server. See peab olema peaprogrammis viimane, sest ListenAndServe
-	// juurest ei lähe täitmisjärg edasi. XXX: Uurida, parem lahendus?
-	CreateServer()
+	// Ava allkirjastatud konteinerite arhiiv.
+	signedArchive, err = archive.New(*archiveDir)
+	if err != nil {
+		log.Fatal("SiGa-Go: Viga arhiivi avamisel: ", err)
+	}
+
+	// Eemalda perioodiliselt aegunud sirvikuseansid.
+	ctx, stopSessions := context.WithCancel(context.Background())
+	go sessions.run(ctx, time.Minute)
+
+	// Eemalda perioodiliselt arhiivist aegunud seansside dokumendid.
+	go runArchivePruning(ctx, sessions, archivePruneInterval)
+
+	// Loo ja käivita HTTPS server.
+	srv := CreateServer(LoadServerConf(*serverConfPath))
+	go func() {
+		if err := srv.ListenAndServe(); err != http.ErrServerClosed {
+			log.Fatal("SiGa-Go: Viga HTTPS serveri töös: ", err)
+		}
+	}()
+
+	// Oota lõpetamissignaali ja lõpeta töö korrektselt.
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, os.Interrupt)
+	log.Println("SiGa-Go: Saadud signaal ", <-signals, ", lõpetan tööd")
+	stopSessions()
+	shutdown(srv, exporter)
+	fmt.Println("SiGa-Go: Töö lõpetatud")
+}
+
+// shutdownTimeout on aeg, mille jooksul lõpetatakse pooleliolevad
+// allkirjastamised ja päringud ning suletakse konteinerid.
+const shutdownTimeout = 30 * time.Second
+
+// drainInterval on pooleliolevate allkirjastamiste kontrollimise intervall
+// töö lõpetamisel.
+const drainInterval = 500 * time.Millisecond
+
+// shutdown lõpetab korrektselt rakenduse töö: uusi allkirjastamisi enam ei
+// alustata ja oodatakse pooleliolevate allkirjastamiste (ka kasutaja
+// telefonis kinnitamist ootavate m-ID ja /p1 ning /p2 vahelise ID-kaardi
+// allkirjastamiste) lõppemist, mille ajal server teenindab veel päringuid.
+// Seejärel lõpetab server uute päringute vastuvõtmise ja ootab
+// pooleliolevate päringute lõppemist, suletakse kõik SiGa kliendi teada
+// olevad avatud konteinerid, SiGa klient ja viimasena jälgede eksportija
+// exporter (kui see ei ole nil), et ka töö lõpetamise jäljed salvestataks.
+// Kõik see peab mahtuma ajasse shutdownTimeout.
+func shutdown(srv *https.Server, exporter traceExporter) {
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	if err := sessions.drain(ctx, drainInterval); err != nil {
+		log.Println("SiGa-Go: Pooleliolevaid allkirjastamisi ei jõutud lõpetada: ", err)
+	}
+	if err := srv.Shutdown(ctx); err != nil {
+		log.Println("SiGa-Go: Pooleliolevaid päringuid ei õnnestunud lõpetada: ", err)
+	}
+	if err := sigaClient.CloseAllContainers(ctx); err != nil {
+		log.Println("SiGa-Go: Viga konteinerite sulgemisel: ", err)
+	}
+	if err := sigaClient.Close(); err != nil {
+		log.Println("SiGa-Go: Viga SiGa kliendi sulgemisel: ", err)
+	}
+	if exporter != nil {
+		if err := exporter.Shutdown(ctx); err != nil {
+			log.Println("SiGa-Go: Viga jälgede eksportija sulgemisel: ", err)
+		}
+	}
+}
+
+// traceExporter on jälgede eksportija, mis tuleb töö lõpetamisel sulgeda, et
+// puhverdatud jäljed salvestataks.
+type traceExporter interface {
+	tracing.Exporter
+	Shutdown(ctx context.Context) error
+}
+
+// fileExporter kirjutab jäljed faili ja sulgeb töö lõpetamisel faili.
+type fileExporter struct {
+	*tracing.WriterExporter
+	f *os.File
+}
+
+// Shutdown sulgeb jälgede faili.
+func (e fileExporter) Shutdown(ctx context.Context) error {
+	return e.f.Close()
+}
+
+// traceCollectorTimeout on jälgede kollektorile saatmise päringu maksimaalne
+// kestus.
+const traceCollectorTimeout = 10 * time.Second
 
-	// TODO: Kas sigaClient sulgemine on vajalik?
-	// 	defer c.Close()
+// createTracer moodustab jälgija, mis saadab jäljed dest-is antud HTTP(S)
+// kollektorile või kirjutab need JSON ridadena faili dest, ning jälgija
+// eksportija, mis tuleb töö lõpetamisel sulgeda (vt shutdown). Kui dest on
+// tühi, siis jälgimine ei ole sisse lülitatud.
+func createTracer(dest string) (*tracing.Tracer, traceExporter) {
+	if dest == "" {
+		return nil, nil
+	}
+	onError := func(err error) { log.Println("SiGa-Go: Viga jälgede salvestamisel: ", err) }
+	if strings.HasPrefix(dest, "http://") || strings.HasPrefix(dest, "https://") {
+		client := &http.Client{Timeout: traceCollectorTimeout}
+		exporter := tracing.NewCollectorExporter(dest, client, onError)
+		return tracing.NewTracer(exporter), exporter
+	}
+	f, err := os.OpenFile(dest, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		log.Fatal("SiGa-Go: Viga jälgede faili avamisel: ", err)
+	}
+	exporter := fileExporter{WriterExporter: tracing.NewWriterExporter(f), f: f}
+	exporter.OnError = onError
+	return tracing.NewTracer(exporter), exporter
 }
 
 // Märkmed
@@ -9,6 +9,7 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"crypto"
 	"crypto/x509"
 	"encoding/json"
@@ -19,6 +20,9 @@ import (
 	"net/url"
 
 	"golang.org/x/crypto/ocsp"
+
+	"github.com/e-gov/SiGa-Go/confutil"
+	"github.com/e-gov/SiGa-Go/https"
 )
 
 func main() {
@@ -86,30 +90,26 @@ func isCertificateRevokedByOCSP(
 		return "", err
 	}
 
-	httpRequest, err := http.NewRequest(http.MethodPost, ocspServer, bytes.NewBuffer(buffer))
-	if err != nil {
-		return "", err
-	}
-
-	ocspUrl, err := url.Parse(ocspServer)
-	if err != nil {
-		return "", err
-	}
-
-	httpRequest.Header.Add("Content-Type", "application/ocsp-request")
-	httpRequest.Header.Add("Accept", "application/ocsp-response")
-	httpRequest.Header.Add("host", ocspUrl.Host)
-
-	httpClient := &http.Client{}
-
-	httpResponse, err := httpClient.Do(httpRequest)
+	ocspURL, err := url.Parse(ocspServer)
 	if err != nil {
 		return "", err
 	}
 
-	defer httpResponse.Body.Close()
-
-	output, err := ioutil.ReadAll(httpResponse.Body)
+	// Tee päring https paki kliendiga. OCSP vastused on väikesed, seega
+	// piira vastuse suurust.
+	client := https.NewClient(https.ClientConf{
+		URL:             confutil.URL{Raw: ocspServer, URL: ocspURL},
+		MaxResponseSize: 64 << 10,
+	})
+	var output []byte
+	err = client.Req(context.Background()).
+		WithHeader("Accept", "application/ocsp-response").
+		WithBody("application/ocsp-request", bytes.NewReader(buffer)).
+		OnSuccess(func(res *http.Response) (err error) {
+			output, err = ioutil.ReadAll(res.Body)
+			return err
+		}).
+		Post()
 	if err != nil {
 		return "", err
 	}
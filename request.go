@@ -0,0 +1,131 @@
+package main
+
+import (
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/e-gov/SiGa-Go/siga"
+)
+
+// maxJSONSize on JSON päringu keha suurim suurus baitides.
+const maxJSONSize = 1 << 20
+
+// validator on päringu keha, mis oskab oma välju kontrollida.
+type validator interface {
+	// validate tagastab *apiError-i, kui mõni väli on vigane.
+	validate() error
+}
+
+// requireMethod kontrollib, et päringu meetod on üks meetoditest methods.
+func requireMethod(w http.ResponseWriter, req *http.Request, methods ...string) error {
+	for _, method := range methods {
+		if req.Method == method {
+			return nil
+		}
+	}
+	w.Header().Set("Allow", strings.Join(methods, ", "))
+	return newAPIError(http.StatusMethodNotAllowed, codeMethodNotAllowed, req.Method)
+}
+
+// decodeJSON loeb päringu kehast JSON objekti v-sse ja kontrollib selle
+// välju, kui v on validator. Keha suurus on piiratud maxJSONSize baidiga.
+func decodeJSON(w http.ResponseWriter, req *http.Request, v interface{}) error {
+	if mediaType, _, _ := mime.ParseMediaType(req.Header.Get("Content-Type")); mediaType != "application/json" {
+		return newAPIError(http.StatusUnsupportedMediaType, codeUnsupportedMediaType, mediaType)
+	}
+	body := http.MaxBytesReader(w, req.Body, maxJSONSize)
+	dec := json.NewDecoder(body)
+	if err := dec.Decode(v); err != nil {
+		if isTooLarge(err) {
+			return newAPIError(http.StatusRequestEntityTooLarge, codeBodyTooLarge, maxJSONSize).withCause(err)
+		}
+		return newAPIError(http.StatusBadRequest, codeInvalidJSON).withCause(err)
+	}
+	// Pärast objekti ei tohi kehas midagi olla.
+	if _, err := dec.Token(); err != io.EOF {
+		if isTooLarge(err) {
+			return newAPIError(http.StatusRequestEntityTooLarge, codeBodyTooLarge, maxJSONSize).withCause(err)
+		}
+		return newAPIError(http.StatusBadRequest, codeInvalidJSON)
+	}
+	if val, ok := v.(validator); ok {
+		return val.validate()
+	}
+	return nil
+}
+
+// isTooLarge teatab, kas err on http.MaxBytesReader-i viga. Go 1.13-s ei
+// ole sellel veal omaette tüüpi.
+func isTooLarge(err error) bool {
+	return err != nil && strings.Contains(err.Error(), "http: request body too large")
+}
+
+// signRequest on allkirjastamise alustamise päringu keha: allkirjastatav
+// tekst ja allkirjastamisviisi väljad. Sama päringu võib saata ka
+// multipart/form-data vormina koos failidega.
+type signRequest interface {
+	validator
+	// text tagastab allkirjastatava teksti JSON päringu korral.
+	text() string
+	// existing teatab, kas allkiri lisatakse sirvikuseansis üles laaditud
+	// konteinerile (vt containerHandler).
+	existing() bool
+	// setForm täidab väljad multipart vormist.
+	setForm(form url.Values)
+}
+
+// readSignRequest loeb allkirjastamise alustamise päringu r-i ja tagastab
+// allkirjastatavad failid. Failid saadetakse multipart/form-data vormina (vt
+// readUpload), ainult tekst ka JSON-na; tekst pannakse konteinerisse
+// failina textFileName. Kui allkiri lisatakse üles laaditud konteinerile,
+// siis faile ei tagastata.
+func readSignRequest(w http.ResponseWriter, req *http.Request, r signRequest) ([]*siga.DataFile, error) {
+	if err := requireMethod(w, req, http.MethodPost); err != nil {
+		return nil, err
+	}
+	if isMultipart(req) {
+		form, datafiles, err := readUpload(w, req)
+		if err != nil {
+			return nil, err
+		}
+		r.setForm(form)
+		if err := r.validate(); err != nil {
+			return nil, err
+		}
+		return datafiles, nil
+	}
+
+	if err := decodeJSON(w, req, r); err != nil {
+		return nil, err
+	}
+	if r.existing() {
+		return nil, nil
+	}
+	if r.text() == "" {
+		return nil, newAPIError(http.StatusBadRequest, codeNothingToSign)
+	}
+	datafile, err := siga.NewDataFile(textFileName, strings.NewReader(r.text()))
+	if err != nil {
+		return nil, err
+	}
+	return []*siga.DataFile{datafile}, nil
+}
+
+// parseSignerCertificate parsib allkirjastaja PEM-kujul serdi.
+func parseSignerCertificate(cert string) (*x509.Certificate, error) {
+	block, _ := pem.Decode([]byte(cert))
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, newAPIError(http.StatusBadRequest, codeInvalidCertificate)
+	}
+	parsed, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, newAPIError(http.StatusBadRequest, codeInvalidCertificate).withCause(err)
+	}
+	return parsed, nil
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// jsonRequest teeb JSON päringu käsitlejale h uue seansi nimel ja
+// dekodeerib veavastuse.
+func jsonRequest(t *testing.T, h http.HandlerFunc, body, lang string) (int, *errorBody) {
+	store := newSessionStore(time.Minute, nil)
+	req := httptest.NewRequest(http.MethodPost, "/p1", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	if lang != "" {
+		req.Header.Set("Accept-Language", lang)
+	}
+	rec := httptest.NewRecorder()
+	store.handler(h).ServeHTTP(rec, req)
+	var resp errorEnvelope
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Error == nil {
+		t.Fatalf("no error in response: %d", rec.Code)
+	}
+	return rec.Code, resp.Error
+}
+
+func TestP1Handler_MalformedJSON_BadRequest(t *testing.T) {
+	// when
+	code, resp := jsonRequest(t, p1Handler, `{"tekst": "Tere", "sert":`, "")
+
+	// then
+	if code != http.StatusBadRequest || resp.Code != codeInvalidJSON {
+		t.Errorf("unexpected response: %d %v", code, resp)
+	}
+	if resp.Message != errorMessages[codeInvalidJSON][langET] {
+		t.Errorf("unexpected message: %q", resp.Message)
+	}
+}
+
+func TestP1Handler_ShortCertificate_BadRequest(t *testing.T) {
+	// when
+	code, resp := jsonRequest(t, p1Handler, `{"tekst": "Tere", "sert": "PEM"}`, "")
+
+	// then
+	if code != http.StatusBadRequest || resp.Code != codeInvalidCertificate {
+		t.Errorf("unexpected response: %d %v", code, resp)
+	}
+}
+
+func TestP1Handler_TooLarge_RequestEntityTooLarge(t *testing.T) {
+	// given
+	body := `{"tekst": "` + strings.Repeat("a", maxJSONSize) + `"}`
+
+	// when
+	code, resp := jsonRequest(t, p1Handler, body, "")
+
+	// then
+	if code != http.StatusRequestEntityTooLarge || resp.Code != codeBodyTooLarge {
+		t.Errorf("unexpected response: %d %v", code, resp)
+	}
+}
+
+func TestP2Handler_InvalidSignature_EnglishMessage(t *testing.T) {
+	// when
+	code, resp := jsonRequest(t, p2Handler, `{"allkiri": "%%%"}`, "en-US,en;q=0.9")
+
+	// then
+	if code != http.StatusBadRequest || resp.Code != codeInvalidSignature {
+		t.Errorf("unexpected response: %d %v", code, resp)
+	}
+	if resp.Message != errorMessages[codeInvalidSignature][langEN] {
+		t.Errorf("unexpected message: %q", resp.Message)
+	}
+}
+
+func TestDecodeJSON_TrailingData_BadRequest(t *testing.T) {
+	// given
+	req := httptest.NewRequest(http.MethodPost, "/p2", strings.NewReader(`{"allkiri": "YQ=="} {}`))
+	req.Header.Set("Content-Type", "application/json")
+
+	// when
+	var r p2Request
+	err := decodeJSON(httptest.NewRecorder(), req, &r)
+
+	// then
+	if status := errorStatus(err); status != http.StatusBadRequest {
+		t.Errorf("unexpected status: %d (%v)", status, err)
+	}
+}
+
+func TestDecodeJSON_FormBody_UnsupportedMediaType(t *testing.T) {
+	// given
+	req := httptest.NewRequest(http.MethodPost, "/p2", strings.NewReader("allkiri=YQ"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	// when
+	var r p2Request
+	err := decodeJSON(httptest.NewRecorder(), req, &r)
+
+	// then
+	if status := errorStatus(err); status != http.StatusUnsupportedMediaType {
+		t.Errorf("unexpected status: %d (%v)", status, err)
+	}
+}
@@ -0,0 +1,342 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// sessionCookie on sirvikuseansi salajast märki kandva küpsise nimi.
+const sessionCookie = "SiGa-Go-Session"
+
+// sessionTTL on sirvikuseansi kehtivusaeg pärast viimast päringut.
+const sessionTTL = 30 * time.Minute
+
+// maxSessions on seansihoidlas korraga hoitavate seansside (ka aegunud, kuid
+// veel eemaldamata seansside) suurim arv. Seanss luuakse igale küpsiseta
+// päringule, seega ilma piiranguta saaks päringutega serveri mälu täita.
+const maxSessions = 10000
+
+// maxContainerBytes on kõigi seansside üles laaditud konteinerite (vt
+// containerHandler) kogumaht baitides, mida seansihoidla korraga mälus hoiab.
+// Konteiner võib olla kuni maxUploadFileSize suur ja seda hoitakse kuni
+// seansi aegumiseni, seega ilma piiranguta saaks üleslaadimistega serveri
+// mälu täita.
+const maxContainerBytes = 256 << 20
+
+// session on ühe sirviku (kasutaja) seanss. Igal seansil on oma SiGa
+// seansid, nii et samaaegselt allkirjastavad kasutajad ei sega üksteist.
+type session struct {
+	// token on sirvikuseansi salajane märk, mis saadetakse sirvikule
+	// küpsises. Märgi teadja saab seansi üle võtta, seepärast kasutatakse
+	// seda ainult küpsises ja seansihoidlas.
+	token string
+	// sigaID on seansi mittesalajane juhuslik ID, millest tuletatakse SiGa
+	// seansi ID-d. SiGa seansi ID-d võivad sattuda logidesse ja jälgedesse.
+	sigaID  string
+	expires time.Time
+
+	mu sync.Mutex
+	// states hoiab allkirjastamisviiside konteinerite allkirjastamise
+	// olekuid.
+	states map[string]signingState
+	// container on sirvikust üles laaditud olemasolev konteiner, millele
+	// lisatakse allkiri (vt containerHandler).
+	container []byte
+
+	// poll järjestab seansi m-ID olekupäringud SiGa poole.
+	poll sync.Mutex
+}
+
+// signingState on seansi konteineri allkirjastamise olek.
+type signingState int
+
+const (
+	// signingNone: konteinerit ei ole või selle allkirjastamine ebaõnnestus.
+	signingNone signingState = iota
+	// signingPending: konteiner on loodud ja allkirjastamine on pooleli.
+	signingPending
+	// signingSigned: konteiner on allkirjastatud ja allalaaditav.
+	signingSigned
+)
+
+// sigaSession tagastab seansi SiGa seansi ID antud allkirjastamisviisi
+// jaoks ("idcard" või "mid").
+func (s *session) sigaSession(kind string) string {
+	return s.sigaID + ":" + kind
+}
+
+// setState seab allkirjastamisviisi kind konteineri allkirjastamise oleku.
+func (s *session) setState(kind string, state signingState) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.states == nil {
+		s.states = make(map[string]signingState)
+	}
+	s.states[kind] = state
+}
+
+// state tagastab allkirjastamisviisi kind konteineri allkirjastamise oleku.
+func (s *session) state(kind string) signingState {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.states[kind]
+}
+
+// uploadedContainer tagastab seansis üles laaditud konteineri või nil, kui
+// seda ei ole.
+func (s *session) uploadedContainer() []byte {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.container
+}
+
+// sigaSessionKinds on allkirjastamisviisid, millel on oma SiGa seanss.
+var sigaSessionKinds = []string{"idcard", "mid"}
+
+// sessionStore hoiab serveripoolseid sirvikuseansse. Aegunud seansid
+// eemaldatakse ja neile vastavad SiGa konteinerid suletakse.
+type sessionStore struct {
+	mu sync.Mutex
+	// sessions hoiab seansse salajase märgi järgi.
+	sessions map[string]*session
+	max      int
+	// containerBytes on seansside üles laaditud konteinerite kogumaht,
+	// maxContainerBytes selle suurim lubatud väärtus.
+	containerBytes    int
+	maxContainerBytes int
+	ttl               time.Duration
+	// draining: server lõpetab tööd ja uusi allkirjastamisi ei alustata.
+	draining bool
+	now      func() time.Time
+	onExpire func(*session)
+}
+
+// newSessionStore loob seansihoidla. onExpire kutsutakse välja iga aegunud
+// seansi kohta pärast selle eemaldamist.
+func newSessionStore(ttl time.Duration, onExpire func(*session)) *sessionStore {
+	return &sessionStore{
+		sessions:          make(map[string]*session),
+		max:               maxSessions,
+		maxContainerBytes: maxContainerBytes,
+		ttl:               ttl,
+		now:               time.Now,
+		onExpire:          onExpire,
+	}
+}
+
+// randomID moodustab n krüptograafiliselt juhuslikust baidist koosneva ID.
+func randomID(n int) (string, error) {
+	id := make([]byte, n)
+	if _, err := rand.Read(id); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(id), nil
+}
+
+// newSession moodustab uue seansi juhusliku salajase märgi ja SiGa ID-ga.
+func newSession() (*session, error) {
+	token, err := randomID(32)
+	if err != nil {
+		return nil, err
+	}
+	sigaID, err := randomID(16)
+	if err != nil {
+		return nil, err
+	}
+	return &session{token: token, sigaID: sigaID}, nil
+}
+
+// get tagastab märgile token vastava kehtiva seansi või loob uue. Seansi
+// kehtivusaega pikendatakse. Kui hoidlas on juba max seanssi, siis uut seanssi
+// ei looda ja tagastatakse viga.
+func (s *sessionStore) get(token string) (*session, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	sess, ok := s.sessions[token]
+	if !ok || now.After(sess.expires) {
+		if len(s.sessions) >= s.max {
+			return nil, newAPIError(http.StatusServiceUnavailable, codeTooManySessions)
+		}
+		var err error
+		if sess, err = newSession(); err != nil {
+			return nil, err
+		}
+		s.sessions[sess.token] = sess
+	}
+	sess.expires = now.Add(s.ttl)
+	return sess, nil
+}
+
+// sigaIDs tagastab hoidla kehtivate seansside SiGa ID-d.
+func (s *sessionStore) sigaIDs() map[string]bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	ids := make(map[string]bool, len(s.sessions))
+	for _, sess := range s.sessions {
+		if !now.After(sess.expires) {
+			ids[sess.sigaID] = true
+		}
+	}
+	return ids
+}
+
+// setContainer jätab seansis sess meelde üles laaditud konteineri. nil väärtus
+// eemaldab konteineri. Kui konteiner ei mahu seansside konteinerite
+// kogumahu piiri (maxContainerBytes), siis jääb seansi senine konteiner alles
+// ja tagastatakse viga.
+func (s *sessionStore) setContainer(sess *session, container []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sess.mu.Lock()
+	defer sess.mu.Unlock()
+
+	total := s.containerBytes - len(sess.container) + len(container)
+	if len(container) > 0 && total > s.maxContainerBytes {
+		return newAPIError(http.StatusServiceUnavailable, codeTooManyContainers)
+	}
+	s.containerBytes = total
+	sess.container = container
+	return nil
+}
+
+// beginSigning märgib seansi sess allkirjastamisviisi kind allkirjastamise
+// pooleliolevaks. Kui server lõpetab tööd (vt drain), siis uut
+// allkirjastamist ei alustata ja tagastatakse viga.
+func (s *sessionStore) beginSigning(sess *session, kind string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.draining {
+		return newAPIError(http.StatusServiceUnavailable, codeShuttingDown)
+	}
+	sess.setState(kind, signingPending)
+	return nil
+}
+
+// signing tagastab kehtivate seansside pooleliolevate allkirjastamiste arvu.
+func (s *sessionStore) signing() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	var n int
+	for _, sess := range s.sessions {
+		if now.After(sess.expires) {
+			continue
+		}
+		for _, kind := range sigaSessionKinds {
+			if sess.state(kind) == signingPending {
+				n++
+			}
+		}
+	}
+	return n
+}
+
+// drain keelab uute allkirjastamiste alustamise ja ootab, kuni ühegi kehtiva
+// seansi allkirjastamine ei ole pooleli, kontrollides seda iga interval järel.
+// Pooleliolevad allkirjastamised vajavad veel päringuid (ID-kaardi puhul
+// /p2, m-ID puhul /mid/status), seega peab server drain-i ajal päringuid
+// teenindama. Kui ctx lõpeb enne, siis tagastatakse ctx viga.
+func (s *sessionStore) drain(ctx context.Context, interval time.Duration) error {
+	s.mu.Lock()
+	s.draining = true
+	s.mu.Unlock()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for s.signing() > 0 {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+// sweep eemaldab aegunud seansid.
+func (s *sessionStore) sweep() {
+	s.mu.Lock()
+	now := s.now()
+	var expired []*session
+	for token, sess := range s.sessions {
+		if now.After(sess.expires) {
+			expired = append(expired, sess)
+			delete(s.sessions, token)
+			s.containerBytes -= len(sess.uploadedContainer())
+		}
+	}
+	s.mu.Unlock()
+
+	for _, sess := range expired {
+		if s.onExpire != nil {
+			s.onExpire(sess)
+		}
+	}
+}
+
+// run eemaldab perioodiliselt aegunud seansid, kuni ctx lõpeb.
+func (s *sessionStore) run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			s.sweep()
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+type sessionKey struct{}
+
+// sessionFromContext tagastab päringu kontekstis oleva seansi.
+func sessionFromContext(ctx context.Context) *session {
+	sess, _ := ctx.Value(sessionKey{}).(*session)
+	return sess
+}
+
+// handler mähib h-i käsitlejaga, mis leiab või loob päringu seansi,
+// seab seansiküpsise ja lisab seansi päringu konteksti.
+func (s *sessionStore) handler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		var token string
+		if cookie, err := req.Cookie(sessionCookie); err == nil {
+			token = cookie.Value
+		}
+		sess, err := s.get(token)
+		if err != nil {
+			writeError(w, req, "sessionStore", err)
+			return
+		}
+		http.SetCookie(w, &http.Cookie{
+			Name:     sessionCookie,
+			Value:    sess.token,
+			Path:     "/",
+			MaxAge:   int(s.ttl / time.Second),
+			Secure:   true,
+			HttpOnly: true,
+			SameSite: http.SameSiteStrictMode,
+		})
+		h.ServeHTTP(w, req.WithContext(context.WithValue(req.Context(), sessionKey{}, sess)))
+	})
+}
+
+// closeSigaSessions sulgeb aegunud seansi SiGa konteinerid.
+func closeSigaSessions(sess *session) {
+	ctx := context.Background()
+	for _, kind := range sigaSessionKinds {
+		// Viga on oodatav, kui selle allkirjastamisviisiga konteinerit ei
+		// ole: seda ei logita.
+		sigaClient.CloseContainer(ctx, sess.sigaSession(kind))
+	}
+}
@@ -0,0 +1,264 @@
+package main
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/e-gov/SiGa-Go/siga"
+)
+
+// fakeClient on testides kasutatav SiGa klient. Meetodid, mida fakeClient
+// ei asenda, paanitsevad.
+type fakeClient struct {
+	siga.Client
+
+	// container on WriteContainer-i kirjutatav konteiner. Kui see on nil,
+	// siis kirjutatakse konteineriks SiGa seansi ID.
+	container []byte
+	// writeErr on WriteContainer-i tagastatav viga.
+	writeErr error
+
+	// polls on m-ID olekupäringute arv, mille järel allkirjastamine lõpeb.
+	polls int
+	// person ja phone on StartMobileIDSigning-ule antud allkirjastaja.
+	person string
+	phone  string
+
+	// cert on StartRemoteSigning-ule antud allkirjastaja sert.
+	cert []byte
+	// uploaded on UploadContainer-iga üles laaditud konteiner.
+	uploaded []byte
+}
+
+func (c *fakeClient) UploadContainer(ctx context.Context, session string, r io.Reader) error {
+	var err error
+	c.uploaded, err = ioutil.ReadAll(r)
+	return err
+}
+
+func (c *fakeClient) StartRemoteSigning(ctx context.Context, session string, cert []byte) ([]byte, string, error) {
+	c.cert = cert
+	return []byte("hash"), "SHA256", nil
+}
+
+func (c *fakeClient) CreateContainer(ctx context.Context, session string, datafiles ...*siga.DataFile) error {
+	return nil
+}
+
+func (c *fakeClient) StartMobileIDSigning(ctx context.Context, session, person, phone, message string) (string, error) {
+	c.person, c.phone = person, phone
+	return "1234", nil
+}
+
+func (c *fakeClient) RequestMobileIDSigningStatus(ctx context.Context, session string) (bool, error) {
+	c.polls--
+	return c.polls <= 0, nil
+}
+
+func (c *fakeClient) WriteContainer(ctx context.Context, session string, w io.Writer) error {
+	if c.writeErr != nil {
+		return c.writeErr
+	}
+	if c.container == nil {
+		_, err := io.WriteString(w, session)
+		return err
+	}
+	_, err := w.Write(c.container)
+	return err
+}
+
+// withSigaClient seab SiGa kliendiks c. Kutsuja peab tagastatud funktsiooniga
+// eelmise kliendi taastama.
+func withSigaClient(c siga.Client) func() {
+	old := sigaClient
+	sigaClient = c
+	return func() { sigaClient = old }
+}
+
+// sessionRequest teeb päringu req seansi sess nimel seansihoidla store
+// käsitlejaga mähitud käsitlejale h.
+func sessionRequest(store *sessionStore, sess *session, h http.Handler, req *http.Request) *httptest.ResponseRecorder {
+	req.AddCookie(&http.Cookie{Name: sessionCookie, Value: sess.token})
+	rec := httptest.NewRecorder()
+	store.handler(h).ServeHTTP(rec, req)
+	return rec
+}
+
+func TestSessionStore_DifferentBrowsers_IsolatedSessions(t *testing.T) {
+	// given
+	store := newSessionStore(time.Minute, nil)
+	var got []*session
+	h := store.handler(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		got = append(got, sessionFromContext(req.Context()))
+	}))
+
+	// when
+	first := httptest.NewRecorder()
+	h.ServeHTTP(first, httptest.NewRequest(http.MethodPost, "/p1", nil))
+	second := httptest.NewRecorder()
+	h.ServeHTTP(second, httptest.NewRequest(http.MethodPost, "/p1", nil))
+	again := httptest.NewRequest(http.MethodPost, "/p2", nil)
+	again.AddCookie(first.Result().Cookies()[0])
+	h.ServeHTTP(httptest.NewRecorder(), again)
+
+	// then
+	if got[0] == got[1] {
+		t.Error("different browsers share a session")
+	}
+	if got[0].sigaSession("idcard") == got[1].sigaSession("idcard") {
+		t.Error("different browsers share a SiGa session")
+	}
+	if got[2] != got[0] {
+		t.Error("session cookie did not select the existing session")
+	}
+	cookie := first.Result().Cookies()[0]
+	if cookie.Name != sessionCookie || !cookie.HttpOnly || !cookie.Secure {
+		t.Errorf("unexpected session cookie: %v", cookie)
+	}
+	if strings.Contains(got[0].sigaSession("idcard"), cookie.Value) {
+		t.Error("SiGa session contains the session cookie")
+	}
+}
+
+func TestSessionStore_Expired_NewSessionAndOnExpire(t *testing.T) {
+	// given
+	now := time.Now()
+	var expired []*session
+	store := newSessionStore(time.Minute, func(s *session) { expired = append(expired, s) })
+	store.now = func() time.Time { return now }
+	old, err := store.get("")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// when
+	now = now.Add(2 * time.Minute)
+	store.sweep()
+	renewed, err := store.get(old.token)
+
+	// then
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(expired) != 1 || expired[0] != old {
+		t.Errorf("expired sessions: %v", expired)
+	}
+	if renewed.token == old.token || renewed.sigaID == old.sigaID {
+		t.Error("expired session id was reused")
+	}
+}
+
+func TestSessionStore_MaxSessions_ServiceUnavailable(t *testing.T) {
+	// given
+	store := newSessionStore(time.Minute, nil)
+	store.max = 1
+	existing, err := store.get("")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// when
+	_, newErr := store.get("")
+	same, sameErr := store.get(existing.token)
+
+	// then
+	if errorStatus(newErr) != http.StatusServiceUnavailable {
+		t.Errorf("unexpected error: %v", newErr)
+	}
+	if sameErr != nil || same != existing {
+		t.Errorf("existing session not found: %v", sameErr)
+	}
+	if len(store.sessions) != 1 {
+		t.Errorf("unexpected number of sessions: %d", len(store.sessions))
+	}
+}
+
+func TestSessionStore_ContainersFull_ServiceUnavailable(t *testing.T) {
+	// given
+	now := time.Now()
+	store := newSessionStore(time.Minute, nil)
+	store.now = func() time.Time { return now }
+	store.maxContainerBytes = 10
+	first, _ := store.get("")
+	second, _ := store.get("")
+	if err := store.setContainer(first, make([]byte, 6)); err != nil {
+		t.Fatal(err)
+	}
+
+	// when
+	fullErr := store.setContainer(second, make([]byte, 6))
+	replaceErr := store.setContainer(first, make([]byte, 8))
+	now = now.Add(2 * time.Minute)
+	store.sweep()
+	third, _ := store.get("")
+	expiredErr := store.setContainer(third, make([]byte, 10))
+
+	// then
+	if errorStatus(fullErr) != http.StatusServiceUnavailable || second.uploadedContainer() != nil {
+		t.Errorf("container stored over the limit: %v", fullErr)
+	}
+	if replaceErr != nil || len(first.uploadedContainer()) != 8 {
+		t.Errorf("replaced container not released: %v", replaceErr)
+	}
+	if expiredErr != nil || store.containerBytes != 10 {
+		t.Errorf("expired session container not released: %v, %d bytes", expiredErr, store.containerBytes)
+	}
+}
+
+func TestSessionStore_Drain_WaitsForPendingSigning(t *testing.T) {
+	// given
+	store := newSessionStore(time.Minute, nil)
+	signing, _ := store.get("")
+	if err := store.beginSigning(signing, "mid"); err != nil {
+		t.Fatal(err)
+	}
+	drained := make(chan error)
+
+	// when
+	go func() { drained <- store.drain(context.Background(), time.Millisecond) }()
+	time.Sleep(10 * time.Millisecond)
+	other, _ := store.get("")
+	startErr := store.beginSigning(other, "idcard")
+
+	// then
+	select {
+	case err := <-drained:
+		t.Fatalf("drained with pending signing: %v", err)
+	default:
+	}
+	if errorStatus(startErr) != http.StatusServiceUnavailable || other.state("idcard") != signingNone {
+		t.Errorf("signing started while draining: %v", startErr)
+	}
+	signing.setState("mid", signingSigned)
+	select {
+	case err := <-drained:
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Error("not drained after signing finished")
+	}
+}
+
+func TestSessionStore_DrainTimeout_Error(t *testing.T) {
+	// given
+	store := newSessionStore(time.Minute, nil)
+	sess, _ := store.get("")
+	store.beginSigning(sess, "idcard")
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	// when
+	err := store.drain(ctx, time.Millisecond)
+
+	// then
+	if err != context.DeadlineExceeded {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
@@ -0,0 +1,188 @@
+package siga
+
+import (
+	"archive/zip"
+	"bytes"
+	"io"
+	"io/ioutil"
+	"path"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const asicsMimetype = "application/vnd.etsi.asic-s+zip"
+
+// ErrASiCSHashcode is returned when an ASiC-S container is given where a
+// hashcode form conversion is required: SiGa only supports hashcode form for
+// ASiC-E containers.
+var ErrASiCSHashcode = errors.New("ASiC-S container has no hashcode form")
+
+// ContainerType is the type of an associated signature container (ASiC).
+type ContainerType int
+
+// Supported container types.
+const (
+	UnknownContainer ContainerType = iota
+	ASiCE                          // Extended container, multiple data files.
+	ASiCS                          // Simple container, single data file.
+)
+
+// String returns the name of the container type.
+func (t ContainerType) String() string {
+	switch t {
+	case ASiCE:
+		return "ASiC-E"
+	case ASiCS:
+		return "ASiC-S"
+	default:
+		return "unknown"
+	}
+}
+
+// Mimetype returns the contents of the mimetype file of the container type.
+func (t ContainerType) Mimetype() string {
+	switch t {
+	case ASiCE:
+		return asiceMimetype
+	case ASiCS:
+		return asicsMimetype
+	default:
+		return ""
+	}
+}
+
+// Extension returns the conventional file name extension of the container
+// type, including the leading dot.
+func (t ContainerType) Extension() string {
+	switch t {
+	case ASiCE:
+		return ".asice"
+	case ASiCS:
+		return ".asics"
+	default:
+		return ""
+	}
+}
+
+// ContainerInfo describes the contents of a complete signature container.
+type ContainerInfo struct {
+	// Type is the type of the container detected from its mimetype file.
+	Type ContainerType
+
+	// DataFiles are the names of the data files in the container.
+	DataFiles []string
+
+	// Signatures are the names of the signature files in META-INF/.
+	Signatures []string
+
+	// Timestamps are the names of the timestamp token files in META-INF/.
+	// Only ASiC-S containers are expected to contain these.
+	Timestamps []string
+}
+
+// InspectContainer reads a complete signature container from r and describes
+// its contents. It does not validate the signatures or timestamps.
+//
+// The container is checked against the default ZipLimits and for structural
+// problems. See ZipError for the returned errors.
+func InspectContainer(r io.Reader) (*ContainerInfo, error) {
+	src, size, err := toReaderAt(r)
+	if err != nil {
+		return nil, err
+	}
+	reader, err := zip.NewReader(src, size)
+	if err != nil {
+		return nil, errors.Wrap(err, "open zip")
+	}
+	if err := checkZip(reader, ZipLimits{}); err != nil {
+		return nil, err
+	}
+	return inspectZip(reader)
+}
+
+// WrapContainer creates a DataFile with the given name from the complete
+// signature container read from r. This is the conversion path for
+// containers which cannot be augmented directly, e.g., ASiC-S: the result can
+// be passed to Client.CreateContainer to create a new ASiC-E container, which
+// holds the original container as its single data file.
+func WrapContainer(name string, r io.Reader) (*DataFile, error) {
+	contents, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if _, err := InspectContainer(bytes.NewReader(contents)); err != nil {
+		return nil, errors.WithMessage(err, "inspect container")
+	}
+	return NewDataFile(name, bytes.NewReader(contents))
+}
+
+// inspectZip describes the container opened as reader.
+func inspectZip(reader *zip.Reader) (*ContainerInfo, error) {
+	t, err := containerType(reader)
+	if err != nil {
+		return nil, err
+	}
+
+	info := &ContainerInfo{Type: t}
+	for _, file := range reader.File {
+		switch {
+		case file.Name == "mimetype", strings.HasSuffix(file.Name, "/"):
+			// Not a data file nor a signature.
+		case !strings.HasPrefix(file.Name, "META-INF/"):
+			info.DataFiles = append(info.DataFiles, file.Name)
+		case isSignatureFile(file.Name):
+			info.Signatures = append(info.Signatures, file.Name)
+		case isTimestampFile(file.Name):
+			info.Timestamps = append(info.Timestamps, file.Name)
+		}
+	}
+
+	if t == ASiCS && len(info.DataFiles) != 1 {
+		return nil, errors.Errorf("ASiC-S container with %d data files", len(info.DataFiles))
+	}
+	return info, nil
+}
+
+// containerType detects the type of the container opened as reader from the
+// contents of its mimetype file.
+func containerType(reader *zip.Reader) (ContainerType, error) {
+	for _, file := range reader.File {
+		if file.Name != "mimetype" {
+			continue
+		}
+
+		r, err := file.Open()
+		if err != nil {
+			return UnknownContainer, errors.Wrap(err, "open mimetype")
+		}
+		defer r.Close()
+
+		// Limit the read: we are only interested in known values.
+		data, err := ioutil.ReadAll(io.LimitReader(r, 64))
+		if err != nil {
+			return UnknownContainer, errors.Wrap(err, "read mimetype")
+		}
+		switch mimetype := strings.TrimSpace(string(data)); mimetype {
+		case asiceMimetype:
+			return ASiCE, nil
+		case asicsMimetype:
+			return ASiCS, nil
+		default:
+			return UnknownContainer, errors.Errorf("unknown mimetype: %q", mimetype)
+		}
+	}
+	return UnknownContainer, errors.New("missing mimetype")
+}
+
+// isSignatureFile reports if name is a signature file in META-INF/.
+func isSignatureFile(name string) bool {
+	base := path.Base(name)
+	return strings.Contains(base, "signature") &&
+		(strings.HasSuffix(base, ".xml") || strings.HasSuffix(base, ".p7s"))
+}
+
+// isTimestampFile reports if name is a timestamp token file in META-INF/.
+func isTimestampFile(name string) bool {
+	return strings.HasSuffix(name, ".tst")
+}
@@ -0,0 +1,176 @@
+package siga
+
+import (
+	"archive/zip"
+	"bytes"
+	"io/ioutil"
+	"reflect"
+	"testing"
+)
+
+// testZipFile is a file written to a test container by testContainer.
+type testZipFile struct {
+	name     string
+	contents string
+}
+
+// testContainer creates a ZIP-archive with the given files in order. The
+// mimetype file is stored without compression, all other files are deflated.
+func testContainer(t *testing.T, files ...testZipFile) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	for _, file := range files {
+		method := zip.Deflate
+		if file.name == "mimetype" {
+			method = zip.Store
+		}
+		fw, err := w.CreateHeader(&zip.FileHeader{Name: file.name, Method: method})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := fw.Write([]byte(file.contents)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// testZipReader opens a ZIP-archive created by testContainer.
+func testZipReader(t *testing.T, container []byte) *zip.Reader {
+	t.Helper()
+	reader, err := zip.NewReader(bytes.NewReader(container), int64(len(container)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return reader
+}
+
+func TestInspectContainer_ASiCE_Described(t *testing.T) {
+	// given
+	container := testContainer(t,
+		testZipFile{"mimetype", asiceMimetype},
+		testZipFile{"first.txt", "first"},
+		testZipFile{"second.txt", "second"},
+		testZipFile{"META-INF/manifest.xml", "<manifest/>"},
+		testZipFile{"META-INF/signatures0.xml", "<signature/>"},
+	)
+
+	// when
+	info, err := InspectContainer(bytes.NewReader(container))
+
+	// then
+	if err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	expected := &ContainerInfo{
+		Type:       ASiCE,
+		DataFiles:  []string{"first.txt", "second.txt"},
+		Signatures: []string{"META-INF/signatures0.xml"},
+	}
+	if !reflect.DeepEqual(info, expected) {
+		t.Errorf("unexpected info:\n     got: %+v\nexpected: %+v", info, expected)
+	}
+}
+
+func TestInspectContainer_TimestampedASiCS_Described(t *testing.T) {
+	// given
+	container := testContainer(t,
+		testZipFile{"mimetype", asicsMimetype},
+		testZipFile{"document.pdf", "%PDF"},
+		testZipFile{"META-INF/timestamp.tst", "token"},
+	)
+
+	// when
+	info, err := InspectContainer(bytes.NewReader(container))
+
+	// then
+	if err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	expected := &ContainerInfo{
+		Type:       ASiCS,
+		DataFiles:  []string{"document.pdf"},
+		Timestamps: []string{"META-INF/timestamp.tst"},
+	}
+	if !reflect.DeepEqual(info, expected) {
+		t.Errorf("unexpected info:\n     got: %+v\nexpected: %+v", info, expected)
+	}
+}
+
+func TestInspectContainer_ASiCSMultipleDatafiles_Error(t *testing.T) {
+	// given
+	container := testContainer(t,
+		testZipFile{"mimetype", asicsMimetype},
+		testZipFile{"first.txt", "first"},
+		testZipFile{"second.txt", "second"},
+	)
+
+	// when
+	_, err := InspectContainer(bytes.NewReader(container))
+
+	// then
+	if err == nil {
+		t.Fatal("unexpected success")
+	}
+}
+
+func TestInspectContainer_UnknownMimetype_Error(t *testing.T) {
+	// given
+	container := testContainer(t,
+		testZipFile{"mimetype", "application/zip"},
+		testZipFile{"first.txt", "first"},
+	)
+
+	// when
+	_, err := InspectContainer(bytes.NewReader(container))
+
+	// then
+	if err == nil {
+		t.Fatal("unexpected success")
+	}
+}
+
+func TestToHashcode_ASiCS_Error(t *testing.T) {
+	// given
+	container := testContainer(t,
+		testZipFile{"mimetype", asicsMimetype},
+		testZipFile{"document.pdf", "%PDF"},
+		testZipFile{"META-INF/timestamp.tst", "token"},
+	)
+
+	// when
+	_, err := toHashcode(ioutil.Discard, bytes.NewReader(container), int64(len(container)), ZipLimits{})
+
+	// then
+	if err != ErrASiCSHashcode {
+		t.Fatalf("unexpected error:\n     got: %v\nexpected: %v", err, ErrASiCSHashcode)
+	}
+}
+
+func TestWrapContainer_ASiCS_DataFile(t *testing.T) {
+	// given
+	container := testContainer(t,
+		testZipFile{"mimetype", asicsMimetype},
+		testZipFile{"document.pdf", "%PDF"},
+		testZipFile{"META-INF/timestamp.tst", "token"},
+	)
+
+	// when
+	df, err := WrapContainer("document.asics", bytes.NewReader(container))
+
+	// then
+	if err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	if df.Name() != "document.asics" {
+		t.Errorf("unexpected name: %s", df.Name())
+	}
+	if !bytes.Equal(df.contents, container) {
+		t.Error("contents do not match container")
+	}
+}
@@ -0,0 +1,319 @@
+package siga
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/e-gov/SiGa-Go/confutil"
+)
+
+// ErrCircuitOpen is returned without contacting the SiGa service if the
+// circuit breaker is open after consecutive failures.
+var ErrCircuitOpen = errors.New("circuit breaker open")
+
+// ErrRateLimited is returned if a request to the SiGa service cannot be made
+// before the context deadline without exceeding the configured rate.
+var ErrRateLimited = errors.New("rate limit exceeded")
+
+// Default circuit breaker parameters used if the corresponding values in
+// CircuitBreakerConf are zero.
+const (
+	DefaultOpenTimeout    = 30 * time.Second
+	DefaultHalfOpenProbes = 1
+)
+
+// CircuitBreakerConf configures the circuit breaker of the SiGa client.
+//
+// After FailureThreshold consecutive failed requests (network errors and
+// HTTP 5xx or 429 responses) the breaker opens and requests fail with
+// ErrCircuitOpen. After OpenTimeout the breaker becomes half-open and lets
+// HalfOpenProbes concurrent requests through: if one succeeds, then the
+// breaker closes, if one fails, then it opens again.
+type CircuitBreakerConf struct {
+	// FailureThreshold is the number of consecutive failures after which
+	// the breaker opens. If FailureThreshold is zero, then the circuit
+	// breaker is disabled.
+	FailureThreshold int
+
+	// OpenTimeout is the time in seconds the breaker stays open before
+	// becoming half-open. If zero, then DefaultOpenTimeout is used.
+	OpenTimeout confutil.Seconds `json:"OpenTimeoutSeconds"`
+
+	// HalfOpenProbes is the number of concurrent requests let through
+	// while half-open. If zero, then DefaultHalfOpenProbes is used.
+	HalfOpenProbes int
+
+	// OnStateChange, if not nil, is called when the state of the breaker
+	// changes. It must not block.
+	OnStateChange func(from, to BreakerState) `json:"-"`
+}
+
+// BreakerState is the state of a circuit breaker.
+type BreakerState int
+
+// Circuit breaker states.
+const (
+	BreakerClosed BreakerState = iota
+	BreakerOpen
+	BreakerHalfOpen
+)
+
+// String returns the name of the state.
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerClosed:
+		return "closed"
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half-open"
+	default:
+		return "unknown"
+	}
+}
+
+// outcome is the result of a request guarded by the circuit breaker.
+type outcome int
+
+const (
+	outcomeSuccess outcome = iota
+	outcomeFailure
+	outcomeIgnored // The request was canceled by the caller.
+)
+
+// breaker is a circuit breaker. A nil *breaker allows all requests.
+type breaker struct {
+	threshold int
+	timeout   time.Duration
+	probes    int
+	onChange  func(from, to BreakerState)
+	now       func() time.Time
+
+	mu       sync.Mutex
+	state    BreakerState
+	failures int       // Consecutive failures while closed.
+	openedAt time.Time // When the breaker last opened.
+	inflight int       // Probes in flight while half-open.
+	period   int       // Incremented on every state change.
+}
+
+// admission records how a request was allowed by the breaker, so that its
+// outcome only affects the state in which it was allowed.
+type admission struct {
+	probe  bool // The request was allowed as a half-open probe.
+	period int  // The period of the state in which it was allowed.
+}
+
+// newBreaker creates a circuit breaker from conf. It returns nil if the
+// breaker is disabled.
+func newBreaker(conf CircuitBreakerConf) *breaker {
+	if conf.FailureThreshold <= 0 {
+		return nil
+	}
+	b := &breaker{
+		threshold: conf.FailureThreshold,
+		timeout:   conf.OpenTimeout.Or(DefaultOpenTimeout),
+		probes:    conf.HalfOpenProbes,
+		onChange:  conf.OnStateChange,
+		now:       time.Now,
+	}
+	if b.probes <= 0 {
+		b.probes = DefaultHalfOpenProbes
+	}
+	return b
+}
+
+// allow checks if a request may be made. If it returns nil, then done must
+// be called with the returned admission and the outcome of the request.
+func (b *breaker) allow() (admission, error) {
+	if b == nil {
+		return admission{}, nil
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == BreakerOpen {
+		if b.now().Sub(b.openedAt) < b.timeout {
+			return admission{}, errors.WithStack(ErrCircuitOpen)
+		}
+		b.setState(BreakerHalfOpen)
+	}
+	if b.state == BreakerHalfOpen {
+		if b.inflight >= b.probes {
+			return admission{}, errors.WithStack(ErrCircuitOpen)
+		}
+		b.inflight++
+		return admission{probe: true, period: b.period}, nil
+	}
+	return admission{period: b.period}, nil
+}
+
+// done records the outcome of a request allowed by allow. The outcome of a
+// request which finishes after the state has changed is ignored: e.g., a
+// request allowed while closed does not change the probes of a later
+// half-open state.
+func (b *breaker) done(a admission, result outcome) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if a.period != b.period {
+		return
+	}
+	switch b.state {
+	case BreakerClosed:
+		switch result {
+		case outcomeSuccess:
+			b.failures = 0
+		case outcomeFailure:
+			if b.failures++; b.failures >= b.threshold {
+				b.open()
+			}
+		}
+	case BreakerHalfOpen:
+		if !a.probe {
+			return
+		}
+		b.inflight--
+		switch result {
+		case outcomeSuccess:
+			b.failures = 0
+			b.inflight = 0
+			b.setState(BreakerClosed)
+		case outcomeFailure:
+			b.open()
+		}
+	}
+}
+
+func (b *breaker) open() {
+	b.openedAt = b.now()
+	b.failures = 0
+	b.inflight = 0
+	b.setState(BreakerOpen)
+}
+
+func (b *breaker) setState(state BreakerState) {
+	if from := b.state; from != state {
+		b.state = state
+		b.period++
+		if b.onChange != nil {
+			b.onChange(from, state)
+		}
+	}
+}
+
+// RateLimitConf configures the token bucket rate limiter of the SiGa client.
+type RateLimitConf struct {
+	// Rate is the sustained number of requests per second allowed to be
+	// made to the SiGa service. If Rate is zero, then requests are not
+	// rate limited.
+	Rate float64
+
+	// Burst is the maximum number of requests which can be made at once.
+	// If Burst is zero, then the smallest whole number not less than Rate
+	// is used.
+	Burst int
+
+	// OnLimited, if not nil, is called when a request has to wait for
+	// wait before it can be made. It must not block.
+	OnLimited func(wait time.Duration) `json:"-"`
+}
+
+// limiter is a token bucket rate limiter. A nil *limiter allows all
+// requests immediately.
+type limiter struct {
+	rate      float64 // Tokens per second.
+	burst     float64
+	onLimited func(time.Duration)
+	now       func() time.Time
+
+	mu     sync.Mutex
+	tokens float64 // Can be negative if tokens have been reserved.
+	last   time.Time
+}
+
+// newLimiter creates a rate limiter from conf. It returns nil if rate
+// limiting is disabled.
+func newLimiter(conf RateLimitConf) *limiter {
+	if conf.Rate <= 0 {
+		return nil
+	}
+	burst := float64(conf.Burst)
+	if burst <= 0 {
+		burst = math.Ceil(conf.Rate)
+	}
+	return &limiter{
+		rate:      conf.Rate,
+		burst:     burst,
+		onLimited: conf.OnLimited,
+		now:       time.Now,
+		tokens:    burst,
+	}
+}
+
+// wait blocks until a request may be made or ctx is done. If ctx has a
+// deadline before the request could be made, then wait returns
+// ErrRateLimited immediately.
+func (l *limiter) wait(ctx context.Context) error {
+	if l == nil {
+		return nil
+	}
+	delay, ok := l.reserve(ctx)
+	if !ok {
+		return errors.WithStack(ErrRateLimited)
+	}
+	if delay <= 0 {
+		return nil
+	}
+	if l.onLimited != nil {
+		l.onLimited(delay)
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		l.cancel()
+		return errors.WithStack(ctx.Err())
+	}
+}
+
+// reserve takes a token and returns the time to wait until it is
+// available. It returns false and takes no token if the wait would exceed
+// the deadline of ctx.
+func (l *limiter) reserve(ctx context.Context) (time.Duration, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	if !l.last.IsZero() {
+		l.tokens = math.Min(l.burst, l.tokens+now.Sub(l.last).Seconds()*l.rate)
+	}
+	l.last = now
+
+	var delay time.Duration
+	if l.tokens < 1 {
+		delay = time.Duration((1 - l.tokens) / l.rate * float64(time.Second))
+	}
+	if deadline, ok := ctx.Deadline(); ok && now.Add(delay).After(deadline) {
+		return 0, false
+	}
+	l.tokens--
+	return delay, true
+}
+
+// cancel returns a token reserved by a canceled wait.
+func (l *limiter) cancel() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.tokens = math.Min(l.burst, l.tokens+1)
+}
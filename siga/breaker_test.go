@@ -0,0 +1,180 @@
+package siga
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/e-gov/SiGa-Go/confutil"
+)
+
+func TestBreaker_ConsecutiveFailures_OpensAndRecovers(t *testing.T) {
+	// given
+	now := time.Unix(0, 0)
+	var changes []string
+	b := newBreaker(CircuitBreakerConf{
+		FailureThreshold: 2,
+		OpenTimeout:      confutil.Seconds(10 * time.Second),
+		OnStateChange: func(from, to BreakerState) {
+			changes = append(changes, from.String()+"->"+to.String())
+		},
+	})
+	b.now = func() time.Time { return now }
+	request := func(result outcome) error {
+		admitted, err := b.allow()
+		if err != nil {
+			return err
+		}
+		b.done(admitted, result)
+		return nil
+	}
+
+	// when
+	request(outcomeFailure)
+	request(outcomeSuccess)
+	request(outcomeFailure)
+	request(outcomeFailure)
+	_, openErr := b.allow()
+	now = now.Add(10 * time.Second)
+	probe, probeErr := b.allow()
+	_, concurrentErr := b.allow()
+	b.done(probe, outcomeSuccess)
+
+	// then
+	if !errors.Is(openErr, ErrCircuitOpen) {
+		t.Errorf("expected open breaker, got %v", openErr)
+	}
+	if probeErr != nil {
+		t.Errorf("expected half-open probe, got %v", probeErr)
+	}
+	if !errors.Is(concurrentErr, ErrCircuitOpen) {
+		t.Errorf("expected single half-open probe, got %v", concurrentErr)
+	}
+	expected := []string{"closed->open", "open->half-open", "half-open->closed"}
+	if len(changes) != len(expected) {
+		t.Fatalf("unexpected state changes: %v", changes)
+	}
+	for i := range expected {
+		if changes[i] != expected[i] {
+			t.Errorf("unexpected state changes: %v", changes)
+		}
+	}
+}
+
+func TestBreaker_FailedProbe_Reopens(t *testing.T) {
+	// given
+	now := time.Unix(0, 0)
+	b := newBreaker(CircuitBreakerConf{FailureThreshold: 1})
+	b.now = func() time.Time { return now }
+	admitted, _ := b.allow()
+	b.done(admitted, outcomeFailure)
+	now = now.Add(DefaultOpenTimeout)
+
+	// when
+	probe, _ := b.allow()
+	b.done(probe, outcomeFailure)
+
+	// then
+	if _, err := b.allow(); !errors.Is(err, ErrCircuitOpen) {
+		t.Errorf("expected open breaker, got %v", err)
+	}
+}
+
+func TestBreaker_LateRequestWhileHalfOpen_Ignored(t *testing.T) {
+	// given
+	now := time.Unix(0, 0)
+	b := newBreaker(CircuitBreakerConf{FailureThreshold: 1})
+	b.now = func() time.Time { return now }
+	late, _ := b.allow()
+	failed, _ := b.allow()
+	b.done(failed, outcomeFailure)
+	now = now.Add(DefaultOpenTimeout)
+	probe, probeErr := b.allow()
+
+	// when
+	b.done(late, outcomeFailure)
+	_, concurrentErr := b.allow()
+	b.done(probe, outcomeSuccess)
+
+	// then
+	if probeErr != nil {
+		t.Fatalf("expected half-open probe, got %v", probeErr)
+	}
+	if !errors.Is(concurrentErr, ErrCircuitOpen) {
+		t.Errorf("late request freed a probe slot: %v", concurrentErr)
+	}
+	if b.state != BreakerClosed {
+		t.Errorf("late request changed half-open state: %s", b.state)
+	}
+}
+
+func TestLimiter_BurstExhausted_Waits(t *testing.T) {
+	// given
+	now := time.Unix(0, 0)
+	l := newLimiter(RateLimitConf{Rate: 2, Burst: 2})
+	l.now = func() time.Time { return now }
+
+	// when
+	first, _ := l.reserve(context.Background())
+	second, _ := l.reserve(context.Background())
+	third, _ := l.reserve(context.Background())
+	now = now.Add(time.Second)
+	fourth, _ := l.reserve(context.Background())
+
+	// then
+	if first != 0 || second != 0 {
+		t.Errorf("burst delayed: %v, %v", first, second)
+	}
+	if third != 500*time.Millisecond {
+		t.Errorf("unexpected delay: %v", third)
+	}
+	if fourth != 0 {
+		t.Errorf("unexpected delay after refill: %v", fourth)
+	}
+}
+
+func TestLimiter_DeadlineBeforeToken_RateLimited(t *testing.T) {
+	// given
+	l := newLimiter(RateLimitConf{Rate: 0.1})
+	l.wait(context.Background())
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	// when
+	err := l.wait(ctx)
+
+	// then
+	if !errors.Is(err, ErrRateLimited) {
+		t.Errorf("expected rate limit error, got %v", err)
+	}
+}
+
+func TestHTTPClientDo_ServerErrors_CircuitOpens(t *testing.T) {
+	// given
+	var requests int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+	c := testHTTPClient(srv, time.Now)
+	c.breaker = newBreaker(CircuitBreakerConf{FailureThreshold: 2})
+
+	// when
+	for i := 0; i < 3; i++ {
+		c.do(context.Background(), http.MethodGet, "/test", nil, nil)
+	}
+	err := c.do(context.Background(), http.MethodGet, "/test", nil, nil)
+
+	// then
+	if !errors.Is(err, ErrCircuitOpen) {
+		t.Errorf("expected open circuit, got %v", err)
+	}
+	if requests != 2 {
+		t.Errorf("unexpected number of requests: %d", requests)
+	}
+}
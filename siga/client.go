@@ -5,11 +5,10 @@ import (
 	"context"
 	"crypto/sha512"
 	"encoding/base64"
-	"fmt"
 	"io"
-	"log"
 	"net/http"
 	"net/url"
+	"time"
 
 	"github.com/pkg/errors"
 
@@ -29,6 +28,10 @@ type Client interface {
 	// UploadContainer uploads an existing container for the specified
 	// session identifier. It will close any existing container related to
 	// this session identifier.
+	//
+	// Only ASiC-E containers can be uploaded: for ASiC-S containers it
+	// returns ErrASiCSHashcode. Use WrapContainer to create a new ASiC-E
+	// container around those instead.
 	UploadContainer(ctx context.Context, session string, r io.Reader) error
 
 	// StartRemoteSigning initiates signing of the container using external
@@ -70,6 +73,11 @@ type Client interface {
 	// related to the specified session identifier.
 	CloseContainer(ctx context.Context, session string) error
 
+	// CloseAllContainers closes all open containers known to the client,
+	// e.g., before shutting down. If closing a container fails, then it
+	// still continues with the remaining ones.
+	CloseAllContainers(ctx context.Context) error
+
 	// Close frees any resources connected with the client.
 	Close() error
 }
@@ -79,6 +87,7 @@ type client struct {
 	storage  storage
 	profile  string
 	language string
+	limits   ZipLimits
 }
 
 // NewClient moodustab moodustab SiGa-ga suhtlemiseks HTTPS kliendi.
@@ -89,7 +98,14 @@ func NewClient(conf Conf) (Client, error) {
 		return nil, err
 	}
 	c.storage = newMemStorage()
-	return c, nil
+	var client Client = c
+	if conf.Metrics != nil {
+		client = metricsClient{Client: client, metrics: conf.Metrics}
+	}
+	if conf.Tracer != nil {
+		client = tracingClient{Client: client, tracer: conf.Tracer}
+	}
+	return client, nil
 }
 
 // newClientWithoutStorage on sisemine abif-n SiGa HTTPS kliendi moodustamiseks.
@@ -97,6 +113,7 @@ func newClientWithoutStorage(conf Conf) (*client, error) {
 	c := &client{
 		profile:  conf.SignatureProfile,
 		language: conf.MIDLanguage,
+		limits:   conf.ZipLimits,
 	}
 	if c.profile == "" {
 		c.profile = "LT"
@@ -130,16 +147,21 @@ func (c *client) CreateContainer(
 
 	// Sule eelmine konteiner, kui see eksisteerib.	
 	if err := c.closeContainer(ctx, session, false); err != nil {
-		// log.Error().WithError(err).Log(ctx, "close_old_container_error")
+		c.http.log.Log(ctx, LevelError, "close_old_container_error", Fields{"error": err})
 		// Continue with creating the container.
 	}
 
 	var s status // Konteineri olek.
 	// Kogu SiGa-sse saadetav metateave andmefailide kohta.
 	var meta []dataFileMeta
+	// Jäta meelde andmefailide ZIP-metaandmed, et konteineri väljund oleks
+	// iga kord sama.
+	s.zip = make(map[string]zipMeta, len(datafiles))
+	now := time.Now()
 	for _, datafile := range datafiles {
 		s.filenames = append(s.filenames, datafile.meta.Name)
 		meta = append(meta, datafile.meta)
+		s.zip[datafile.meta.Name] = *orZipMeta(datafile.zip, now)
 	}
 
 	// Valmista ette päring SiGa poole.
@@ -159,7 +181,10 @@ func (c *client) CreateContainer(
 	// Salvesta SiGa-st saadud konteineri ID.
 	s.containerID = resp.ContainerID
 
-	log.Println("CreateContainer: SiGa-s loodud konteiner ID: ", s.containerID)
+	c.http.log.Log(ctx, LevelInfo, "container_created", Fields{
+		"containerId": s.containerID,
+		"datafiles":   len(datafiles),
+	})
 
 	if err := c.storage.putStatus(ctx, session, s); err != nil {
 		// Ignore SiGa delete error: best-effort attempt to clean up.
@@ -198,13 +223,16 @@ func (c *client) UploadContainer(ctx context.Context, session string, r io.Reade
 	// forZipInputStream for SiGa to accept the hashcode container.
 	w := forZipInputStream(&hashcode)
 
-	datafiles, err := toHashcode(w, src, size)
+	datafiles, err := toHashcode(w, src, size, c.limits)
 	if err != nil {
 		return err
 	}
+	if err := w.Close(); err != nil {
+		return errors.WithMessage(err, "normalize zip")
+	}
 
 	if err := c.closeContainer(ctx, session, false); err != nil {
-		// log.Error().WithError(err).Log(ctx, "close_old_container_error")
+		c.http.log.Log(ctx, LevelError, "close_old_container_error", Fields{"error": err})
 		// Continue with uploading the container.
 	}
 
@@ -220,11 +248,16 @@ func (c *client) UploadContainer(ctx context.Context, session string, r io.Reade
 	}
 
 	s := status{containerID: resp.ContainerID}
-	// LISATUD:
-	fmt.Println("Konteiner üles laetud. ContainerID:", resp.ContainerID)
+	c.http.log.Log(ctx, LevelInfo, "container_uploaded", Fields{
+		"containerId": s.containerID,
+		"datafiles":   len(datafiles),
+	})
 
+	s.zip = make(map[string]zipMeta, len(datafiles))
+	now := time.Now()
 	for _, datafile := range datafiles {
 		s.filenames = append(s.filenames, datafile.meta.Name)
+		s.zip[datafile.meta.Name] = *orZipMeta(datafile.zip, now)
 	}
 	if err := c.storage.putStatus(ctx, session, s); err != nil {
 		// Ignore SiGa delete error: best-effort attempt to clean up.
@@ -395,6 +428,7 @@ func (c *client) RequestMobileIDSigningStatus(ctx context.Context, session strin
 	if err := c.http.do(ctx, http.MethodGet, uri, nil, &resp); err != nil {
 		return false, errors.WithMessage(err, "get siga")
 	}
+	c.http.metrics.observeMobileID(resp.Status)
 
 	switch resp.Status {
 	case "SIGNATURE":
@@ -427,16 +461,16 @@ func (c *client) WriteContainer(
 
 	// Valmista ette konteineri SiGa-st allalaadimise päring.
 	uri := "/hashcodecontainers/" + url.PathEscape(s.containerID)
-	// Valmista ette vastuse struktuur.
-	var resp struct {
-		Container []byte `json:"container"`
-	}
-	// Täida konteineri allalaadimise päring (GET).
-	if err := c.http.do(ctx, http.MethodGet, uri, nil, &resp); err != nil {
+	// Täida konteineri allalaadimise päring (GET). Dekodeeri räsikujul
+	// konteiner vastusest voona, et seda ei hoitaks mälus mitmes koopias.
+	// ZIP-arhiivi lugemiseks on vaja juhupöördust, seega üks koopia jääb.
+	var container bytes.Buffer
+	if err := c.http.doFunc(ctx, http.MethodGet, uri, nil, func(body io.Reader) error {
+		return decodeBase64Field(&container, body, "container")
+	}); err != nil {
 		return errors.WithMessage(err, "get siga")
 	}
-	// Eralda vastusest räsikujul konteiner.
-	hashcode := bytes.NewReader(resp.Container)
+	hashcode := bytes.NewReader(container.Bytes())
 
 	// Võta seansiolekukirjest andmefailid, kogu need massiivi datafiles.
 	datafiles := make([]*DataFile, 0, len(s.filenames))
@@ -445,7 +479,11 @@ func (c *client) WriteContainer(
 		if err != nil {
 			return errors.WithMessagef(err, "get data %s", filename)
 		}
-		datafiles = append(datafiles, bytesDataFile(filename, data))
+		datafile := bytesDataFile(filename, data)
+		if meta, ok := s.zip[filename]; ok {
+			datafile.zip = &meta
+		}
+		datafiles = append(datafiles, datafile)
 	}
 
 	// Salvesta andmefailid räsikujul konteinerisse (hashcode), moodustades sellega
@@ -461,6 +499,31 @@ func (c *client) CloseContainer(ctx context.Context, session string) error {
 	return c.closeContainer(ctx, session, true)
 }
 
+// CloseAllContainers closes the containers of all sessions in SiGa client
+// storage. The first error is returned along with the number of containers
+// which could not be closed.
+func (c *client) CloseAllContainers(ctx context.Context) error {
+	sessions, err := c.storage.sessions(ctx)
+	if err != nil {
+		return errors.WithMessage(err, "list sessions")
+	}
+	var first error
+	var failed int
+	for _, session := range sessions {
+		if err := c.closeContainer(ctx, session, false); err != nil {
+			c.http.log.Log(ctx, LevelError, "close_container_error", Fields{"error": err})
+			if first == nil {
+				first = err
+			}
+			failed++
+		}
+	}
+	if first != nil {
+		return errors.WithMessagef(first, "close %d of %d containers", failed, len(sessions))
+	}
+	return nil
+}
+
 // closeContainer on konteineri sulgemise (kustutamise) abif-n.
 func (c *client) closeContainer(ctx context.Context, session string, mandatory bool) error {
 	// Leia seansiolekukirje.
@@ -487,6 +550,8 @@ func (c *client) closeContainer(ctx context.Context, session string, mandatory b
 		}
 	}
 
+	c.http.log.Log(ctx, LevelInfo, "container_closed", Fields{"containerId": s.containerID})
+
 	// Lõpuks kustuta seansiolekukirje.
 	return errors.WithMessage(c.storage.removeStatus(ctx, session), "remove status")
 }
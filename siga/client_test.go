@@ -0,0 +1,282 @@
+package siga_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io/ioutil"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/e-gov/SiGa-Go/siga"
+	"github.com/e-gov/SiGa-Go/sigatest"
+)
+
+// testClient returns a SiGa client connected to a new SiGa emulator. The
+// returned function closes both.
+func testClient(t *testing.T) (siga.Client, *sigatest.Server, func()) {
+	t.Helper()
+	srv := sigatest.NewServer()
+	conf := srv.Conf()
+	conf.Logger = siga.NopLogger
+	c, err := siga.NewClient(conf)
+	if err != nil {
+		srv.Close()
+		t.Fatal(err)
+	}
+	return c, srv, func() {
+		c.Close()
+		srv.Close()
+	}
+}
+
+func testDataFile(t *testing.T) *siga.DataFile {
+	t.Helper()
+	datafile, err := siga.NewDataFile("test.txt", strings.NewReader("Hello, SiGa!"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return datafile
+}
+
+// mobileIDContainer signs a new container with Mobile-ID and returns it.
+func mobileIDContainer(t *testing.T, c siga.Client, session string) []byte {
+	t.Helper()
+	ctx := context.Background()
+	if err := c.CreateContainer(ctx, session, testDataFile(t)); err != nil {
+		t.Fatal("create container:", err)
+	}
+	if _, err := c.StartMobileIDSigning(ctx, session, "60001019906", "+37200000766", "Test"); err != nil {
+		t.Fatal("start Mobile-ID signing:", err)
+	}
+	for done := false; !done; {
+		var err error
+		if done, err = c.RequestMobileIDSigningStatus(ctx, session); err != nil {
+			t.Fatal("request Mobile-ID signing status:", err)
+		}
+	}
+	var container bytes.Buffer
+	if err := c.WriteContainer(ctx, session, &container); err != nil {
+		t.Fatal("write container:", err)
+	}
+	return container.Bytes()
+}
+
+func assertSigned(t *testing.T, container []byte, signatures int) {
+	t.Helper()
+	info, err := siga.InspectContainer(bytes.NewReader(container))
+	if err != nil {
+		t.Fatal("inspect container:", err)
+	}
+	if info.Type != siga.ASiCE || len(info.DataFiles) != 1 || info.DataFiles[0] != "test.txt" {
+		t.Errorf("unexpected container: %+v", info)
+	}
+	if len(info.Signatures) != signatures {
+		t.Errorf("unexpected signatures: %v", info.Signatures)
+	}
+}
+
+func TestClient_RemoteSigning_Succeeds(t *testing.T) {
+	// given
+	c, _, done := testClient(t)
+	defer done()
+	ctx := context.Background()
+	const session = "TestClient_RemoteSigning_Succeeds"
+	key, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "TEST,SIGA,60001019906"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	cert, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.CreateContainer(ctx, session, testDataFile(t)); err != nil {
+		t.Fatal("create container:", err)
+	}
+
+	// when
+	hash, algorithm, err := c.StartRemoteSigning(ctx, session, cert)
+	if err != nil {
+		t.Fatal("start remote signing:", err)
+	}
+	r, s, err := ecdsa.Sign(rand.Reader, key, hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signature := make([]byte, 96) // XML-DSig format: r || s.
+	rb, sb := r.Bytes(), s.Bytes()
+	copy(signature[48-len(rb):48], rb)
+	copy(signature[96-len(sb):], sb)
+	if err := c.FinalizeRemoteSigning(ctx, session, signature); err != nil {
+		t.Fatal("finalize remote signing:", err)
+	}
+	var container bytes.Buffer
+	err = c.WriteContainer(ctx, session, &container)
+
+	// then
+	if err != nil {
+		t.Fatal("write container:", err)
+	}
+	if algorithm != "SHA-512" {
+		t.Errorf("unexpected algorithm: %s", algorithm)
+	}
+	assertSigned(t, container.Bytes(), 1)
+}
+
+func TestClient_InvalidRemoteSignature_Fails(t *testing.T) {
+	// given
+	c, _, done := testClient(t)
+	defer done()
+	ctx := context.Background()
+	const session = "TestClient_InvalidRemoteSignature_Fails"
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	template := &x509.Certificate{SerialNumber: big.NewInt(1), NotAfter: time.Now().Add(time.Hour)}
+	cert, _ := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err := c.CreateContainer(ctx, session, testDataFile(t)); err != nil {
+		t.Fatal("create container:", err)
+	}
+	if _, _, err := c.StartRemoteSigning(ctx, session, cert); err != nil {
+		t.Fatal("start remote signing:", err)
+	}
+
+	// when
+	err := c.FinalizeRemoteSigning(ctx, session, make([]byte, 64))
+
+	// then
+	if err == nil || !strings.Contains(err.Error(), "INVALID_SIGNATURE_EXCEPTION") {
+		t.Errorf("expected invalid signature error, got %v", err)
+	}
+}
+
+func TestClient_MobileIDSigning_Succeeds(t *testing.T) {
+	// given
+	c, srv, done := testClient(t)
+	defer done()
+	srv.MobileIDPolls = 2
+
+	// when
+	container := mobileIDContainer(t, c, "TestClient_MobileIDSigning_Succeeds")
+
+	// then
+	assertSigned(t, container, 1)
+}
+
+func TestClient_UploadContainer_Succeeds(t *testing.T) {
+	// given
+	c, _, done := testClient(t)
+	defer done()
+	ctx := context.Background()
+	const session = "TestClient_UploadContainer_Succeeds"
+	container := mobileIDContainer(t, c, session)
+
+	// when
+	err := c.UploadContainer(ctx, session, bytes.NewReader(container))
+
+	// then
+	if err != nil {
+		t.Fatal("upload container:", err)
+	}
+	var written bytes.Buffer
+	if err := c.WriteContainer(ctx, session, &written); err != nil {
+		t.Fatal("write container:", err)
+	}
+	assertSigned(t, written.Bytes(), 1)
+}
+
+func TestClient_CloseContainer_Deleted(t *testing.T) {
+	// given
+	c, srv, done := testClient(t)
+	defer done()
+	ctx := context.Background()
+	const session = "TestClient_CloseContainer_Deleted"
+	if err := c.CreateContainer(ctx, session, testDataFile(t)); err != nil {
+		t.Fatal("create container:", err)
+	}
+
+	// when
+	err := c.CloseContainer(ctx, session)
+
+	// then
+	if err != nil {
+		t.Fatal("close container:", err)
+	}
+	if n := srv.Containers(); n != 0 {
+		t.Errorf("%d containers left in SiGa", n)
+	}
+}
+
+func TestClient_CloseAllContainers_AllDeleted(t *testing.T) {
+	// given
+	c, srv, done := testClient(t)
+	defer done()
+	ctx := context.Background()
+	sessions := []string{"first", "second", "third"}
+	for _, session := range sessions {
+		if err := c.CreateContainer(ctx, session, testDataFile(t)); err != nil {
+			t.Fatal("create container:", err)
+		}
+	}
+
+	// when
+	err := c.CloseAllContainers(ctx)
+
+	// then
+	if err != nil {
+		t.Fatal("close all containers:", err)
+	}
+	if n := srv.Containers(); n != 0 {
+		t.Errorf("%d containers left in SiGa", n)
+	}
+	if err := c.WriteContainer(ctx, sessions[0], ioutil.Discard); err == nil {
+		t.Error("closed container still in storage")
+	}
+}
+
+func TestClient_WrongServiceKey_Unauthorized(t *testing.T) {
+	// given
+	srv := sigatest.NewServer()
+	defer srv.Close()
+	conf := srv.Conf()
+	conf.ServiceKey = "wrong"
+	conf.Logger = siga.NopLogger
+	c, err := siga.NewClient(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	// when
+	err = c.CreateContainer(context.Background(), "TestClient_WrongServiceKey_Unauthorized", testDataFile(t))
+
+	// then
+	if err == nil || !strings.Contains(err.Error(), "AUTHORIZATION_ERROR") {
+		t.Errorf("expected authorization error, got %v", err)
+	}
+}
+
+func TestClient_ServerClockSkew_Compensated(t *testing.T) {
+	// given
+	c, srv, done := testClient(t)
+	defer done()
+	srv.SetClockSkew(time.Hour)
+
+	// when
+	err := c.CreateContainer(context.Background(), "TestClient_ServerClockSkew_Compensated", testDataFile(t))
+
+	// then
+	if err != nil {
+		t.Fatal("create container:", err)
+	}
+}
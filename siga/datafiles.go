@@ -7,6 +7,8 @@ import (
 	"encoding/base64"
 	"io"
 	"io/ioutil"
+	"mime"
+	"net/http"
 	"os"
 	"path/filepath"
 	"strings"
@@ -29,6 +31,16 @@ type DataFile struct {
 	// If a new usage pattern comes up, where the file could be streamed
 	// instead, then change this.
 	contents []byte
+
+	// zip is the ZIP-archive metadata of the DataFile if it was read from
+	// a container or remembered by the client. If nil, then defaults are
+	// used when writing the DataFile into a container.
+	zip *zipMeta
+
+	// mediaType is the media type of the DataFile. It is not sent to SiGa:
+	// the hashcode container API has no media type field, so the manifest
+	// of a signed container lists the media types which SiGa used.
+	mediaType string
 }
 
 type dataFileMeta struct {
@@ -38,11 +50,26 @@ type dataFileMeta struct {
 	Size   int    `json:"fileSize"`
 }
 
-// NewDataFile creates a DataFile from a name and data read from reader.
+// NewDataFile creates a DataFile from a name and data read from reader. The
+// media type of the DataFile is detected from the name and contents.
 func NewDataFile(name string, reader io.Reader) (*DataFile, error) {
+	return NewTypedDataFile(name, "", reader)
+}
+
+// NewTypedDataFile creates a DataFile from a name, media type, and data read
+// from reader. If mediaType is empty, then it is detected from the name and
+// contents.
+func NewTypedDataFile(name, mediaType string, reader io.Reader) (*DataFile, error) {
 	if name == "" || strings.ContainsRune(name, '/') {
 		return nil, errors.Errorf("invalid name: %s", name)
 	}
+	if mediaType != "" {
+		parsed, _, err := mime.ParseMediaType(mediaType)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid media type: %s", mediaType)
+		}
+		mediaType = parsed
+	}
 	df := &DataFile{meta: dataFileMeta{Name: name}}
 
 	// Calculate hashes while reading the contents of the datafile.
@@ -57,6 +84,10 @@ func NewDataFile(name string, reader io.Reader) (*DataFile, error) {
 	df.meta.SHA256 = base64.StdEncoding.EncodeToString(sum256.Sum(nil))
 	df.meta.SHA512 = base64.StdEncoding.EncodeToString(sum512.Sum(nil))
 	df.meta.Size = len(df.contents)
+	df.mediaType = mediaType
+	if df.mediaType == "" {
+		df.mediaType = detectMediaType(name, df.contents)
+	}
 	return df, nil
 }
 
@@ -86,13 +117,36 @@ func bytesDataFile(name string, contents []byte) *DataFile {
 			SHA512: base64.StdEncoding.EncodeToString(sum512[:]),
 			Size:   len(contents),
 		},
-		contents: contents,
+		contents:  contents,
+		mediaType: detectMediaType(name, contents),
 	}
+}
 
+// detectMediaType detects the media type of a data file. The file name
+// extension is preferred, since content sniffing cannot tell apart many
+// formats (e.g., office documents are all ZIP-archives). Parameters, such as
+// charset, are dropped.
+func detectMediaType(name string, contents []byte) string {
+	mediaType := mime.TypeByExtension(filepath.Ext(name))
+	if mediaType == "" {
+		mediaType = http.DetectContentType(contents)
+	}
+	if parsed, _, err := mime.ParseMediaType(mediaType); err == nil {
+		return parsed
+	}
+	return defaultMediaType
 }
 
+// defaultMediaType is the media type of data files in unknown format.
+const defaultMediaType = "application/octet-stream"
+
 // Name returns the name of the DataFile.
 func (f *DataFile) Name() string { return f.meta.Name }
 
+// MediaType returns the media type of the DataFile: given explicitly,
+// detected from the name and contents, or read from the manifest of the
+// container which contained it. The media type is not sent to SiGa.
+func (f *DataFile) MediaType() string { return f.mediaType }
+
 // Data returns a Reader for reading the contents of the DataFile.
 func (f *DataFile) Data() io.Reader { return bytes.NewReader(f.contents) }
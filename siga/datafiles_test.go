@@ -0,0 +1,42 @@
+package siga
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestNewDataFile_KnownExtension_MediaTypeFromExtension(t *testing.T) {
+	df, err := NewDataFile("document.pdf", strings.NewReader("not really a PDF"))
+	if err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	if df.MediaType() != "application/pdf" {
+		t.Errorf("unexpected media type: %s", df.MediaType())
+	}
+}
+
+func TestNewDataFile_UnknownExtension_MediaTypeSniffed(t *testing.T) {
+	df, err := NewDataFile("image", strings.NewReader("\x89PNG\x0D\x0A\x1A\x0A"))
+	if err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	if df.MediaType() != "image/png" {
+		t.Errorf("unexpected media type: %s", df.MediaType())
+	}
+}
+
+func TestNewTypedDataFile_Explicit_ParametersDropped(t *testing.T) {
+	df, err := NewTypedDataFile("document", "text/plain; charset=utf-8", strings.NewReader("text"))
+	if err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	if df.MediaType() != "text/plain" {
+		t.Errorf("unexpected media type: %s", df.MediaType())
+	}
+}
+
+func TestNewTypedDataFile_Invalid_Error(t *testing.T) {
+	if _, err := NewTypedDataFile("document", "not a media type", strings.NewReader("text")); err == nil {
+		t.Fatal("unexpected success")
+	}
+}
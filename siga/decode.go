@@ -0,0 +1,140 @@
+package siga
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+
+	"github.com/pkg/errors"
+)
+
+// ErrResponseTooLarge is returned if a response body from the SiGa service
+// exceeds the configured MaxResponseSize.
+var ErrResponseTooLarge = errors.New("response too large")
+
+// limitBody wraps body so that reading more than max bytes from it fails with
+// ErrResponseTooLarge.
+func limitBody(body io.Reader, max int64) io.Reader {
+	return &limitedBody{r: body, left: max}
+}
+
+type limitedBody struct {
+	r    io.Reader
+	left int64
+}
+
+func (l *limitedBody) Read(p []byte) (int, error) {
+	if int64(len(p)) > l.left+1 {
+		p = p[:l.left+1]
+	}
+	n, err := l.r.Read(p)
+	if l.left -= int64(n); l.left < 0 {
+		return 0, errors.WithStack(ErrResponseTooLarge)
+	}
+	return n, err
+}
+
+// decodeBase64Field streams the Base64-encoded string value of field in the
+// JSON object read from r to dst. Other fields of the object are skipped.
+//
+// Unlike decoding into a struct with a []byte field, this does not hold the
+// encoded and decoded values in memory: only the decoded data is written to
+// dst as it is read.
+func decodeBase64Field(dst io.Writer, r io.Reader, field string) error {
+	decoder := json.NewDecoder(r)
+	if tok, err := decoder.Token(); err != nil {
+		return errors.Wrap(err, "decode response")
+	} else if tok != json.Delim('{') {
+		return errors.Errorf("decode response: unexpected %v", tok)
+	}
+
+	for decoder.More() {
+		tok, err := decoder.Token()
+		if err != nil {
+			return errors.Wrap(err, "decode response")
+		}
+		if key, _ := tok.(string); key != field {
+			var skip json.RawMessage
+			if err := decoder.Decode(&skip); err != nil {
+				return errors.Wrap(err, "decode response")
+			}
+			continue
+		}
+
+		// The decoder has consumed the key, but not the value: continue
+		// reading the value from the raw stream.
+		raw := bufio.NewReader(io.MultiReader(decoder.Buffered(), r))
+		if err := skipToString(raw); err != nil {
+			return errors.Wrapf(err, "decode %s", field)
+		}
+		decoded := base64.NewDecoder(base64.StdEncoding, &jsonString{r: raw})
+		_, err = io.Copy(dst, decoded)
+		return errors.Wrapf(err, "decode %s", field)
+	}
+	return errors.Errorf("decode response: missing %s", field)
+}
+
+// skipToString skips whitespace and the name separator up to and including
+// the opening quotation mark of a JSON string.
+func skipToString(r io.ByteReader) error {
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		switch b {
+		case ' ', '\t', '\r', '\n', ':':
+		case '"':
+			return nil
+		default:
+			return errors.Errorf("unexpected %q, expected string", b)
+		}
+	}
+}
+
+// jsonString reads the contents of a JSON string up to the closing quotation
+// mark. It only supports the escape sequences which can appear in Base64
+// encoded strings: "\/" and line breaks.
+type jsonString struct {
+	r    *bufio.Reader
+	done bool
+}
+
+func (s *jsonString) Read(p []byte) (int, error) {
+	var n int
+	for n < len(p) && !s.done {
+		b, err := s.r.ReadByte()
+		if err == io.EOF {
+			return n, io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			return n, err
+		}
+
+		switch b {
+		case '"':
+			s.done = true
+			continue
+		case '\\':
+			if b, err = s.r.ReadByte(); err != nil {
+				return n, errors.WithStack(err)
+			}
+			switch b {
+			case '/':
+			case 'n':
+				b = '\n'
+			case 'r':
+				b = '\r'
+			default:
+				return n, errors.Errorf("unsupported escape sequence: \\%c", b)
+			}
+		}
+		p[n] = b
+		n++
+	}
+	if s.done && n == 0 {
+		return 0, io.EOF
+	}
+	return n, nil
+}
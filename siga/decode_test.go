@@ -0,0 +1,69 @@
+package siga
+
+import (
+	"bytes"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+func TestDecodeBase64Field_OtherFields_Decoded(t *testing.T) {
+	// given
+	body := `{"containerId": "id", "nested": {"container": "bm90"},
+		"container" : "Pz8\/\nZGF0YQ==", "after": true}`
+	var out bytes.Buffer
+
+	// when
+	err := decodeBase64Field(&out, strings.NewReader(body), "container")
+
+	// then
+	if err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	if out.String() != "???data" {
+		t.Errorf("unexpected output: %q", out.String())
+	}
+}
+
+func TestDecodeBase64Field_Missing_Error(t *testing.T) {
+	body := `{"containerId": "id"}`
+	if err := decodeBase64Field(ioutil.Discard, strings.NewReader(body), "container"); err == nil {
+		t.Fatal("unexpected success")
+	}
+}
+
+func TestDecodeBase64Field_Unterminated_Error(t *testing.T) {
+	body := `{"container": "ZGF0YQ==`
+	if err := decodeBase64Field(ioutil.Discard, strings.NewReader(body), "container"); err == nil {
+		t.Fatal("unexpected success")
+	}
+}
+
+func TestDecodeBase64Field_NotString_Error(t *testing.T) {
+	body := `{"container": null}`
+	if err := decodeBase64Field(ioutil.Discard, strings.NewReader(body), "container"); err == nil {
+		t.Fatal("unexpected success")
+	}
+}
+
+func TestLimitBody_TooLarge_Error(t *testing.T) {
+	// given
+	body := limitBody(strings.NewReader(strings.Repeat("x", 101)), 100)
+
+	// when
+	_, err := ioutil.ReadAll(body)
+
+	// then
+	if !errors.Is(err, ErrResponseTooLarge) {
+		t.Fatalf("unexpected error:\n     got: %v\nexpected: %v", err, ErrResponseTooLarge)
+	}
+}
+
+func TestLimitBody_AtLimit_Succeeds(t *testing.T) {
+	body := limitBody(strings.NewReader(strings.Repeat("x", 100)), 100)
+	if _, err := ioutil.ReadAll(body); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+}
@@ -3,6 +3,7 @@ package siga
 import (
 	"archive/zip"
 	"bytes"
+	"encoding/binary"
 	"encoding/xml"
 	"io"
 	"io/ioutil"
@@ -63,13 +64,34 @@ type readAtSeeker interface {
 // toHashcode transforms a complete signature container read from src to a
 // hashcode form signature container and writes it to dst. size indicates the
 // size of src in bytes. toHashcode returns the datafiles read from src.
-func toHashcode(dst io.Writer, src io.ReaderAt, size int64) ([]*DataFile, error) {
+//
+// Only ASiC-E containers have a hashcode form: if src is an ASiC-S container,
+// then toHashcode returns ErrASiCSHashcode.
+//
+// src is untrusted: it is checked against limits and for structural problems
+// before reading any data files. See ZipError for the returned errors.
+func toHashcode(dst io.Writer, src io.ReaderAt, size int64, limits ZipLimits) ([]*DataFile, error) {
 	reader, err := zip.NewReader(src, size)
 	if err != nil {
 		return nil, errors.Wrap(err, "open zip")
 	}
+	if err := checkZip(reader, limits); err != nil {
+		return nil, err
+	}
+	switch t, err := containerType(reader); {
+	case err != nil:
+		return nil, err
+	case t == ASiCS:
+		return nil, ErrASiCSHashcode
+	}
 	writer := zip.NewWriter(dst)
 
+	// Remember the media types of data files listed in the manifest. A
+	// manifest which cannot be parsed does not reject the container, as it
+	// did not before media types were read: the manifest is sent to SiGa
+	// unchanged for validation and the media types are detected instead.
+	mediaTypes, _ := readManifest(reader)
+
 	// Copy files from src, collecting data files and dropping them from
 	// the output.
 	var datafiles []*DataFile
@@ -81,10 +103,13 @@ func toHashcode(dst io.Writer, src io.ReaderAt, size int64) ([]*DataFile, error)
 		}
 
 		if file.Name != "mimetype" && !strings.HasPrefix(file.Name, "META-INF/") {
-			df, err := zipDataFile(file)
+			df, err := zipDataFile(file, limits)
 			if err != nil {
 				return nil, err
 			}
+			if mediaType := mediaTypes[file.Name]; mediaType != "" {
+				df.mediaType = mediaType
+			}
 			datafiles = append(datafiles, df)
 			continue // Do not copy to output.
 		}
@@ -154,14 +179,11 @@ func fromHashcode(
 		return errors.New("missing SHA-512 hashcodes")
 	}
 
-	// Write the datafiles to the archive.
+	// Write the datafiles to the archive. Use the remembered metadata if
+	// available so that the output is the same each time.
 	for _, datafile := range datafiles {
-		if err := zipWrite(writer, &zip.FileHeader{
-			Name:               datafile.meta.Name,
-			Method:             zip.Deflate,
-			Modified:           time.Now(),
-			UncompressedSize64: uint64(datafile.meta.Size),
-		}, datafile.contents); err != nil {
+		meta := orZipMeta(datafile.zip, time.Now())
+		if err := zipWrite(writer, meta.header(datafile), datafile.contents); err != nil {
 			return err
 		}
 	}
@@ -169,13 +191,104 @@ func fromHashcode(
 	return errors.Wrap(writer.Close(), "close zip")
 }
 
-func zipDataFile(file *zip.File) (*DataFile, error) {
-	r, err := file.Open()
+func zipDataFile(file *zip.File, limits ZipLimits) (*DataFile, error) {
+	r, err := limitFile(file, limits)
 	if err != nil {
-		return nil, errors.Wrapf(err, "open %s", file.Name)
+		return nil, err
 	}
 	defer r.Close()
-	return NewDataFile(file.Name, r)
+	df, err := NewDataFile(file.Name, r)
+	if err != nil {
+		return nil, err
+	}
+	df.zip = readZipMeta(&file.FileHeader)
+	return df, nil
+}
+
+// zipMeta is the ZIP-archive metadata of a data file which is reproduced when
+// writing the data file into a complete container.
+type zipMeta struct {
+	Method         uint16
+	Modified       time.Time // Only used if ModifiedDate is zero.
+	ModifiedTime   uint16
+	ModifiedDate   uint16
+	Comment        string
+	CreatorVersion uint16
+	ExternalAttrs  uint32
+	Extra          []byte
+}
+
+// newZipMeta returns the metadata used for data files which were not read from
+// a container: DEFLATE compression and modification time at.
+func newZipMeta(at time.Time) *zipMeta {
+	return &zipMeta{
+		Method:   zip.Deflate,
+		Modified: at.UTC().Truncate(time.Second),
+	}
+}
+
+// orZipMeta returns meta if not nil and newZipMeta(at) otherwise.
+func orZipMeta(meta *zipMeta, at time.Time) *zipMeta {
+	if meta != nil {
+		return meta
+	}
+	return newZipMeta(at)
+}
+
+// readZipMeta remembers the metadata of a data file read from a container.
+//
+// The modification time is kept in its original MS-DOS form with the raw
+// extra fields, which contain any extended timestamps: this way archive/zip
+// does not add its own extended timestamp when writing. Zip64 extra fields are
+// dropped, since archive/zip adds those itself when necessary.
+func readZipMeta(header *zip.FileHeader) *zipMeta {
+	meta := &zipMeta{
+		Method:         header.Method,
+		ModifiedTime:   header.ModifiedTime,
+		ModifiedDate:   header.ModifiedDate,
+		Comment:        header.Comment,
+		CreatorVersion: header.CreatorVersion,
+		ExternalAttrs:  header.ExternalAttrs,
+	}
+	if meta.Method != zip.Store {
+		meta.Method = zip.Deflate // Only methods supported by archive/zip.
+	}
+	if meta.ModifiedDate == 0 {
+		meta.Modified = header.Modified
+	}
+
+	for extra := header.Extra; len(extra) >= 4; {
+		tag := binary.LittleEndian.Uint16(extra[:2])
+		size := 4 + int(binary.LittleEndian.Uint16(extra[2:4]))
+		if size > len(extra) {
+			break // Malformed, drop the rest.
+		}
+		if tag != zip64ExtraID {
+			meta.Extra = append(meta.Extra, extra[:size]...)
+		}
+		extra = extra[size:]
+	}
+	return meta
+}
+
+// zip64ExtraID is the header ID of the Zip64 extended information extra field.
+const zip64ExtraID = 0x0001
+
+// header returns a ZIP-archive file header for writing datafile with the
+// metadata m.
+func (m *zipMeta) header(datafile *DataFile) *zip.FileHeader {
+	return &zip.FileHeader{
+		Name:               datafile.meta.Name,
+		Comment:            m.Comment,
+		CreatorVersion:     m.CreatorVersion,
+		Method:             m.Method,
+		Modified:           m.Modified,
+		ModifiedTime:       m.ModifiedTime,
+		ModifiedDate:       m.ModifiedDate,
+		Extra:              append([]byte(nil), m.Extra...),
+		ExternalAttrs:      m.ExternalAttrs,
+		UncompressedSize64: uint64(datafile.meta.Size),
+	}
 }
 
 func zipCopy(writer *zip.Writer, file *zip.File, buf []byte, forceDeflate bool) error {
@@ -185,7 +298,11 @@ func zipCopy(writer *zip.Writer, file *zip.File, buf []byte, forceDeflate bool)
 	}
 	defer r.Close()
 
+	// Keep the original MS-DOS modification time and extra fields: otherwise
+	// archive/zip would append a new extended timestamp each time the file
+	// is copied.
 	header := file.FileHeader
+	header.Modified = time.Time{}
 	if forceDeflate {
 		header.Method = zip.Deflate
 	}
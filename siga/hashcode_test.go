@@ -1,6 +1,8 @@
 package siga
 
 import (
+	"archive/zip"
+	"bytes"
 	"errors"
 	"fmt"
 	"io/ioutil"
@@ -8,6 +10,7 @@ import (
 	"path/filepath"
 	"strings"
 	"testing"
+	"time"
 )
 
 // Set to true to save outputs in allkirjad// for validation with external tools.
@@ -91,3 +94,121 @@ func TestFromHashcode_UnknownDatafiles_Errors(t *testing.T) {
 func TestFromHashcode_MismatchingDatafiles_Errors(t *testing.T) {
 	runFromHashcodeTest(t, "mismatching", errors.New("mismatching mismatching_datafile.txt hash"))
 }
+
+func TestFromHashcode_UploadedDatafiles_MetadataPreserved(t *testing.T) {
+	// given
+	var container bytes.Buffer
+	w := zip.NewWriter(&container)
+	modified := time.Date(2020, 8, 1, 12, 30, 0, 0, time.UTC)
+	for _, header := range []*zip.FileHeader{
+		{Name: "mimetype", Method: zip.Store},
+		{Name: "stored.txt", Method: zip.Store, Modified: modified, Comment: "comment"},
+		{Name: "deflated.txt", Method: zip.Deflate, Modified: modified},
+	} {
+		fw, err := w.CreateHeader(header)
+		if err != nil {
+			t.Fatal(err)
+		}
+		contents := header.Name
+		if header.Name == "mimetype" {
+			contents = asiceMimetype
+		}
+		if _, err := fw.Write([]byte(contents)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	var hashcode bytes.Buffer
+	datafiles, err := toHashcode(&hashcode, bytes.NewReader(container.Bytes()), int64(container.Len()), ZipLimits{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// when
+	var first, second bytes.Buffer
+	src := bytes.NewReader(hashcode.Bytes())
+	if err := fromHashcode(&first, src, src.Size(), datafiles...); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	if err := fromHashcode(&second, src, src.Size(), datafiles...); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+
+	// then
+	if !bytes.Equal(first.Bytes(), second.Bytes()) {
+		t.Error("output not deterministic")
+	}
+	reader, err := zip.NewReader(bytes.NewReader(first.Bytes()), int64(first.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	methods := map[string]uint16{"stored.txt": zip.Store, "deflated.txt": zip.Deflate}
+	for _, file := range reader.File {
+		method, ok := methods[file.Name]
+		if !ok {
+			continue
+		}
+		delete(methods, file.Name)
+		if file.Method != method {
+			t.Errorf("unexpected %s method: got %d, expected %d", file.Name, file.Method, method)
+		}
+		if !file.Modified.Equal(modified) {
+			t.Errorf("unexpected %s modified: got %v, expected %v", file.Name, file.Modified, modified)
+		}
+	}
+	if len(methods) > 0 {
+		t.Error("missing datafiles:", methods)
+	}
+}
+
+func TestFromHashcode_CreatedDatafiles_Deterministic(t *testing.T) {
+	// given
+	var hashcode bytes.Buffer
+	w := zip.NewWriter(&hashcode)
+	datafile := bytesDataFile("created.txt", []byte("created"))
+	created := time.Date(2020, 5, 1, 12, 0, 0, 0, time.UTC)
+	datafile.zip = newZipMeta(created)
+	if err := writeHashcodes(w, hashcodesSHA256, []*DataFile{datafile}, false); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeHashcodes(w, hashcodesSHA512, []*DataFile{datafile}, true); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	src := bytes.NewReader(hashcode.Bytes())
+
+	// when
+	var first, second bytes.Buffer
+	if err := fromHashcode(&first, src, src.Size(), datafile); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	if err := fromHashcode(&second, src, src.Size(), datafile); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+
+	// then
+	if !bytes.Equal(first.Bytes(), second.Bytes()) {
+		t.Error("output not deterministic")
+	}
+	r, err := zip.NewReader(bytes.NewReader(first.Bytes()), int64(first.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var found bool
+	for _, file := range r.File {
+		if file.Name == "created.txt" {
+			found = true
+			if !file.Modified.Equal(created) {
+				t.Errorf("unexpected modified: got %v, expected %v", file.Modified, created)
+			}
+		}
+	}
+	if !found {
+		t.Error("missing created.txt")
+	}
+}
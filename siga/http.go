@@ -12,17 +12,26 @@ import (
 	"hash"
 	"io"
 	"net/http"
+	"net/url"
+	"sort"
 	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/e-gov/SiGa-Go/https"
 	"github.com/e-gov/SiGa-Go/https/httpsutil"
+	"github.com/e-gov/SiGa-Go/tracing"
 	"github.com/pkg/errors"
 )
 
 // httpClient on SiGa poole pöörduv HTTPS klient.
 type httpClient struct {
+	// skew on SiGa serveri ja kohaliku kella vahe nanosekundites, mida
+	// kasutatakse autoriseerimise ajatemplis. Esimene väli, et tagada
+	// atomaarsete operatsioonide jaoks joondus ka 32-bitistel platvormidel.
+	skew int64
+
 	client *http.Client
 
 	url        string
@@ -30,109 +39,334 @@ type httpClient struct {
 	key        []byte
 	algo       string
 	hmac       func() hash.Hash
+	rotation   []serviceKey // ValidFrom järgi kasvavalt sorditud.
 	now        func() time.Time
+	maxBody    int64
+	log        Logger
+	metrics    *Metrics
+	tracer     *tracing.Tracer
+	breaker    *breaker
+	limiter    *limiter
 }
 
 // newHTTPClient moodustab conf põhjal SiGa kliendi.
 func newHTTPClient(conf Conf) (*httpClient, error) {
 	c := &httpClient{
 		client: &http.Client{
-			Transport: httpsutil.Transport(conf.RootCAs, &conf.ClientTLS),
+			Transport: httpsutil.Transport(conf.RootCAs, &conf.ClientTLS, conf.TransportOptions()),
 			Timeout:   conf.Timeout.Or(https.DefaultClientTimeout),
 		},
 		url:        conf.URL.Raw,
 		identifier: conf.ServiceIdentifier,
 		key:        []byte(conf.ServiceKey),
 		now:        time.Now,
+		maxBody:    conf.MaxResponseSize,
+		metrics:    conf.Metrics,
+		tracer:     conf.Tracer,
+	}
+	if c.maxBody <= 0 {
+		c.maxBody = DefaultMaxResponseSize
+	}
+	c.log = conf.Logger
+	if c.log == nil {
+		c.log = NewStdLogger(nil, LevelInfo)
+	}
+	c.log = redactingLogger{c.log}
+
+	c.limiter = newLimiter(conf.RateLimit)
+	c.breaker = newBreaker(conf.CircuitBreaker)
+	if c.breaker != nil {
+		onChange := conf.CircuitBreaker.OnStateChange
+		c.breaker.onChange = func(from, to BreakerState) {
+			level := LevelInfo
+			if to == BreakerOpen {
+				level = LevelError
+			}
+			c.log.Log(context.Background(), level, "siga_circuit_state", Fields{
+				"from": from,
+				"to":   to,
+			})
+			if onChange != nil {
+				onChange(from, to)
+			}
+		}
+	}
+
+	var err error
+	if c.algo, c.hmac, err = hmacAlgorithm(conf.HMACAlgorithm); err != nil {
+		return nil, err
+	}
+
+	for i, key := range conf.ServiceKeys {
+		rotated := serviceKey{
+			validFrom:  key.ValidFrom,
+			identifier: key.ServiceIdentifier,
+			key:        []byte(key.ServiceKey),
+		}
+		if rotated.identifier == "" {
+			rotated.identifier = conf.ServiceIdentifier
+		}
+		algorithm := key.HMACAlgorithm
+		if algorithm == "" {
+			algorithm = conf.HMACAlgorithm
+		}
+		if rotated.algo, rotated.hmac, err = hmacAlgorithm(algorithm); err != nil {
+			return nil, errors.WithMessagef(err, "ServiceKeys[%d]", i)
+		}
+		c.rotation = append(c.rotation, rotated)
 	}
+	sort.SliceStable(c.rotation, func(i, j int) bool {
+		return c.rotation[i].validFrom.Before(c.rotation[j].validFrom)
+	})
+	return c, nil
+}
 
-	switch conf.HMACAlgorithm {
+// hmacAlgorithm tagastab seadistuses antud HMAC algoritmile vastava SiGa
+// algoritmi nime ja räsifunktsiooni.
+func hmacAlgorithm(name string) (string, func() hash.Hash, error) {
+	switch name {
 	case "", "HMAC-SHA256":
-		c.algo = "HmacSHA256"
-		c.hmac = sha256.New
+		return "HmacSHA256", sha256.New, nil
 	case "HMAC-SHA384":
-		c.algo = "HmacSHA384"
-		c.hmac = sha512.New384
+		return "HmacSHA384", sha512.New384, nil
 	case "HMAC-SHA512":
-		c.algo = "HmacSHA512"
-		c.hmac = sha512.New
+		return "HmacSHA512", sha512.New, nil
 	default:
-		return nil, errors.Errorf("unknown HMACAlgorithm: %s", conf.HMACAlgorithm)
+		return "", nil, errors.Errorf("unknown HMACAlgorithm: %s", name)
 	}
-	return c, nil
 }
 
-// authHeaders seab SiGa kliendile autoriseerimispäised.
+// serviceKey on võtmevahetuse ajakavas olev teenuse võti.
+type serviceKey struct {
+	validFrom  time.Time
+	identifier string
+	key        []byte
+	algo       string
+	hmac       func() hash.Hash
+}
+
+// serviceKey tagastab ajahetkel now kehtiva teenuse võtme: viimase
+// ajakavas olevatest võtmetest, mis on hetkeks now kehtima hakanud, või
+// põhivõtme, kui ükski neist pole veel kehtiv.
+func (c *httpClient) serviceKey(now time.Time) serviceKey {
+	current := serviceKey{identifier: c.identifier, key: c.key, algo: c.algo, hmac: c.hmac}
+	for _, key := range c.rotation {
+		if key.validFrom.After(now) {
+			break
+		}
+		current = key
+	}
+	return current
+}
+
+// authHeaders seab SiGa kliendile autoriseerimispäised. Ajatemplina
+// kasutatakse kohalikku aega, mida on korrigeeritud SiGa serveri kellaga.
 func (c *httpClient) authHeaders(headers http.Header, method, uri string, body []byte) {
-	now := strconv.FormatInt(c.now().Unix(), 10)
-	hmac := hmac.New(c.hmac, c.key)
+	at := c.now().Add(time.Duration(atomic.LoadInt64(&c.skew)))
+	key := c.serviceKey(at)
+	now := strconv.FormatInt(at.Unix(), 10)
+	hmac := hmac.New(key.hmac, key.key)
 
-	fmt.Fprintf(hmac, "%s:%s:%s:%s:", c.identifier, now, method, uri)
+	fmt.Fprintf(hmac, "%s:%s:%s:%s:", key.identifier, now, method, uri)
 	hmac.Write(body)
 
 	headers.Set("X-Authorization-Timestamp", now)
-	headers.Set("X-Authorization-ServiceUUID", c.identifier)
-	headers.Set("X-Authorization-Hmac-Algorithm", c.algo)
+	headers.Set("X-Authorization-ServiceUUID", key.identifier)
+	headers.Set("X-Authorization-Hmac-Algorithm", key.algo)
 	headers.Set("X-Authorization-Signature", hex.EncodeToString(hmac.Sum(nil)))
 }
 
-// do täidab SiGa kliendina päringu.
+// clockSkewTolerance on suurim SiGa serveri ja kohaliku kella vahe, mida ei
+// loeta autoriseerimise tagasilükkamise põhjuseks. HTTP Date päise
+// täpsus on üks sekund.
+const clockSkewTolerance = 2 * time.Second
+
+// adjustClock kontrollib, kas SiGa lükkas päringu autoriseerimise tagasi
+// kellade erinevuse tõttu. Kui jah, siis salvestab kellade vahe edasiste
+// päringute ajatemplite korrigeerimiseks ja tagastab true.
+func (c *httpClient) adjustClock(resp *http.Response) (time.Duration, bool) {
+	if resp.StatusCode != http.StatusUnauthorized {
+		return 0, false
+	}
+	date, err := http.ParseTime(resp.Header.Get("Date"))
+	if err != nil {
+		return 0, false
+	}
+	local := c.now().Add(time.Duration(atomic.LoadInt64(&c.skew)))
+	diff := date.Sub(local)
+	if diff > -clockSkewTolerance && diff < clockSkewTolerance {
+		return 0, false
+	}
+	skew := date.Sub(c.now())
+	atomic.StoreInt64(&c.skew, int64(skew))
+	return skew, true
+}
+
+// do täidab SiGa kliendina päringu ja dekodeerib JSON vastuse resp-i.
 func (c *httpClient) do(ctx context.Context, method, uri string, req interface{}, resp interface{}) error {
+	return c.doFunc(ctx, method, uri, req, func(body io.Reader) error {
+		if resp == nil {
+			return nil
+		}
+		return errors.Wrap(json.NewDecoder(body).Decode(resp), "decode response")
+	})
+}
+
+// doFunc täidab SiGa kliendina päringu ja annab eduka vastuse keha decode-le.
+// Vastuse keha pikkus on piiratud: decode saab ErrResponseTooLarge vea, kui
+// keha on pikem kui MaxResponseSize.
+func (c *httpClient) doFunc(
+	ctx context.Context,
+	method, uri string,
+	req interface{},
+	decode func(body io.Reader) error) (err error) {
+
+	ctx, span := c.tracer.StartSpan(ctx, method+" "+endpointFromURI(uri))
+	span.SetAttribute("http.method", method)
+	span.SetAttribute("http.url", uri)
+	if id := containerIDFromURI(uri); id != "" {
+		span.SetAttribute("siga.container_id", id)
+	}
+	defer func() { finishSpan(span, err) }()
+
 	// If a request body is given, then marshal it into memory since we
 	// need to calculate the MAC over it before sending it to the server.
 	var body []byte
-	var bodyReader io.Reader
 	if req != nil {
-		var err error
 		if body, err = json.Marshal(req); err != nil {
 			return errors.Wrap(err, "encode request")
 		}
-		bodyReader = bytes.NewReader(body)
 	}
 
+	fields := Fields{"method": method, "uri": uri}
+	if id := containerIDFromURI(uri); id != "" {
+		fields["containerId"] = id
+	}
+
+	// Perform the request. If the SiGa service rejects the authorization
+	// timestamp because of clock skew, then retry once with corrected time.
+	var httpResp *http.Response
+	var latency time.Duration
+	for retried := false; ; retried = true {
+		httpResp, latency, err = c.send(ctx, method, uri, body)
+		fields["latency"] = latency
+		if err != nil {
+			fields["error"] = err
+			c.log.Log(ctx, LevelError, "siga_request_error", fields)
+			c.metrics.observeRequest(method, uri, 0, "", latency)
+			return err
+		}
+		if retried {
+			break
+		}
+		skew, ok := c.adjustClock(httpResp)
+		if !ok {
+			break
+		}
+		httpResp.Body.Close()
+		c.log.Log(ctx, LevelInfo, "siga_clock_skew", Fields{
+			"method": method,
+			"uri":    uri,
+			"skew":   skew,
+		})
+		c.metrics.observeRequest(method, uri, httpResp.StatusCode, "", latency)
+	}
+	defer httpResp.Body.Close()
+	fields["status"] = httpResp.StatusCode
+	span.SetAttribute("http.status_code", httpResp.StatusCode)
+
+	// Decode the response body with decode or into an error struct if
+	// the HTTP status code indicates failure.
+	respBody := limitBody(httpResp.Body, c.maxBody)
+	if httpResp.StatusCode/100 != 2 { // XXX: Exact codes?
+		errResp := errorResponse{statusCode: httpResp.StatusCode}
+		if httpResp.Body != http.NoBody {
+			if err := json.NewDecoder(respBody).Decode(&errResp); err != nil {
+				errResp.decodeErr = err
+			}
+		}
+		fields["errorCode"] = errResp.ErrorCode
+		span.SetAttribute("siga.error_code", errResp.ErrorCode)
+		fields["error"] = errResp
+		c.log.Log(ctx, LevelError, "siga_response_error", fields)
+		c.metrics.observeRequest(method, uri, httpResp.StatusCode, errResp.ErrorCode, latency)
+		return errors.WithStack(errResp)
+	}
+	c.log.Log(ctx, LevelDebug, "siga_response", fields)
+	c.metrics.observeRequest(method, uri, httpResp.StatusCode, "", latency)
+	return decode(respBody)
+}
+
+// send moodustab autoriseerimispäistega päringu ja saadab selle SiGa-le.
+// Päringu keha body võib olla nil. Päring tehakse vaid siis, kui
+// kaitselüliti seda lubab, ja mitte sagedamini kui kiiruspiirang lubab.
+func (c *httpClient) send(ctx context.Context, method, uri string, body []byte) (
+	*http.Response, time.Duration, error) {
+
 	// Create a HTTP request and set the required headers.
+	var bodyReader io.Reader
+	if body != nil {
+		bodyReader = bytes.NewReader(body)
+	}
 	httpReq, err := http.NewRequest(method, singleJoiningSlash(c.url, uri), bodyReader)
 	if err != nil {
-		return errors.WithStack(err)
+		return nil, 0, errors.WithStack(err)
 	}
 	httpReq = httpReq.WithContext(ctx)
-	if req != nil {
+	if body != nil {
 		httpReq.Header.Set("Content-Type", "application/json; charset=UTF-8")
 	}
+
+	// Check the circuit breaker and wait for the rate limiter before
+	// authorizing the request so that the authorization timestamp is current.
+	admitted, err := c.breaker.allow()
+	if err != nil {
+		return nil, 0, err
+	}
+	if err := c.limiter.wait(ctx); err != nil {
+		c.breaker.done(admitted, outcomeIgnored)
+		return nil, 0, err
+	}
 	c.authHeaders(httpReq.Header, method, uri, body)
+	tracing.Inject(ctx, httpReq.Header)
 
-	// Perform the request.
-	// log.Debug().
-	// 	WithString("method", method).
-	// 	WithString("url", httpReq.URL).
-	// 	WithString("contentLength", httpReq.ContentLength).
-	// 	Log(ctx, "request")
+	c.log.Log(ctx, LevelDebug, "siga_request", Fields{
+		"method":        method,
+		"uri":           uri,
+		"contentLength": len(body),
+	})
+	start := time.Now()
 	httpResp, err := c.client.Do(httpReq)
+	latency := time.Since(start)
+	switch {
+	case err != nil && ctx.Err() != nil:
+		c.breaker.done(admitted, outcomeIgnored)
+	case err != nil, httpResp.StatusCode >= 500, httpResp.StatusCode == http.StatusTooManyRequests:
+		c.breaker.done(admitted, outcomeFailure)
+	default:
+		c.breaker.done(admitted, outcomeSuccess)
+	}
 	if err != nil {
-		return errors.Wrap(err, "perform request")
+		return nil, latency, errors.Wrap(err, "perform request")
 	}
-	defer httpResp.Body.Close()
-	// log.Debug().WithString("status", httpResp.StatusCode).Log(ctx, "response")
-
-	// Decode the response body into resp or an error struct if the HTTP
-	// status code indicates failure.
-	//
-	// TODO: Apply an upper limit to the number of bytes read. For now
-	// trust the service provider to not return excessively large bodies.
-	decoder := json.NewDecoder(httpResp.Body)
-	if httpResp.StatusCode/100 != 2 { // XXX: Exact codes?
-		errResp := errorResponse{statusCode: httpResp.StatusCode}
-		if httpResp.Body != http.NoBody {
-			if err := decoder.Decode(&errResp); err != nil {
-				errResp.decodeErr = err
-			}
-		}
-		return errors.WithStack(errResp)
+	return httpResp, latency, nil
+}
+
+// containerIDFromURI returns the container identifier from a SiGa container
+// URI or an empty string if uri does not refer to a container.
+func containerIDFromURI(uri string) string {
+	const prefix = "/hashcodecontainers/"
+	if !strings.HasPrefix(uri, prefix) {
+		return ""
+	}
+	id := strings.TrimPrefix(uri, prefix)
+	if i := strings.IndexByte(id, '/'); i >= 0 {
+		id = id[:i]
 	}
-	if resp != nil {
-		return errors.Wrap(decoder.Decode(resp), "decode response")
+	if unescaped, err := url.PathUnescape(id); err == nil {
+		id = unescaped
 	}
-	return nil
+	return id
 }
 
 // singleJoiningSlash joins the given paths with single slash in between.
@@ -1,8 +1,12 @@
 package siga
 
 import (
+	"context"
 	"crypto/sha256"
+	"encoding/json"
 	"net/http"
+	"net/http/httptest"
+	"strconv"
 	"testing"
 	"time"
 )
@@ -40,3 +44,151 @@ func TestHTTPClientAuthHeaders_WikiExample_Matches(t *testing.T) {
 	assert("X-Authorization-Hmac-Algorithm", "HmacSHA256")
 	assert("X-Authorization-Signature", "7301b3b88995b410bed0016b9a5bb3d177d32ac2bb2e91fabb80c084180eb42d")
 }
+
+func TestHTTPClientAuthHeaders_RotatedKey_Used(t *testing.T) {
+	// given
+	switchover := time.Unix(1580400000, 0)
+	c, err := newHTTPClient(Conf{
+		ServiceIdentifier: "old-identifier",
+		ServiceKey:        "old-key",
+		ServiceKeys: []ServiceKey{
+			{ValidFrom: switchover.Add(time.Hour), ServiceKey: "future-key"},
+			{ValidFrom: switchover, ServiceIdentifier: "new-identifier",
+				ServiceKey: "new-key", HMACAlgorithm: "HMAC-SHA512"},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		at         time.Time
+		identifier string
+		key        string
+		algo       string
+	}{
+		{switchover.Add(-time.Second), "old-identifier", "old-key", "HmacSHA256"},
+		{switchover, "new-identifier", "new-key", "HmacSHA512"},
+		{switchover.Add(2 * time.Hour), "old-identifier", "future-key", "HmacSHA256"},
+	} {
+		// when
+		key := c.serviceKey(test.at)
+
+		// then
+		if key.identifier != test.identifier || string(key.key) != test.key || key.algo != test.algo {
+			t.Errorf("unexpected key at %v: %s, %s, %s", test.at, key.identifier, key.key, key.algo)
+		}
+	}
+}
+
+func TestNewHTTPClient_UnknownRotatedAlgorithm_Error(t *testing.T) {
+	_, err := newHTTPClient(Conf{ServiceKeys: []ServiceKey{{HMACAlgorithm: "HMAC-MD5"}}})
+	if err == nil {
+		t.Error("expected error")
+	}
+}
+
+func TestNewHTTPClient_MaxResponseSize_FromClientConf(t *testing.T) {
+	// given
+	var conf Conf
+	if err := json.Unmarshal([]byte(`{"MaxResponseSize": 1024}`), &conf); err != nil {
+		t.Fatal(err)
+	}
+
+	// when
+	configured, err := newHTTPClient(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defaulted, err := newHTTPClient(Conf{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// then
+	if configured.maxBody != 1024 || defaulted.maxBody != DefaultMaxResponseSize {
+		t.Errorf("unexpected limits: %d, %d", configured.maxBody, defaulted.maxBody)
+	}
+}
+
+// timestampServer returns a test server which rejects requests with
+// authorization timestamps differing from its clock by more than a second.
+func timestampServer(t *testing.T, requests *int) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		*requests++
+		ts, err := strconv.ParseInt(r.Header.Get("X-Authorization-Timestamp"), 10, 64)
+		if err != nil {
+			t.Error(err)
+		}
+		if diff := time.Since(time.Unix(ts, 0)); diff > 2*time.Second || diff < -2*time.Second {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(`{"errorCode":"AUTHORIZATION_ERROR"}`))
+			return
+		}
+		w.Write([]byte(`{}`))
+	}))
+}
+
+func testHTTPClient(srv *httptest.Server, now func() time.Time) *httpClient {
+	return &httpClient{
+		client:  srv.Client(),
+		url:     srv.URL,
+		algo:    "HmacSHA256",
+		hmac:    sha256.New,
+		now:     now,
+		maxBody: DefaultMaxResponseSize,
+		log:     NopLogger,
+	}
+}
+
+func TestHTTPClientDo_ClockSkew_RetriedWithServerTime(t *testing.T) {
+	// given
+	var requests int
+	srv := timestampServer(t, &requests)
+	defer srv.Close()
+	c := testHTTPClient(srv, func() time.Time { return time.Now().Add(-time.Hour) })
+
+	// when
+	err := c.do(context.Background(), http.MethodGet, "/test", nil, nil)
+
+	// then
+	if err != nil {
+		t.Fatal(err)
+	}
+	if requests != 2 {
+		t.Errorf("unexpected number of requests: %d", requests)
+	}
+
+	// and when
+	err = c.do(context.Background(), http.MethodGet, "/test", nil, nil)
+
+	// then
+	if err != nil {
+		t.Fatal(err)
+	}
+	if requests != 3 {
+		t.Errorf("skew not remembered: %d requests", requests)
+	}
+}
+
+func TestHTTPClientDo_UnauthorizedWithoutSkew_NotRetried(t *testing.T) {
+	// given
+	var requests int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer srv.Close()
+	c := testHTTPClient(srv, time.Now)
+
+	// when
+	err := c.do(context.Background(), http.MethodGet, "/test", nil, nil)
+
+	// then
+	if err == nil {
+		t.Fatal("expected error")
+	}
+	if requests != 1 {
+		t.Errorf("unexpected number of requests: %d", requests)
+	}
+}
@@ -0,0 +1,156 @@
+package siga
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Level is the severity of a log event.
+type Level int
+
+// Log levels in increasing order of severity.
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelError
+)
+
+// String returns the name of the log level.
+func (l Level) String() string {
+	switch l {
+	case LevelDebug:
+		return "DEBUG"
+	case LevelInfo:
+		return "INFO"
+	case LevelError:
+		return "ERROR"
+	default:
+		return fmt.Sprintf("LEVEL(%d)", int(l))
+	}
+}
+
+// Fields are the structured fields of a log event. The SiGa client uses the
+// following keys:
+//
+//   - "method" and "uri" for the SiGa request method and URI,
+//   - "containerId" for the SiGa container identifier,
+//   - "status" and "errorCode" for the SiGa response status and error code,
+//   - "latency" for the time.Duration of the SiGa request, and
+//   - "error" for the error which occurred.
+type Fields map[string]interface{}
+
+// Logger is the interface used by the SiGa client for logging events. The
+// client redacts personal codes, phone numbers, and document contents from
+// fields before passing them to Logger.
+type Logger interface {
+	Log(ctx context.Context, level Level, event string, fields Fields)
+}
+
+// NewStdLogger returns a Logger which writes events with at least level min
+// to l in the form "LEVEL event key=value ...". If l is nil, then the
+// standard logger of package log is used.
+func NewStdLogger(l *log.Logger, min Level) Logger {
+	if l == nil {
+		l = log.New(log.Writer(), log.Prefix(), log.Flags())
+	}
+	return stdLogger{logger: l, min: min}
+}
+
+type stdLogger struct {
+	logger *log.Logger
+	min    Level
+}
+
+func (s stdLogger) Log(ctx context.Context, level Level, event string, fields Fields) {
+	if level < s.min {
+		return
+	}
+
+	keys := make([]string, 0, len(fields))
+	for key := range fields {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var buf strings.Builder
+	fmt.Fprintf(&buf, "%s %s", level, event)
+	for _, key := range keys {
+		fmt.Fprintf(&buf, " %s=%q", key, fmt.Sprint(fields[key]))
+	}
+	s.logger.Print(buf.String())
+}
+
+// NopLogger is a Logger which discards all events.
+var NopLogger Logger = nopLogger{}
+
+type nopLogger struct{}
+
+func (nopLogger) Log(context.Context, Level, string, Fields) {}
+
+// redactingLogger wraps a Logger and redacts fields before passing them on.
+type redactingLogger struct {
+	Logger
+}
+
+func (r redactingLogger) Log(ctx context.Context, level Level, event string, fields Fields) {
+	redacted := make(Fields, len(fields))
+	for key, value := range fields {
+		switch {
+		case sensitiveKeys[key]:
+			redacted[key] = redactedValue
+		case value == nil:
+			redacted[key] = nil
+		default:
+			switch v := value.(type) {
+			case time.Duration:
+				redacted[key] = v
+			case string:
+				redacted[key] = Redact(v)
+			case error:
+				redacted[key] = Redact(v.Error())
+			case fmt.Stringer:
+				redacted[key] = Redact(v.String())
+			default:
+				redacted[key] = value
+			}
+		}
+	}
+	r.Logger.Log(ctx, level, event, redacted)
+}
+
+const redactedValue = "[REDACTED]"
+
+// sensitiveKeys are field keys whose values are always redacted, because they
+// contain personal data or document contents.
+var sensitiveKeys = map[string]bool{
+	"person":      true,
+	"phone":       true,
+	"message":     true,
+	"contents":    true,
+	"data":        true,
+	"dataToSign":  true,
+	"signature":   true,
+	"certificate": true,
+	"body":        true,
+}
+
+var (
+	// personalCodeRegexp matches Estonian personal identification codes.
+	personalCodeRegexp = regexp.MustCompile(`\b[1-6]\d{2}(?:0[1-9]|1[0-2])(?:0[1-9]|[12]\d|3[01])\d{4}\b`)
+
+	// phoneRegexp matches international phone numbers and Estonian phone
+	// numbers with or without the country code.
+	phoneRegexp = regexp.MustCompile(`\+\d{7,15}\b|\b372\d{7,8}\b|\b5\d{6,7}\b`)
+)
+
+// Redact replaces personal identification codes and phone numbers in s with
+// a placeholder.
+func Redact(s string) string {
+	s = personalCodeRegexp.ReplaceAllString(s, redactedValue)
+	return phoneRegexp.ReplaceAllString(s, redactedValue)
+}
@@ -0,0 +1,87 @@
+package siga
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// recordLogger is a Logger which records the last logged fields.
+type recordLogger struct {
+	fields Fields
+}
+
+func (r *recordLogger) Log(ctx context.Context, level Level, event string, fields Fields) {
+	r.fields = fields
+}
+
+func TestRedact_PersonalCodeAndPhone_Redacted(t *testing.T) {
+	got := Redact("person 60001019906, phone +37200000766 and 5123456, container 1234")
+	expected := "person [REDACTED], phone [REDACTED] and [REDACTED], container 1234"
+	if got != expected {
+		t.Errorf("unexpected redaction:\n     got: %s\nexpected: %s", got, expected)
+	}
+}
+
+func TestRedactingLogger_Fields_Redacted(t *testing.T) {
+	// given
+	record := new(recordLogger)
+	logger := redactingLogger{record}
+
+	// when
+	logger.Log(context.Background(), LevelInfo, "event", Fields{
+		"contents":    "secret document",
+		"error":       errors.New("invalid person 60001019906"),
+		"containerId": "a7fd7728",
+		"status":      400,
+		"latency":     time.Second,
+	})
+
+	// then
+	expected := Fields{
+		"contents":    redactedValue,
+		"error":       "invalid person " + redactedValue,
+		"containerId": "a7fd7728",
+		"status":      400,
+		"latency":     time.Second,
+	}
+	for key, value := range expected {
+		if record.fields[key] != value {
+			t.Errorf("unexpected %s: got %v, expected %v", key, record.fields[key], value)
+		}
+	}
+}
+
+func TestStdLogger_BelowMinimum_Discarded(t *testing.T) {
+	// given
+	var buf bytes.Buffer
+	logger := NewStdLogger(log.New(&buf, "", 0), LevelInfo)
+
+	// when
+	logger.Log(context.Background(), LevelDebug, "debug", nil)
+	logger.Log(context.Background(), LevelInfo, "info", Fields{"b": 2, "a": "1"})
+
+	// then
+	if got := strings.TrimSpace(buf.String()); got != `INFO info a="1" b="2"` {
+		t.Errorf("unexpected output: %s", got)
+	}
+}
+
+func TestContainerIDFromURI_ContainerURIs_Extracted(t *testing.T) {
+	for uri, expected := range map[string]string{
+		"/hashcodecontainers":                           "",
+		"/upload/hashcodecontainers":                    "",
+		"/hashcodecontainers/abc":                       "abc",
+		"/hashcodecontainers/abc/remotesigning/def":     "abc",
+		"/hashcodecontainers/a%2Fb/mobileidsigning/xyz": "a/b",
+	} {
+		if got := containerIDFromURI(uri); got != expected {
+			t.Errorf("unexpected %s container ID: got %q, expected %q", uri, got, expected)
+		}
+	}
+}
@@ -0,0 +1,50 @@
+package siga
+
+import (
+	"archive/zip"
+	"encoding/xml"
+
+	"github.com/pkg/errors"
+)
+
+const manifestFile = "META-INF/manifest.xml"
+
+// manifest is the OpenDocument manifest of an ASiC-E container. Only the
+// fields we are interested in are unmarshaled.
+type manifest struct {
+	FileEntries []manifestEntry `xml:"file-entry"`
+}
+
+type manifestEntry struct {
+	FullPath  string `xml:"full-path,attr"`
+	MediaType string `xml:"media-type,attr"`
+}
+
+// readManifest reads the media types of files listed in the manifest of the
+// container opened as reader. If the container has no manifest, then an empty
+// map is returned. The manifest is only read: media types of signed
+// containers are never changed, since they must match the signatures.
+func readManifest(reader *zip.Reader) (map[string]string, error) {
+	types := make(map[string]string)
+	for _, file := range reader.File {
+		if file.Name != manifestFile {
+			continue
+		}
+
+		r, err := file.Open()
+		if err != nil {
+			return nil, errors.Wrapf(err, "open %s", file.Name)
+		}
+		defer r.Close()
+
+		var parsed manifest
+		if err := xml.NewDecoder(r).Decode(&parsed); err != nil {
+			return nil, errors.Wrapf(err, "parse %s", file.Name)
+		}
+		for _, entry := range parsed.FileEntries {
+			types[entry.FullPath] = entry.MediaType
+		}
+		break
+	}
+	return types, nil
+}
@@ -0,0 +1,142 @@
+package siga
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+const testManifest = `<?xml version="1.0" encoding="UTF-8" standalone="no" ?>
+<manifest:manifest xmlns:manifest="urn:oasis:names:tc:opendocument:xmlns:manifest:1.0" manifest:version="1.2">
+	<manifest:file-entry manifest:full-path="/" manifest:media-type="application/vnd.etsi.asic-e+zip"/>
+	<manifest:file-entry manifest:full-path="document.pdf" manifest:media-type="application/octet-stream"/>
+	<manifest:file-entry manifest:full-path="a&amp;b.txt" manifest:media-type='application/octet-stream'/>
+</manifest:manifest>`
+
+func TestReadManifest_Prefixed_MediaTypes(t *testing.T) {
+	// given
+	container := testContainer(t,
+		testZipFile{"mimetype", asiceMimetype},
+		testZipFile{manifestFile, testManifest},
+	)
+	reader := testZipReader(t, container)
+
+	// when
+	types, err := readManifest(reader)
+
+	// then
+	if err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	if got := types["a&b.txt"]; got != "application/octet-stream" {
+		t.Errorf("unexpected media type: %s", got)
+	}
+	if got := types["/"]; got != asiceMimetype {
+		t.Errorf("unexpected media type: %s", got)
+	}
+}
+
+func TestToHashcode_Manifest_MediaTypesKept(t *testing.T) {
+	// given
+	container := testContainer(t,
+		testZipFile{"mimetype", asiceMimetype},
+		testZipFile{"document.pdf", "%PDF-1.4"},
+		testZipFile{manifestFile, testManifest},
+	)
+
+	// when
+	datafiles, err := toHashcode(ioutil.Discard, bytes.NewReader(container), int64(len(container)), ZipLimits{})
+
+	// then
+	if err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	if len(datafiles) != 1 || datafiles[0].MediaType() != "application/octet-stream" {
+		t.Errorf("unexpected data files: %v", datafiles)
+	}
+}
+
+func TestToHashcode_InvalidManifest_MediaTypesDetected(t *testing.T) {
+	// given
+	container := testContainer(t,
+		testZipFile{"mimetype", asiceMimetype},
+		testZipFile{"document.pdf", "%PDF-1.4"},
+		testZipFile{manifestFile, "<manifest:manifest"},
+	)
+
+	// when
+	datafiles, err := toHashcode(ioutil.Discard, bytes.NewReader(container), int64(len(container)), ZipLimits{})
+
+	// then
+	if err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	if len(datafiles) != 1 || datafiles[0].MediaType() != "application/pdf" {
+		t.Errorf("unexpected data files: %v", datafiles)
+	}
+}
+
+func TestFromHashcode_Manifest_CopiedUnchanged(t *testing.T) {
+	// given
+	datafile := bytesDataFile("document.pdf", []byte("%PDF-1.4"))
+	var hashcode bytes.Buffer
+	w := zip.NewWriter(&hashcode)
+	if err := writeHashcodes(w, hashcodesSHA256, []*DataFile{datafile}, false); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeHashcodes(w, hashcodesSHA512, []*DataFile{datafile}, true); err != nil {
+		t.Fatal(err)
+	}
+	fw, err := w.Create(manifestFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fw.Write([]byte(testManifest))
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	src := bytes.NewReader(hashcode.Bytes())
+
+	// when
+	var out bytes.Buffer
+	err = fromHashcode(&out, src, src.Size(), datafile)
+
+	// then
+	if err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	for _, file := range testZipReader(t, out.Bytes()).File {
+		if file.Name != manifestFile {
+			continue
+		}
+		r, err := file.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer r.Close()
+		if contents, _ := ioutil.ReadAll(r); string(contents) != testManifest {
+			t.Errorf("manifest changed:\n%s", contents)
+		}
+		return
+	}
+	t.Error("missing manifest")
+}
+
+func TestDataFileMeta_MediaType_NotSentToSiGa(t *testing.T) {
+	// given
+	datafile := bytesDataFile("document.pdf", []byte("%PDF-1.4"))
+
+	// when
+	data, err := json.Marshal(datafile.meta)
+
+	// then
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "mimeType") || strings.Contains(string(data), "application/pdf") {
+		t.Errorf("media type sent to SiGa: %s", data)
+	}
+}
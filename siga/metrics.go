@@ -0,0 +1,333 @@
+package siga
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// metricsBuckets are the upper bounds of the latency histogram buckets in
+// seconds. The last bucket matches https.DefaultClientTimeout.
+var metricsBuckets = []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 25}
+
+// Metrics collects metrics about SiGa operations and exposes them in the
+// Prometheus text format. It implements http.Handler, so it can be mounted
+// directly by the application, e.g., at "/metrics".
+//
+// Create Metrics with NewMetrics and pass it to NewClient in Conf.Metrics.
+// A nil *Metrics discards all observations.
+type Metrics struct {
+	mu sync.Mutex
+
+	requests         *counterVec
+	requestDurations *histogramVec
+	operations       *counterVec
+	opDurations      *histogramVec
+	mobileID         *counterVec
+}
+
+// NewMetrics creates a new empty collection of metrics.
+func NewMetrics() *Metrics {
+	return &Metrics{
+		requests: newCounterVec("siga_http_requests_total",
+			"Number of requests made to the SiGa service.",
+			"endpoint", "method", "status", "error_code"),
+		requestDurations: newHistogramVec("siga_http_request_duration_seconds",
+			"Latency of requests made to the SiGa service.",
+			"endpoint", "method"),
+		operations: newCounterVec("siga_operations_total",
+			"Number of SiGa client operations.",
+			"operation", "result"),
+		opDurations: newHistogramVec("siga_operation_duration_seconds",
+			"Latency of SiGa client operations.",
+			"operation"),
+		mobileID: newCounterVec("siga_mobile_id_status_total",
+			"Number of Mobile-ID signing status responses by status.",
+			"status"),
+	}
+}
+
+// observeRequest records a request made to the SiGa service. status is zero
+// if no response was received.
+func (m *Metrics) observeRequest(method, uri string, status int, errorCode string, latency time.Duration) {
+	if m == nil {
+		return
+	}
+	endpoint := endpointFromURI(uri)
+	statusLabel := "none"
+	if status > 0 {
+		statusLabel = strconv.Itoa(status)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.requests.add(endpoint, method, statusLabel, errorCode)
+	m.requestDurations.observe(latency.Seconds(), endpoint, method)
+}
+
+// observeOperation records a SiGa client operation.
+func (m *Metrics) observeOperation(operation string, err error, latency time.Duration) {
+	if m == nil {
+		return
+	}
+	result := "success"
+	if err != nil {
+		result = "error"
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.operations.add(operation, result)
+	m.opDurations.observe(latency.Seconds(), operation)
+}
+
+// observeMobileID records a Mobile-ID signing status response.
+func (m *Metrics) observeMobileID(status string) {
+	if m == nil {
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.mobileID.add(status)
+}
+
+// ServeHTTP writes the metrics in the Prometheus text exposition format.
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	m.WriteTo(w)
+}
+
+// WriteTo writes the metrics in the Prometheus text exposition format to w.
+func (m *Metrics) WriteTo(w io.Writer) (int64, error) {
+	if m == nil {
+		return 0, nil
+	}
+
+	var buf strings.Builder
+	m.mu.Lock()
+	m.requests.write(&buf)
+	m.requestDurations.write(&buf)
+	m.operations.write(&buf)
+	m.opDurations.write(&buf)
+	m.mobileID.write(&buf)
+	m.mu.Unlock()
+
+	n, err := io.WriteString(w, buf.String())
+	return int64(n), err
+}
+
+// endpointFromURI replaces the identifiers in a SiGa request URI with
+// placeholders to keep the number of label values bounded.
+func endpointFromURI(uri string) string {
+	parts := strings.Split(strings.Trim(uri, "/"), "/")
+	if len(parts) > 1 && parts[0] == "hashcodecontainers" {
+		parts[1] = "{containerId}"
+		if len(parts) > 3 {
+			parts[3] = "{signatureId}"
+		}
+	}
+	return "/" + strings.Join(parts, "/")
+}
+
+// labelKey joins label values into a map key.
+func labelKey(values []string) string {
+	return strings.Join(values, "\xff")
+}
+
+// writeLabels writes the label pairs in exposition format to buf.
+func writeLabels(buf *strings.Builder, names, values []string, extra ...string) {
+	if len(names) == 0 && len(extra) == 0 {
+		return
+	}
+	buf.WriteByte('{')
+	for i, name := range names {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		fmt.Fprintf(buf, `%s="%s"`, name, escapeLabel(values[i]))
+	}
+	for i := 0; i+1 < len(extra); i += 2 {
+		if len(names) > 0 || i > 0 {
+			buf.WriteByte(',')
+		}
+		fmt.Fprintf(buf, `%s="%s"`, extra[i], escapeLabel(extra[i+1]))
+	}
+	buf.WriteByte('}')
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabel(value string) string {
+	return labelEscaper.Replace(value)
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+// counterVec is a counter partitioned by label values.
+type counterVec struct {
+	name, help string
+	labels     []string
+	values     map[string]uint64
+	keys       map[string][]string
+}
+
+func newCounterVec(name, help string, labels ...string) *counterVec {
+	return &counterVec{
+		name:   name,
+		help:   help,
+		labels: labels,
+		values: make(map[string]uint64),
+		keys:   make(map[string][]string),
+	}
+}
+
+func (c *counterVec) add(values ...string) {
+	key := labelKey(values)
+	c.values[key]++
+	c.keys[key] = values
+}
+
+func (c *counterVec) write(buf *strings.Builder) {
+	fmt.Fprintf(buf, "# HELP %s %s\n# TYPE %s counter\n", c.name, c.help, c.name)
+	for _, key := range sortedKeys(c.keys) {
+		buf.WriteString(c.name)
+		writeLabels(buf, c.labels, c.keys[key])
+		fmt.Fprintf(buf, " %d\n", c.values[key])
+	}
+}
+
+// histogramVec is a histogram partitioned by label values.
+type histogramVec struct {
+	name, help string
+	labels     []string
+	values     map[string]*histogram
+	keys       map[string][]string
+}
+
+type histogram struct {
+	buckets []uint64 // Non-cumulative counts per bucket in metricsBuckets.
+	count   uint64
+	sum     float64
+}
+
+func newHistogramVec(name, help string, labels ...string) *histogramVec {
+	return &histogramVec{
+		name:   name,
+		help:   help,
+		labels: labels,
+		values: make(map[string]*histogram),
+		keys:   make(map[string][]string),
+	}
+}
+
+func (h *histogramVec) observe(value float64, values ...string) {
+	key := labelKey(values)
+	hist, ok := h.values[key]
+	if !ok {
+		hist = &histogram{buckets: make([]uint64, len(metricsBuckets))}
+		h.values[key] = hist
+		h.keys[key] = values
+	}
+	for i, bound := range metricsBuckets {
+		if value <= bound {
+			hist.buckets[i]++
+			break
+		}
+	}
+	hist.count++
+	hist.sum += value
+}
+
+func (h *histogramVec) write(buf *strings.Builder) {
+	fmt.Fprintf(buf, "# HELP %s %s\n# TYPE %s histogram\n", h.name, h.help, h.name)
+	for _, key := range sortedKeys(h.keys) {
+		hist, values := h.values[key], h.keys[key]
+		var cumulative uint64
+		for i, bound := range metricsBuckets {
+			cumulative += hist.buckets[i]
+			buf.WriteString(h.name + "_bucket")
+			writeLabels(buf, h.labels, values, "le", formatFloat(bound))
+			fmt.Fprintf(buf, " %d\n", cumulative)
+		}
+		buf.WriteString(h.name + "_bucket")
+		writeLabels(buf, h.labels, values, "le", "+Inf")
+		fmt.Fprintf(buf, " %d\n", hist.count)
+
+		buf.WriteString(h.name + "_sum")
+		writeLabels(buf, h.labels, values)
+		fmt.Fprintf(buf, " %s\n", formatFloat(hist.sum))
+		buf.WriteString(h.name + "_count")
+		writeLabels(buf, h.labels, values)
+		fmt.Fprintf(buf, " %d\n", hist.count)
+	}
+}
+
+func sortedKeys(m map[string][]string) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// metricsClient wraps a Client and records metrics about its operations.
+type metricsClient struct {
+	Client
+	metrics *Metrics
+}
+
+func (m metricsClient) observe(operation string, start time.Time, err error) {
+	m.metrics.observeOperation(operation, err, time.Since(start))
+}
+
+func (m metricsClient) CreateContainer(ctx context.Context, session string, datafiles ...*DataFile) (err error) {
+	defer func(start time.Time) { m.observe("CreateContainer", start, err) }(time.Now())
+	return m.Client.CreateContainer(ctx, session, datafiles...)
+}
+
+func (m metricsClient) UploadContainer(ctx context.Context, session string, r io.Reader) (err error) {
+	defer func(start time.Time) { m.observe("UploadContainer", start, err) }(time.Now())
+	return m.Client.UploadContainer(ctx, session, r)
+}
+
+func (m metricsClient) StartRemoteSigning(ctx context.Context, session string, cert []byte) (
+	hash []byte, algorithm string, err error) {
+	defer func(start time.Time) { m.observe("StartRemoteSigning", start, err) }(time.Now())
+	return m.Client.StartRemoteSigning(ctx, session, cert)
+}
+
+func (m metricsClient) FinalizeRemoteSigning(ctx context.Context, session string, signature []byte) (err error) {
+	defer func(start time.Time) { m.observe("FinalizeRemoteSigning", start, err) }(time.Now())
+	return m.Client.FinalizeRemoteSigning(ctx, session, signature)
+}
+
+func (m metricsClient) StartMobileIDSigning(ctx context.Context, session, person, phone, message string) (
+	challenge string, err error) {
+	defer func(start time.Time) { m.observe("StartMobileIDSigning", start, err) }(time.Now())
+	return m.Client.StartMobileIDSigning(ctx, session, person, phone, message)
+}
+
+func (m metricsClient) RequestMobileIDSigningStatus(ctx context.Context, session string) (done bool, err error) {
+	defer func(start time.Time) { m.observe("RequestMobileIDSigningStatus", start, err) }(time.Now())
+	return m.Client.RequestMobileIDSigningStatus(ctx, session)
+}
+
+func (m metricsClient) WriteContainer(ctx context.Context, session string, w io.Writer) (err error) {
+	defer func(start time.Time) { m.observe("WriteContainer", start, err) }(time.Now())
+	return m.Client.WriteContainer(ctx, session, w)
+}
+
+func (m metricsClient) CloseContainer(ctx context.Context, session string) (err error) {
+	defer func(start time.Time) { m.observe("CloseContainer", start, err) }(time.Now())
+	return m.Client.CloseContainer(ctx, session)
+}
@@ -0,0 +1,56 @@
+package siga
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+func TestMetrics_Observations_Exposed(t *testing.T) {
+	// given
+	m := NewMetrics()
+	m.observeRequest(http.MethodPost, "/hashcodecontainers", 200, "", 30*time.Millisecond)
+	m.observeRequest(http.MethodGet, "/hashcodecontainers/abc/mobileidsigning/def/status",
+		400, "REQUEST_VALIDATION_EXCEPTION", 2*time.Second)
+	m.observeRequest(http.MethodDelete, "/hashcodecontainers/abc", 0, "", 25*time.Second)
+	m.observeOperation("CreateContainer", nil, time.Second)
+	m.observeOperation("CreateContainer", errors.New("error"), time.Second)
+	m.observeMobileID("OUTSTANDING_TRANSACTION")
+	rec := httptest.NewRecorder()
+
+	// when
+	m.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+	// then
+	body := rec.Body.String()
+	for _, expected := range []string{
+		"# TYPE siga_http_requests_total counter\n",
+		`siga_http_requests_total{endpoint="/hashcodecontainers",method="POST",status="200",error_code=""} 1`,
+		`siga_http_requests_total{endpoint="/hashcodecontainers/{containerId}/mobileidsigning/{signatureId}/status",` +
+			`method="GET",status="400",error_code="REQUEST_VALIDATION_EXCEPTION"} 1`,
+		`siga_http_requests_total{endpoint="/hashcodecontainers/{containerId}",method="DELETE",status="none",error_code=""} 1`,
+		`siga_http_request_duration_seconds_bucket{endpoint="/hashcodecontainers",method="POST",le="0.05"} 1`,
+		`siga_http_request_duration_seconds_bucket{endpoint="/hashcodecontainers/{containerId}",method="DELETE",le="10"} 0`,
+		`siga_http_request_duration_seconds_bucket{endpoint="/hashcodecontainers/{containerId}",method="DELETE",le="+Inf"} 1`,
+		`siga_operations_total{operation="CreateContainer",result="error"} 1`,
+		`siga_operations_total{operation="CreateContainer",result="success"} 1`,
+		`siga_operation_duration_seconds_sum{operation="CreateContainer"} 2`,
+		`siga_operation_duration_seconds_count{operation="CreateContainer"} 2`,
+		`siga_mobile_id_status_total{status="OUTSTANDING_TRANSACTION"} 1`,
+	} {
+		if !strings.Contains(body, expected) {
+			t.Errorf("missing %s in:\n%s", expected, body)
+		}
+	}
+}
+
+func TestMetrics_Nil_Discarded(t *testing.T) {
+	var m *Metrics
+	m.observeRequest(http.MethodPost, "/hashcodecontainers", 200, "", time.Second)
+	m.observeOperation("CreateContainer", nil, time.Second)
+	m.observeMobileID("SIGNATURE")
+}
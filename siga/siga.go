@@ -6,7 +6,10 @@ Authority of the Republic of Estonia.
 package siga
 
 import (
+	"time"
+
 	"github.com/e-gov/SiGa-Go/https"
+	"github.com/e-gov/SiGa-Go/tracing"
 )
 
 // Conf contains configuration values for the SiGa client.
@@ -27,6 +30,12 @@ type Conf struct {
 	// If HMACAlgorithm is empty, then "HMAC-SHA256" is used.
 	HMACAlgorithm string
 
+	// ServiceKeys is the schedule for rotating service keys. Each key
+	// replaces ServiceIdentifier, ServiceKey, and HMACAlgorithm starting
+	// from its ValidFrom time until the next key in the schedule becomes
+	// valid. Before the first key becomes valid, ServiceKey is used.
+	ServiceKeys []ServiceKey
+
 	// SignatureProfile is the signature profile used for qualifying
 	// signatures. Possible values are dictated by the SiGa service
 	// provider. If SignatureProfile is empty, then "LT" is used.
@@ -36,5 +45,55 @@ type Conf struct {
 	// phone during Mobile-ID signing. Possible values are dictated by the
 	// SiGa service provider. If MIDLanguage is empty, then "EST" is used.
 	MIDLanguage string
+
+	// ZipLimits are the limits applied to containers uploaded with
+	// UploadContainer. Zero values are replaced with defaults.
+	ZipLimits ZipLimits
+
+	// CircuitBreaker configures failing fast with ErrCircuitOpen while
+	// the SiGa service is unavailable. It is disabled by default.
+	CircuitBreaker CircuitBreakerConf
+
+	// RateLimit configures the maximum rate of requests made to the SiGa
+	// service. Requests are not rate limited by default.
+	RateLimit RateLimitConf
+
+	// Logger is used for logging client events. Personal data is redacted
+	// from the events. If Logger is nil, then events with at least
+	// LevelInfo are logged using the standard logger of package log.
+	Logger Logger `json:"-"`
+
+	// Metrics, if not nil, collects metrics about SiGa requests and client
+	// operations.
+	Metrics *Metrics `json:"-"`
+
+	// Tracer, if not nil, records a span for each client operation and
+	// SiGa request. Spans are children of the span carried by the context
+	// passed to Client methods and are propagated to the SiGa service in
+	// the traceparent header.
+	Tracer *tracing.Tracer `json:"-"`
 }
 
+// ServiceKey is a service key in the key rotation schedule.
+type ServiceKey struct {
+	// ValidFrom is the time starting from which the key is used.
+	ValidFrom time.Time
+
+	// ServiceIdentifier is the identifier used with the key. If
+	// ServiceIdentifier is empty, then Conf.ServiceIdentifier is used.
+	ServiceIdentifier string
+
+	// ServiceKey is the signing secret key.
+	ServiceKey string
+
+	// HMACAlgorithm is the HMAC algorithm used with the key. If
+	// HMACAlgorithm is empty, then Conf.HMACAlgorithm is used.
+	HMACAlgorithm string
+}
+
+// DefaultMaxResponseSize is the default maximum size of response bodies from
+// the SiGa service, used if MaxResponseSize of the embedded https.ClientConf
+// is zero. Responses contain at most hashcode form containers, which do not
+// include data files.
+const DefaultMaxResponseSize = 16 << 20 // 16 MiB
+
@@ -2,6 +2,7 @@ package siga
 
 import (
 	"context"
+	"sync"
 
 	"github.com/pkg/errors"
 )
@@ -12,6 +13,9 @@ type storage interface {
 	putStatus(ctx context.Context, session string, status status) error
 	getStatus(ctx context.Context, session string, mandatory bool) (*status, error)
 	removeStatus(ctx context.Context, session string) error
+	// sessions returns the identifiers of all sessions with an open
+	// container.
+	sessions(ctx context.Context) ([]string, error)
 
 	putData(ctx context.Context, key string, contents []byte) error
 	getData(ctx context.Context, key string) ([]byte, error)
@@ -23,6 +27,7 @@ type storage interface {
 // newMemStorage moodustab SiGa-ga suhtlemiseks vajaliku mälustruktuuri.
 func newMemStorage() storage {
 	return memStorage{
+		mu:     new(sync.Mutex),
 		status: make(map[string]status),
 		data:   make(map[string][]byte),
 	}
@@ -33,20 +38,30 @@ type status struct {
 	containerID string
 	filenames   []string
 	signatureID string
-}	
 
-// memStorage implements storage in memory for testing.
+	// zip contains the ZIP-archive metadata of data files by filename, so
+	// that complete containers are written identically each time.
+	zip map[string]zipMeta
+}
+
+// memStorage implements storage in memory. It is used by NewClient, so it
+// is shared by all sessions and safe for concurrent use.
 type memStorage struct {
+	mu     *sync.Mutex
 	status map[string]status
 	data   map[string][]byte
-}	
+}
 
 func (s memStorage) putStatus(ctx context.Context, session string, status status) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 	s.status[session] = status
 	return nil
 }
 
 func (s memStorage) getStatus(ctx context.Context, session string, mandatory bool) (*status, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 	status, ok := s.status[session]
 	if !ok {
 		if mandatory {
@@ -58,16 +73,32 @@ func (s memStorage) getStatus(ctx context.Context, session string, mandatory boo
 }
 
 func (s memStorage) removeStatus(ctx context.Context, session string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 	delete(s.status, session)
 	return nil
 }
 
+func (s memStorage) sessions(ctx context.Context) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sessions := make([]string, 0, len(s.status))
+	for session := range s.status {
+		sessions = append(sessions, session)
+	}
+	return sessions, nil
+}
+
 func (s memStorage) putData(ctx context.Context, key string, data []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 	s.data[key] = data
 	return nil
 }
 
 func (s memStorage) getData(ctx context.Context, key string) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 	data, ok := s.data[key]
 	if !ok {
 		return nil, errors.Errorf("memory: no data for %s", key)
@@ -76,6 +107,8 @@ func (s memStorage) getData(ctx context.Context, key string) ([]byte, error) {
 }
 
 func (s memStorage) removeData(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 	delete(s.data, key)
 	return nil
 }
@@ -0,0 +1,97 @@
+package siga
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+
+	"github.com/pkg/errors"
+
+	"github.com/e-gov/SiGa-Go/tracing"
+)
+
+// tracingClient wraps a Client and records a span for each operation. The
+// SiGa requests made during the operation are recorded as its children.
+type tracingClient struct {
+	Client
+	tracer *tracing.Tracer
+}
+
+func (t tracingClient) start(ctx context.Context, operation, session string) (context.Context, *tracing.Span) {
+	ctx, span := t.tracer.StartSpan(ctx, "siga."+operation)
+	span.SetAttribute("siga.session_hash", sessionHash(session))
+	return ctx, span
+}
+
+// sessionHash returns a one-way hash of the session key. Spans are exported
+// to external collectors, so they must not contain the session key itself,
+// but the hash still allows to correlate the spans of a session.
+func sessionHash(session string) string {
+	sum := sha256.Sum256([]byte(session))
+	return hex.EncodeToString(sum[:8])
+}
+
+// finishSpan finishes span with err. Like in the log, personal codes and
+// phone numbers are redacted from the error, because SiGa errors can contain
+// them and spans are exported to external collectors.
+func finishSpan(span *tracing.Span, err error) {
+	if err != nil {
+		err = errors.New(Redact(err.Error()))
+	}
+	span.Finish(err)
+}
+
+func (t tracingClient) CreateContainer(ctx context.Context, session string, datafiles ...*DataFile) (err error) {
+	ctx, span := t.start(ctx, "CreateContainer", session)
+	defer func() { finishSpan(span, err) }()
+	span.SetAttribute("siga.datafiles", len(datafiles))
+	return t.Client.CreateContainer(ctx, session, datafiles...)
+}
+
+func (t tracingClient) UploadContainer(ctx context.Context, session string, r io.Reader) (err error) {
+	ctx, span := t.start(ctx, "UploadContainer", session)
+	defer func() { finishSpan(span, err) }()
+	return t.Client.UploadContainer(ctx, session, r)
+}
+
+func (t tracingClient) StartRemoteSigning(ctx context.Context, session string, cert []byte) (
+	hash []byte, algorithm string, err error) {
+	ctx, span := t.start(ctx, "StartRemoteSigning", session)
+	defer func() { finishSpan(span, err) }()
+	return t.Client.StartRemoteSigning(ctx, session, cert)
+}
+
+func (t tracingClient) FinalizeRemoteSigning(ctx context.Context, session string, signature []byte) (err error) {
+	ctx, span := t.start(ctx, "FinalizeRemoteSigning", session)
+	defer func() { finishSpan(span, err) }()
+	return t.Client.FinalizeRemoteSigning(ctx, session, signature)
+}
+
+func (t tracingClient) StartMobileIDSigning(ctx context.Context, session, person, phone, message string) (
+	challenge string, err error) {
+	ctx, span := t.start(ctx, "StartMobileIDSigning", session)
+	defer func() { finishSpan(span, err) }()
+	return t.Client.StartMobileIDSigning(ctx, session, person, phone, message)
+}
+
+func (t tracingClient) RequestMobileIDSigningStatus(ctx context.Context, session string) (done bool, err error) {
+	ctx, span := t.start(ctx, "RequestMobileIDSigningStatus", session)
+	defer func() {
+		span.SetAttribute("siga.done", done)
+		finishSpan(span, err)
+	}()
+	return t.Client.RequestMobileIDSigningStatus(ctx, session)
+}
+
+func (t tracingClient) WriteContainer(ctx context.Context, session string, w io.Writer) (err error) {
+	ctx, span := t.start(ctx, "WriteContainer", session)
+	defer func() { finishSpan(span, err) }()
+	return t.Client.WriteContainer(ctx, session, w)
+}
+
+func (t tracingClient) CloseContainer(ctx context.Context, session string) (err error) {
+	ctx, span := t.start(ctx, "CloseContainer", session)
+	defer func() { finishSpan(span, err) }()
+	return t.Client.CloseContainer(ctx, session)
+}
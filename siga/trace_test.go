@@ -0,0 +1,134 @@
+package siga
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/e-gov/SiGa-Go/tracing"
+)
+
+type spanRecorder struct {
+	mu    sync.Mutex
+	spans []*tracing.Span
+}
+
+func (r *spanRecorder) Export(span *tracing.Span) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.spans = append(r.spans, span)
+}
+
+func TestHTTPClientDo_Tracer_SpanExportedAndPropagated(t *testing.T) {
+	// given
+	var traceparent string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		traceparent = r.Header.Get(tracing.TraceparentHeader)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"errorCode":"REQUEST_VALIDATION_EXCEPTION"}`))
+	}))
+	defer srv.Close()
+
+	var rec spanRecorder
+	tracer := tracing.NewTracer(&rec)
+	c := testHTTPClient(srv, time.Now)
+	c.tracer = tracer
+	ctx, parent := tracer.StartSpan(context.Background(), "parent")
+
+	// when
+	err := c.do(ctx, http.MethodDelete, "/hashcodecontainers/abc", nil, nil)
+
+	// then
+	if err == nil {
+		t.Fatal("expected error")
+	}
+	if len(rec.spans) != 1 {
+		t.Fatalf("unexpected number of spans: %d", len(rec.spans))
+	}
+	span := rec.spans[0]
+	if span.Name != "DELETE /hashcodecontainers/{containerId}" {
+		t.Errorf("unexpected span name: %s", span.Name)
+	}
+	if span.ParentID != parent.Context.SpanID || span.Context.TraceID != parent.Context.TraceID {
+		t.Error("request span is not a child of the parent span")
+	}
+	if traceparent != span.Context.Traceparent() {
+		t.Errorf("unexpected traceparent: %s", traceparent)
+	}
+	if span.Error == "" || span.Attributes["siga.error_code"] != "REQUEST_VALIDATION_EXCEPTION" ||
+		span.Attributes["siga.container_id"] != "abc" {
+		t.Errorf("unexpected span: %+v", span)
+	}
+}
+
+// closeClient is a Client whose CloseContainer always succeeds.
+type closeClient struct {
+	Client
+}
+
+func (closeClient) CloseContainer(ctx context.Context, session string) error {
+	return nil
+}
+
+func TestTracingClient_Session_OnlyHashExported(t *testing.T) {
+	// given
+	var rec spanRecorder
+	c := tracingClient{Client: closeClient{}, tracer: tracing.NewTracer(&rec)}
+	const session = "secret:idcard"
+
+	// when
+	err := c.CloseContainer(context.Background(), session)
+
+	// then
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rec.spans) != 1 {
+		t.Fatalf("unexpected number of spans: %d", len(rec.spans))
+	}
+	attrs := rec.spans[0].Attributes
+	for name, value := range attrs {
+		if s, ok := value.(string); ok && strings.Contains(s, "secret") {
+			t.Errorf("session key exported in attribute %s", name)
+		}
+	}
+	if attrs["siga.session_hash"] != sessionHash(session) || sessionHash(session) == sessionHash("other:idcard") {
+		t.Errorf("unexpected session hash: %v", attrs["siga.session_hash"])
+	}
+}
+
+// midErrorClient is a Client whose StartMobileIDSigning fails with an error
+// containing the personal code and phone number.
+type midErrorClient struct {
+	Client
+}
+
+func (midErrorClient) StartMobileIDSigning(ctx context.Context, session, person, phone, message string) (string, error) {
+	return "", errors.Errorf("invalid person %s with phone %s", person, phone)
+}
+
+func TestTracingClient_Error_Redacted(t *testing.T) {
+	// given
+	var rec spanRecorder
+	c := tracingClient{Client: midErrorClient{}, tracer: tracing.NewTracer(&rec)}
+
+	// when
+	_, err := c.StartMobileIDSigning(context.Background(), "sess:mid", "60001019906", "+37200000766", "")
+
+	// then
+	if err == nil || !strings.Contains(err.Error(), "60001019906") {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(rec.spans) != 1 {
+		t.Fatalf("unexpected number of spans: %d", len(rec.spans))
+	}
+	if e := rec.spans[0].Error; e == "" || strings.Contains(e, "60001019906") || strings.Contains(e, "37200000766") {
+		t.Errorf("personal data exported in span error: %s", e)
+	}
+}
@@ -0,0 +1,189 @@
+package siga
+
+import (
+	"archive/zip"
+	"io"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Default limits used if the corresponding values in ZipLimits are zero.
+const (
+	DefaultMaxEntries          = 1000
+	DefaultMaxFileSize         = 64 << 20  // 64 MiB
+	DefaultMaxTotalSize        = 256 << 20 // 256 MiB
+	DefaultMaxCompressionRatio = 100
+)
+
+// ZipLimits are the limits applied when reading untrusted containers, e.g.,
+// in Client.UploadContainer. A JSON-encoding of the limits can be directly
+// unmarshaled into an instance of ZipLimits.
+type ZipLimits struct {
+	// MaxEntries is the maximum number of entries in the ZIP-archive.
+	MaxEntries int
+
+	// MaxFileSize is the maximum uncompressed size of a single entry in
+	// bytes.
+	MaxFileSize int64
+
+	// MaxTotalSize is the maximum uncompressed size of all entries in
+	// bytes.
+	MaxTotalSize int64
+
+	// MaxCompressionRatio is the maximum ratio of uncompressed to
+	// compressed size of a single entry.
+	MaxCompressionRatio int64
+}
+
+// or returns a copy of l where zero values are replaced with defaults.
+func (l ZipLimits) or() ZipLimits {
+	if l.MaxEntries <= 0 {
+		l.MaxEntries = DefaultMaxEntries
+	}
+	if l.MaxFileSize <= 0 {
+		l.MaxFileSize = DefaultMaxFileSize
+	}
+	if l.MaxTotalSize <= 0 {
+		l.MaxTotalSize = DefaultMaxTotalSize
+	}
+	if l.MaxCompressionRatio <= 0 {
+		l.MaxCompressionRatio = DefaultMaxCompressionRatio
+	}
+	return l
+}
+
+// Errors returned for containers which exceed ZipLimits or fail structural
+// checks. They are wrapped in *ZipError, use errors.Is to check for them.
+var (
+	ErrTooManyEntries     = errors.New("too many entries")
+	ErrFileTooLarge       = errors.New("file too large")
+	ErrTotalTooLarge      = errors.New("total size too large")
+	ErrCompressionRatio   = errors.New("compression ratio too large")
+	ErrMimetypeNotFirst   = errors.New("mimetype not first file")
+	ErrMimetypeCompressed = errors.New("mimetype compressed")
+	ErrDuplicateName      = errors.New("duplicate name")
+	ErrUnsafePath         = errors.New("unsafe path")
+)
+
+// ZipError is the error returned when a ZIP-archive exceeds ZipLimits or fails
+// structural checks.
+type ZipError struct {
+	// Name is the name of the offending entry. It is empty if the error is
+	// not related to a single entry.
+	Name string
+
+	// Err is one of the Err* values describing the failed check.
+	Err error
+}
+
+func (e *ZipError) Error() string {
+	if e.Name == "" {
+		return "zip: " + e.Err.Error()
+	}
+	return "zip: " + e.Name + ": " + e.Err.Error()
+}
+
+// Unwrap returns the failed check for use with errors.Is.
+func (e *ZipError) Unwrap() error { return e.Err }
+
+// Cause returns the failed check for use with errors.Cause.
+func (e *ZipError) Cause() error { return e.Err }
+
+// checkZip performs structural checks on the container opened as reader and
+// checks the sizes declared in its headers against limits.
+//
+// The declared sizes are untrusted: archive/zip fails reading entries which
+// are larger than declared, but limitFile must still be used when reading
+// entries to guard against declarations which overflow.
+func checkZip(reader *zip.Reader, limits ZipLimits) error {
+	limits = limits.or()
+	if len(reader.File) > limits.MaxEntries {
+		return &ZipError{Err: ErrTooManyEntries}
+	}
+
+	var total uint64
+	names := make(map[string]struct{}, len(reader.File))
+	for i, file := range reader.File {
+		if !safePath(file.Name) {
+			return &ZipError{Name: file.Name, Err: ErrUnsafePath}
+		}
+		if _, ok := names[file.Name]; ok {
+			return &ZipError{Name: file.Name, Err: ErrDuplicateName}
+		}
+		names[file.Name] = struct{}{}
+
+		if file.Name == "mimetype" {
+			if i > 0 {
+				return &ZipError{Name: file.Name, Err: ErrMimetypeNotFirst}
+			}
+			if file.Method != zip.Store {
+				return &ZipError{Name: file.Name, Err: ErrMimetypeCompressed}
+			}
+		}
+
+		size := file.UncompressedSize64
+		if size > uint64(limits.MaxFileSize) {
+			return &ZipError{Name: file.Name, Err: ErrFileTooLarge}
+		}
+		if total += size; total > uint64(limits.MaxTotalSize) {
+			return &ZipError{Name: file.Name, Err: ErrTotalTooLarge}
+		}
+		if size > 0 && size/uint64(limits.MaxCompressionRatio) > file.CompressedSize64 {
+			return &ZipError{Name: file.Name, Err: ErrCompressionRatio}
+		}
+	}
+	if len(reader.File) > 0 && reader.File[0].Name != "mimetype" {
+		return &ZipError{Name: reader.File[0].Name, Err: ErrMimetypeNotFirst}
+	}
+	return nil
+}
+
+// safePath reports if name is a relative path which stays inside the
+// ZIP-archive when extracted.
+func safePath(name string) bool {
+	if name == "" || strings.HasPrefix(name, "/") || strings.ContainsAny(name, "\\\x00") {
+		return false
+	}
+	if len(name) >= 2 && name[1] == ':' { // Windows drive letter.
+		return false
+	}
+	for _, segment := range strings.Split(strings.TrimSuffix(name, "/"), "/") {
+		switch segment {
+		case "", ".", "..":
+			return false
+		}
+	}
+	return true
+}
+
+// limitFile opens file for reading, failing with ErrFileTooLarge if more than
+// limits.MaxFileSize bytes are read from it.
+func limitFile(file *zip.File, limits ZipLimits) (io.ReadCloser, error) {
+	r, err := file.Open()
+	if err != nil {
+		return nil, errors.Wrapf(err, "open %s", file.Name)
+	}
+	return &limitedFile{
+		ReadCloser: r,
+		name:       file.Name,
+		left:       limits.or().MaxFileSize,
+	}, nil
+}
+
+type limitedFile struct {
+	io.ReadCloser
+	name string
+	left int64
+}
+
+func (l *limitedFile) Read(p []byte) (int, error) {
+	if int64(len(p)) > l.left+1 {
+		p = p[:l.left+1]
+	}
+	n, err := l.ReadCloser.Read(p)
+	if l.left -= int64(n); l.left < 0 {
+		return 0, &ZipError{Name: l.name, Err: ErrFileTooLarge}
+	}
+	return n, err
+}
@@ -0,0 +1,136 @@
+package siga
+
+import (
+	"archive/zip"
+	"bytes"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+func runCheckZipTest(t *testing.T, container []byte, limits ZipLimits, expected error) {
+	t.Helper()
+
+	// when
+	err := checkZip(testZipReader(t, container), limits)
+
+	// then
+	if !errors.Is(err, expected) {
+		t.Fatalf("unexpected error:\n     got: %v\nexpected: %v", err, expected)
+	}
+	if expected != nil {
+		if _, ok := err.(*ZipError); !ok {
+			t.Errorf("unexpected error type: %T", err)
+		}
+	}
+}
+
+func TestCheckZip_ValidContainer_Succeeds(t *testing.T) {
+	runCheckZipTest(t, testContainer(t,
+		testZipFile{"mimetype", asiceMimetype},
+		testZipFile{"test.txt", "test"},
+		testZipFile{"META-INF/", ""},
+		testZipFile{"META-INF/manifest.xml", "<manifest/>"},
+	), ZipLimits{}, nil)
+}
+
+func TestCheckZip_TooManyEntries_Error(t *testing.T) {
+	runCheckZipTest(t, testContainer(t,
+		testZipFile{"mimetype", asiceMimetype},
+		testZipFile{"first.txt", "first"},
+		testZipFile{"second.txt", "second"},
+	), ZipLimits{MaxEntries: 2}, ErrTooManyEntries)
+}
+
+func TestCheckZip_FileTooLarge_Error(t *testing.T) {
+	runCheckZipTest(t, testContainer(t,
+		testZipFile{"mimetype", asiceMimetype},
+		testZipFile{"test.txt", strings.Repeat("x", 100)},
+	), ZipLimits{MaxFileSize: 99}, ErrFileTooLarge)
+}
+
+func TestCheckZip_TotalTooLarge_Error(t *testing.T) {
+	runCheckZipTest(t, testContainer(t,
+		testZipFile{"mimetype", asiceMimetype},
+		testZipFile{"first.txt", strings.Repeat("x", 100)},
+		testZipFile{"second.txt", strings.Repeat("x", 100)},
+	), ZipLimits{MaxTotalSize: 200}, ErrTotalTooLarge)
+}
+
+func TestCheckZip_CompressionRatio_Error(t *testing.T) {
+	runCheckZipTest(t, testContainer(t,
+		testZipFile{"mimetype", asiceMimetype},
+		testZipFile{"bomb.txt", strings.Repeat("\x00", 1<<20)},
+	), ZipLimits{}, ErrCompressionRatio)
+}
+
+func TestCheckZip_MimetypeNotFirst_Error(t *testing.T) {
+	runCheckZipTest(t, testContainer(t,
+		testZipFile{"test.txt", "test"},
+		testZipFile{"mimetype", asiceMimetype},
+	), ZipLimits{}, ErrMimetypeNotFirst)
+}
+
+func TestCheckZip_MimetypeCompressed_Error(t *testing.T) {
+	// given
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	fw, err := w.CreateHeader(&zip.FileHeader{Name: "mimetype", Method: zip.Deflate})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fw.Write([]byte(asiceMimetype)); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	runCheckZipTest(t, buf.Bytes(), ZipLimits{}, ErrMimetypeCompressed)
+}
+
+func TestCheckZip_DuplicateName_Error(t *testing.T) {
+	runCheckZipTest(t, testContainer(t,
+		testZipFile{"mimetype", asiceMimetype},
+		testZipFile{"test.txt", "first"},
+		testZipFile{"test.txt", "second"},
+	), ZipLimits{}, ErrDuplicateName)
+}
+
+func TestCheckZip_TraversalPath_Error(t *testing.T) {
+	runCheckZipTest(t, testContainer(t,
+		testZipFile{"mimetype", asiceMimetype},
+		testZipFile{"META-INF/../../test.txt", "test"},
+	), ZipLimits{}, ErrUnsafePath)
+}
+
+func TestCheckZip_AbsolutePath_Error(t *testing.T) {
+	runCheckZipTest(t, testContainer(t,
+		testZipFile{"mimetype", asiceMimetype},
+		testZipFile{"/etc/passwd", "test"},
+	), ZipLimits{}, ErrUnsafePath)
+}
+
+func TestLimitFile_UnderstatedSize_Error(t *testing.T) {
+	// given
+	container := testContainer(t,
+		testZipFile{"mimetype", asiceMimetype},
+		testZipFile{"test.txt", strings.Repeat("x", 100)},
+	)
+	file := testZipReader(t, container).File[1]
+	r, err := limitFile(file, ZipLimits{MaxFileSize: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	// when
+	_, err = ioutil.ReadAll(r)
+
+	// then
+	if !errors.Is(err, ErrFileTooLarge) {
+		t.Fatalf("unexpected error:\n     got: %v\nexpected: %v", err, ErrFileTooLarge)
+	}
+}
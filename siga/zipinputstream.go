@@ -5,30 +5,38 @@ import (
 	"bytes"
 	"compress/flate"
 	"encoding/binary"
+	"hash/crc32"
 	"io"
 	"io/ioutil"
 
 	"github.com/pkg/errors"
 )
 
-// forZipInputStream wraps w with a new io.Writer which expects an ASiC-E
-// container stream to be written to it. It modifies the stream by removing the
-// data descriptor of the first file ("mimetype") and updating its local file
-// header with the data from the descriptor. All other files must not have a
-// data descriptor or be compressed using DEFLATE.
+// forZipInputStream wraps w with a new io.WriteCloser which expects an ASiC-E
+// container stream to be written to it. It normalizes the stream by removing
+// the data descriptors of all files and updating their local file headers
+// with the data from the descriptors.
 //
-// The wrapper also updates any changed offsets in central directory entries.
-// The modified stream is written to w.
+// The wrapper also recalculates the offsets of local file headers in central
+// directory entries and the start of the central directory in the end of
+// central directory record. The normalized stream is written to w.
 //
 // This acts as a workaround for limited ZIP-archive parsing methods like
 // java.util.ZipInputStream, which do not consult the central directory and
 // therefore only support a subset of ZIP-archives.
 //
-// The returned io.Writer performs very little verification on the input and
-// writing non-valid ZIP-archives to it results in undefined behavior. It does
-// not work with multi-disk ZIP-archives and/or ZIP64.
-func forZipInputStream(w io.Writer) io.Writer {
-	return &zipInputStream{output: w, decomp: flate.NewReader(nil)}
+// Files can be stored or compressed using DEFLATE. The data descriptors of
+// stored files must have the optional signature, since their end can only be
+// found by scanning for it. Close must be called after writing the stream to
+// check that it was complete. It does not work with multi-disk ZIP-archives
+// and/or ZIP64.
+func forZipInputStream(w io.Writer) io.WriteCloser {
+	return &zipInputStream{
+		output:  w,
+		decomp:  flate.NewReader(nil),
+		offsets: make(map[uint32]uint32),
+		central: -1,
+	}
 }
 
 const (
@@ -37,20 +45,33 @@ const (
 	zipCentralSignature    = "\x50\x4b\x01\x02"
 	zipEOCDSignature       = "\x50\x4b\x05\x06"
 
-	asiceMimetype           = "application/vnd.etsi.asic-e+zip"
-	asiceMimetypeCRC32      = "\x8a\x21\xf9\x45"
-	asiceMimetypeSize       = "\x1f\x00\x00\x00"
-	asiceMimetypeDescriptor = zipDescriptorSignature +
-		asiceMimetypeCRC32 + asiceMimetypeSize + asiceMimetypeSize
+	zipDescriptorFlag = 0x8
+	zip64Marker       = 0xffffffff
+
+	asiceMimetype = "application/vnd.etsi.asic-e+zip"
 )
 
 type zipInputStream struct {
 	buf     bytes.Buffer
 	err     error
 	output  io.Writer
-	written int64
+	read    int64 // Number of bytes consumed from the input stream.
+	written int64 // Number of bytes written to the output stream.
 	decomp  io.ReadCloser
-	recalc  bool
+	done    bool // Whether the end of central directory was flushed.
+
+	// offsets maps the input offsets of flushed local file headers to
+	// their output offsets.
+	offsets map[uint32]uint32
+
+	// central is the output offset of the central directory or -1 if no
+	// central directory entries have been flushed yet.
+	central int64
+
+	// scan and scanCRC are the number of data bytes already scanned for a
+	// data descriptor of a stored file and their CRC-32 checksum.
+	scan    int
+	scanCRC uint32
 }
 
 func (z *zipInputStream) Write(p []byte) (int, error) {
@@ -65,12 +86,15 @@ func (z *zipInputStream) Write(p []byte) (int, error) {
 			break // Not enough data to continue.
 		}
 
-		switch sig := string(z.buf.Bytes()[:4]); sig {
-		case zipLocalSignature:
+		switch sig := string(z.buf.Bytes()[:4]); {
+		case z.done:
+			z.err = errors.New("data after end of central directory")
+			ok = false
+		case sig == zipLocalSignature:
 			ok = z.flushLocal()
-		case zipCentralSignature:
+		case sig == zipCentralSignature:
 			ok = z.flushCentral()
-		case zipEOCDSignature:
+		case sig == zipEOCDSignature:
 			ok = z.flushEOCD()
 		default:
 			z.err = errors.Errorf("unknown signature: %x", sig)
@@ -80,6 +104,20 @@ func (z *zipInputStream) Write(p []byte) (int, error) {
 	return len(p), z.err
 }
 
+// Close checks that the entire ZIP-archive was written and flushed. It does
+// not close the underlying io.Writer.
+func (z *zipInputStream) Close() error {
+	switch {
+	case z.err != nil:
+		return z.err
+	case !z.done:
+		return errors.New("missing end of central directory")
+	case z.buf.Len() > 0:
+		return errors.Errorf("%d trailing bytes", z.buf.Len())
+	}
+	return nil
+}
+
 // flushLocal attempts to process and flush a single local file entry from the
 // buffer. It returns false if it did not succeed.
 //
@@ -90,7 +128,7 @@ func (z *zipInputStream) flushLocal() bool {
 	if len(buf) < 30 {
 		return false
 	}
-	descriptor := buf[6]&8 == 8
+	descriptor := buf[6]&zipDescriptorFlag == zipDescriptorFlag
 	compression := binary.LittleEndian.Uint16(buf[8:10])
 	size := binary.LittleEndian.Uint32(buf[18:22])
 	name := binary.LittleEndian.Uint16(buf[26:28])
@@ -100,15 +138,25 @@ func (z *zipInputStream) flushLocal() bool {
 	if len(buf) < header {
 		return false
 	}
+	if string(buf[30:30+name]) == "mimetype" && z.written > 0 {
+		z.err = errors.New("mimetype not first file in stream")
+		return false
+	}
 
 	// If no descriptor is used, then try to flush the header and data.
 	if !descriptor {
-		return z.flushBytes(header + int(size))
+		if size == zip64Marker {
+			z.err = errors.New("ZIP64 not supported")
+			return false
+		}
+		return z.flushEntry(header, int(size), 0)
 	}
 
-	// If DEFLATE compression is used, then try to flush the header, data,
-	// and descriptor.
-	if compression == zip.Deflate {
+	// Otherwise find the end of the data, so we know where the data
+	// descriptor is.
+	var data int
+	switch compression {
+	case zip.Deflate:
 		// Check if we have the entire compressed stream (DEFLATE
 		// indicates which block is final).
 		r := bytes.NewReader(buf[header:])
@@ -124,43 +172,83 @@ func (z *zipInputStream) flushLocal() bool {
 			z.err = errors.WithStack(err)
 			return false
 		}
+		data = len(buf) - header - r.Len()
 
-		// Flush read portion of buf + 16 data descriptor bytes.
-		return z.flushBytes(len(buf) - r.Len() + 16)
-	}
+	case zip.Store:
+		// Do not attempt to parse raw data: scan for a data descriptor
+		// signature followed by a matching checksum and sizes.
+		var ok bool
+		if data, ok = z.scanStored(buf[header:]); !ok {
+			return false // Not enough data yet.
+		}
 
-	// Otherwise must be "mimetype".
-	if string(buf[30:30+name]) != "mimetype" {
-		z.err = errors.New("only mimetype may use a data descriptor and be uncompressed")
+	default:
+		z.err = errors.Errorf("unsupported compression method: %d", compression)
 		return false
 	}
-	if z.written > 0 {
-		z.err = errors.New("mimetype not first file in stream")
+
+	// Parse the data descriptor, which may or may not have a signature.
+	desc := buf[header+data:]
+	if len(desc) < 4 {
 		return false
 	}
-
-	// Do not attempt to scan for the data descriptor signature over raw
-	// data, compare against known value instead.
-	end := header + len(asiceMimetype) + len(asiceMimetypeDescriptor)
-	if len(buf) < end {
+	descLen := 12
+	if string(desc[:4]) == zipDescriptorSignature {
+		desc, descLen = desc[4:], 16
+	}
+	if len(desc) < 12 {
 		return false
 	}
-	if data := string(buf[header:end]); data != asiceMimetype+asiceMimetypeDescriptor {
-		z.err = errors.Errorf("unexpected mimetype data: %q", data)
+	crc := binary.LittleEndian.Uint32(desc[0:4])
+	compressed := binary.LittleEndian.Uint32(desc[4:8])
+	uncompressed := binary.LittleEndian.Uint32(desc[8:12])
+	if compressed != uint32(data) {
+		z.err = errors.Errorf("data descriptor size %d does not match data size %d",
+			compressed, data)
 		return false
 	}
 
 	// Update local file header and flush it with data. Skip descriptor.
-	buf[6] &^= 8
-	copy(buf[14:], asiceMimetypeCRC32)
-	copy(buf[18:], asiceMimetypeSize)
-	copy(buf[22:], asiceMimetypeSize)
-	ok := z.flushBytes(header + len(asiceMimetype))
-	if ok {
-		z.buf.Next(len(asiceMimetypeDescriptor))
-		z.recalc = true // Offsets need to be recalculated.
-	}
-	return ok
+	buf[6] &^= zipDescriptorFlag
+	binary.LittleEndian.PutUint32(buf[14:18], crc)
+	binary.LittleEndian.PutUint32(buf[18:22], compressed)
+	binary.LittleEndian.PutUint32(buf[22:26], uncompressed)
+	return z.flushEntry(header, data, descLen)
+}
+
+// scanStored scans the data of a stored file for a data descriptor with a
+// signature and matching checksum and sizes. It returns the size of the data
+// and true if the descriptor was found. The progress is saved in z so that
+// the same data is not scanned again on the next call.
+func (z *zipInputStream) scanStored(data []byte) (int, bool) {
+	for ; z.scan+16 <= len(data); z.scan++ {
+		desc := data[z.scan:]
+		if string(desc[:4]) == zipDescriptorSignature &&
+			binary.LittleEndian.Uint32(desc[4:8]) == z.scanCRC &&
+			binary.LittleEndian.Uint32(desc[8:12]) == uint32(z.scan) &&
+			binary.LittleEndian.Uint32(desc[12:16]) == uint32(z.scan) {
+			return z.scan, true
+		}
+		z.scanCRC = crc32.Update(z.scanCRC, crc32.IEEETable, desc[:1])
+	}
+	return 0, false
+}
+
+// flushEntry flushes a local file entry with a header of the given size and
+// data, and skips a data descriptor of size desc. It records the change in
+// the local file header offset.
+func (z *zipInputStream) flushEntry(header, data, desc int) bool {
+	if z.buf.Len() < header+data+desc {
+		return false
+	}
+	read, written := z.read, z.written
+	if !z.flushBytes(header + data) {
+		return false
+	}
+	z.skipBytes(desc)
+	z.offsets[uint32(read)] = uint32(written)
+	z.scan, z.scanCRC = 0, 0
+	return true
 }
 
 // flushCentral attempts to process and flush a single central directory file
@@ -185,13 +273,24 @@ func (z *zipInputStream) flushCentral() bool {
 		return false
 	}
 
-	// If the mimetype data descriptor was removed and offsets need to be
-	// recalculated, then do so for all entries except for the first one.
-	if z.recalc && offset > 0 {
-		offset -= uint32(len(asiceMimetypeDescriptor))
-		binary.LittleEndian.PutUint32(buf[42:46], offset)
+	// Update the offset to the local file header and clear the data
+	// descriptor flag to match it.
+	recalc, ok := z.offsets[offset]
+	if !ok {
+		z.err = errors.Errorf("no local file header at offset %d", offset)
+		return false
+	}
+	buf[8] &^= zipDescriptorFlag
+	binary.LittleEndian.PutUint32(buf[42:46], recalc)
+
+	written := z.written
+	if !z.flushBytes(header) {
+		return false
+	}
+	if z.central < 0 {
+		z.central = written
 	}
-	return z.flushBytes(header)
+	return true
 }
 
 // flushEOCD attempts to process and flush the end of central directory record
@@ -204,7 +303,6 @@ func (z *zipInputStream) flushEOCD() bool {
 	if len(buf) < 22 {
 		return false
 	}
-	offset := binary.LittleEndian.Uint32(buf[16:20])
 	comment := binary.LittleEndian.Uint16(buf[20:22])
 
 	// Ensure enough data for flushBytes before recalculating so it is only
@@ -214,13 +312,15 @@ func (z *zipInputStream) flushEOCD() bool {
 		return false
 	}
 
-	// If the mimetype data descriptor was removed and offsets need to be
-	// recalculated, then do so for the start of central directory offset.
-	if z.recalc {
-		offset -= uint32(len(asiceMimetypeDescriptor))
-		binary.LittleEndian.PutUint32(buf[16:20], offset)
+	// Update the start of central directory offset. If the archive is
+	// empty, then the central directory starts here.
+	central := z.central
+	if central < 0 {
+		central = z.written
 	}
-	return z.flushBytes(header)
+	binary.LittleEndian.PutUint32(buf[16:20], uint32(central))
+	z.done = z.flushBytes(header)
+	return z.done
 }
 
 func (z *zipInputStream) flushBytes(n int) bool {
@@ -228,6 +328,7 @@ func (z *zipInputStream) flushBytes(n int) bool {
 		return false
 	}
 	n, err := z.output.Write(z.buf.Next(n))
+	z.read += int64(n)
 	z.written += int64(n)
 	if err != nil {
 		z.err = errors.WithStack(err)
@@ -235,3 +336,7 @@ func (z *zipInputStream) flushBytes(n int) bool {
 	}
 	return true
 }
+
+func (z *zipInputStream) skipBytes(n int) {
+	z.read += int64(len(z.buf.Next(n)))
+}
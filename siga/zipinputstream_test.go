@@ -1,7 +1,10 @@
 package siga
 
 import (
+	"archive/zip"
 	"bytes"
+	"io/ioutil"
+	"strings"
 	"testing"
 )
 
@@ -37,11 +40,11 @@ func TestForZipInputStream_LocalNoDescriptor_Unmodified(t *testing.T) {
 	}
 }
 
-func TestForZipInputStream_LocalDescriptorDeflate_Unmodified(t *testing.T) {
+func TestForZipInputStream_LocalDescriptorDeflate_DescriptorRemoved(t *testing.T) {
 	// given
 	var out bytes.Buffer
 	zis := forZipInputStream(&out)
-	header := []byte{
+	with := []byte{
 		0x50, 0x4b, 0x03, 0x04,
 		0x00, 0x00,
 		0x08, 0x00, // Data descriptor flag.
@@ -60,16 +63,84 @@ func TestForZipInputStream_LocalDescriptorDeflate_Unmodified(t *testing.T) {
 		0x06, 0x00, 0x00, 0x00,
 		0x04, 0x00, 0x00, 0x00,
 	}
+	without := []byte{
+		0x50, 0x4b, 0x03, 0x04,
+		0x00, 0x00,
+		0x00, 0x00, // No data descriptor flag.
+		0x08, 0x00, // DEFLATE compression.
+		0x00, 0x00,
+		0x00, 0x00,
+		0x63, 0xf3, 0xf3, 0xad, // Values from data descriptor.
+		0x06, 0x00, 0x00, 0x00,
+		0x04, 0x00, 0x00, 0x00,
+		0x04, 0x00,
+		0x00, 0x00,
+		't', 'e', 's', 't',
+		0x4b, 0x49, 0x2c, 0x49, 0x04, 0x00,
+		// No data descriptor.
+	}
 
 	// when
-	_, err := zis.Write(header)
+	_, err := zis.Write(with)
 
 	// then
 	if err != nil {
 		t.Fatal("unexpected error:", err)
 	}
-	if !bytes.Equal(header, out.Bytes()) {
-		t.Errorf("header was modified:\n     got: %x\nexpected: %x", out.Bytes(), header)
+	if !bytes.Equal(without, out.Bytes()) {
+		t.Errorf("unexpected header:\n     got: %x\nexpected: %x", out.Bytes(), without)
+	}
+}
+
+func TestForZipInputStream_LocalDescriptorNoSignature_DescriptorRemoved(t *testing.T) {
+	// given
+	var out bytes.Buffer
+	zis := forZipInputStream(&out)
+	with := []byte{
+		0x50, 0x4b, 0x03, 0x04,
+		0x00, 0x00,
+		0x08, 0x00, // Data descriptor flag.
+		0x08, 0x00, // DEFLATE compression.
+		0x00, 0x00,
+		0x00, 0x00,
+		0x00, 0x00, 0x00, 0x00,
+		0x00, 0x00, 0x00, 0x00,
+		0x00, 0x00, 0x00, 0x00,
+		0x04, 0x00,
+		0x00, 0x00,
+		't', 'e', 's', 't',
+		0x4b, 0x49, 0x2c, 0x49, 0x04, 0x00,
+		// Data descriptor without signature.
+		0x63, 0xf3, 0xf3, 0xad,
+		0x06, 0x00, 0x00, 0x00,
+		0x04, 0x00, 0x00, 0x00,
+	}
+	without := []byte{
+		0x50, 0x4b, 0x03, 0x04,
+		0x00, 0x00,
+		0x00, 0x00, // No data descriptor flag.
+		0x08, 0x00, // DEFLATE compression.
+		0x00, 0x00,
+		0x00, 0x00,
+		0x63, 0xf3, 0xf3, 0xad, // Values from data descriptor.
+		0x06, 0x00, 0x00, 0x00,
+		0x04, 0x00, 0x00, 0x00,
+		0x04, 0x00,
+		0x00, 0x00,
+		't', 'e', 's', 't',
+		0x4b, 0x49, 0x2c, 0x49, 0x04, 0x00,
+		// No data descriptor.
+	}
+
+	// when
+	_, err := zis.Write(with)
+
+	// then
+	if err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	if !bytes.Equal(without, out.Bytes()) {
+		t.Errorf("unexpected header:\n     got: %x\nexpected: %x", out.Bytes(), without)
 	}
 }
 
@@ -129,11 +200,11 @@ func TestForZipInputStream_LocalDescriptorStoreMimetype_DescriptorRemoved(t *tes
 	}
 }
 
-func TestForZipInputStream_LocalDescriptorStoreOther_Error(t *testing.T) {
+func TestForZipInputStream_LocalDescriptorStoreOther_DescriptorRemoved(t *testing.T) {
 	// given
 	var out bytes.Buffer
 	zis := forZipInputStream(&out)
-	header := []byte{
+	with := []byte{
 		0x50, 0x4b, 0x03, 0x04,
 		0x00, 0x00,
 		0x08, 0x00, // Data descriptor flag.
@@ -152,13 +223,32 @@ func TestForZipInputStream_LocalDescriptorStoreOther_Error(t *testing.T) {
 		0x04, 0x00, 0x00, 0x00,
 		0x04, 0x00, 0x00, 0x00,
 	}
+	without := []byte{
+		0x50, 0x4b, 0x03, 0x04,
+		0x00, 0x00,
+		0x00, 0x00, // No data descriptor flag.
+		0x00, 0x00, // No compression.
+		0x00, 0x00,
+		0x00, 0x00,
+		0x63, 0xf3, 0xf3, 0xad, // Values from data descriptor.
+		0x04, 0x00, 0x00, 0x00,
+		0x04, 0x00, 0x00, 0x00,
+		0x04, 0x00,
+		0x00, 0x00,
+		't', 'e', 's', 't',
+		'd', 'a', 't', 'a',
+		// No data descriptor.
+	}
 
 	// when
-	_, err := zis.Write(header)
+	_, err := zis.Write(with)
 
 	// then
-	if err == nil {
-		t.Fatal("unexpected success")
+	if err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	if !bytes.Equal(without, out.Bytes()) {
+		t.Errorf("unexpected header:\n     got: %x\nexpected: %x", out.Bytes(), without)
 	}
 }
 
@@ -202,7 +292,7 @@ func TestForZipInputStream_CentralOffsetZero_Unmodified(t *testing.T) {
 	// given
 	var out bytes.Buffer
 	zis := forZipInputStream(&out)
-	zis.(*zipInputStream).recalc = true
+	zis.(*zipInputStream).offsets[0] = 0
 	header := []byte{
 		0x50, 0x4b, 0x01, 0x02,
 		0x00, 0x00,
@@ -240,7 +330,7 @@ func TestForZipInputStream_CentralOffsetNonZero_Recalculated(t *testing.T) {
 	// given
 	var out bytes.Buffer
 	zis := forZipInputStream(&out)
-	zis.(*zipInputStream).recalc = true
+	zis.(*zipInputStream).offsets[32] = 16
 	original := []byte{
 		0x50, 0x4b, 0x01, 0x02,
 		0x00, 0x00,
@@ -294,11 +384,45 @@ func TestForZipInputStream_CentralOffsetNonZero_Recalculated(t *testing.T) {
 	}
 }
 
+func TestForZipInputStream_CentralOffsetUnknown_Error(t *testing.T) {
+	// given
+	var out bytes.Buffer
+	zis := forZipInputStream(&out)
+	header := []byte{
+		0x50, 0x4b, 0x01, 0x02,
+		0x00, 0x00,
+		0x00, 0x00,
+		0x00, 0x00,
+		0x00, 0x00,
+		0x00, 0x00,
+		0x00, 0x00,
+		0x00, 0x00, 0x00, 0x00,
+		0x00, 0x00, 0x00, 0x00,
+		0x00, 0x00, 0x00, 0x00,
+		0x04, 0x00,
+		0x00, 0x00,
+		0x00, 0x00,
+		0x00, 0x00,
+		0x00, 0x00,
+		0x00, 0x00, 0x00, 0x00,
+		0x20, 0x00, 0x00, 0x00, // Relative offset 32.
+		't', 'e', 's', 't',
+	}
+
+	// when
+	_, err := zis.Write(header)
+
+	// then
+	if err == nil {
+		t.Fatal("unexpected success")
+	}
+}
+
 func TestForZipInputStream_EOCD_Recalculated(t *testing.T) {
 	// given
 	var out bytes.Buffer
 	zis := forZipInputStream(&out)
-	zis.(*zipInputStream).recalc = true
+	zis.(*zipInputStream).central = 16
 	original := []byte{
 		0x50, 0x4b, 0x05, 0x06,
 		0x00, 0x00,
@@ -331,3 +455,91 @@ func TestForZipInputStream_EOCD_Recalculated(t *testing.T) {
 		t.Errorf("unexpected header:\n     got: %x\nexpected: %x", out.Bytes(), recalc)
 	}
 }
+
+func TestForZipInputStream_ZipWriterArchive_Normalized(t *testing.T) {
+	// given
+	var out bytes.Buffer
+	zis := forZipInputStream(&out)
+	w := zip.NewWriter(zis) // Writes data descriptors for all files.
+	files := []testZipFile{
+		{"mimetype", asiceMimetype},
+		{"stored.txt", "stored"},
+		{"deflated.txt", strings.Repeat("deflated", 1000)},
+		{"META-INF/manifest.xml", "<manifest/>"},
+	}
+	for _, file := range files {
+		method := zip.Deflate
+		if file.name != "deflated.txt" && file.name != "META-INF/manifest.xml" {
+			method = zip.Store
+		}
+		fw, err := w.CreateHeader(&zip.FileHeader{Name: file.name, Method: method})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := fw.Write([]byte(file.contents)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// when
+	err := w.Close()
+	if err == nil {
+		err = zis.Close()
+	}
+
+	// then
+	if err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	if bytes.Contains(out.Bytes(), []byte(zipDescriptorSignature)) {
+		t.Error("data descriptor in output")
+	}
+	reader, err := zip.NewReader(bytes.NewReader(out.Bytes()), int64(out.Len()))
+	if err != nil {
+		t.Fatal("invalid output:", err)
+	}
+	if len(reader.File) != len(files) {
+		t.Fatalf("unexpected number of files: %d", len(reader.File))
+	}
+	for i, file := range reader.File {
+		if file.Flags&zipDescriptorFlag != 0 {
+			t.Errorf("data descriptor flag set for %s", file.Name)
+		}
+		r, err := file.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		contents, err := ioutil.ReadAll(r)
+		r.Close()
+		if err != nil {
+			t.Fatalf("read %s: %v", file.Name, err)
+		}
+		if string(contents) != files[i].contents {
+			t.Errorf("unexpected %s contents", file.Name)
+		}
+	}
+}
+
+func TestForZipInputStream_Truncated_CloseError(t *testing.T) {
+	// given
+	var archive bytes.Buffer
+	w := zip.NewWriter(&archive)
+	if _, err := w.Create("test.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	zis := forZipInputStream(ioutil.Discard)
+
+	// when
+	_, err := zis.Write(archive.Bytes()[:archive.Len()-10])
+	if err == nil {
+		err = zis.Close()
+	}
+
+	// then
+	if err == nil {
+		t.Fatal("unexpected success")
+	}
+}
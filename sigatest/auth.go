@@ -0,0 +1,72 @@
+package sigatest
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// authorize reads the body of r and verifies the HMAC authorization headers
+// of the request over it. The signature is calculated the same way as by the
+// SiGa service:
+//
+//	HMAC(key, identifier:timestamp:method:uri:body)
+//
+// where uri is the request URI without the host.
+func (s *Server) authorize(r *http.Request) ([]byte, error) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return nil, validationError("read body: " + err.Error())
+	}
+
+	identifier := r.Header.Get("X-Authorization-ServiceUUID")
+	timestamp := r.Header.Get("X-Authorization-Timestamp")
+	signature := r.Header.Get("X-Authorization-Signature")
+
+	s.mu.Lock()
+	key, ok := s.keys[identifier]
+	s.mu.Unlock()
+	if !ok {
+		return nil, authorizationError("unknown service UUID: %q", identifier)
+	}
+
+	unix, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return nil, authorizationError("invalid timestamp: %q", timestamp)
+	}
+	if skew := s.now().Sub(time.Unix(unix, 0)); skew > s.MaxClockSkew || skew < -s.MaxClockSkew {
+		return nil, authorizationError("request timestamp expired")
+	}
+
+	var newHash func() hash.Hash
+	switch algorithm := r.Header.Get("X-Authorization-Hmac-Algorithm"); algorithm {
+	case "", "HmacSHA256":
+		newHash = sha256.New
+	case "HmacSHA384":
+		newHash = sha512.New384
+	case "HmacSHA512":
+		newHash = sha512.New
+	default:
+		return nil, authorizationError("unsupported HMAC algorithm: %q", algorithm)
+	}
+
+	mac := hmac.New(newHash, []byte(key))
+	fmt.Fprintf(mac, "%s:%s:%s:%s:", identifier, timestamp, r.Method, r.URL.RequestURI())
+	mac.Write(body)
+	expected := hex.EncodeToString(mac.Sum(nil))
+	if !hmac.Equal([]byte(signature), []byte(expected)) {
+		return nil, authorizationError("invalid HMAC signature")
+	}
+	return body, nil
+}
+
+func authorizationError(format string, args ...interface{}) *sigaError {
+	return &sigaError{http.StatusUnauthorized, codeAuthorization, fmt.Sprintf(format, args...)}
+}
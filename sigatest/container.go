@@ -0,0 +1,373 @@
+package sigatest
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/pem"
+	"encoding/xml"
+	"fmt"
+	"html"
+	"io/ioutil"
+	"math/big"
+	"net/url"
+	"path"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	asiceMimetype   = "application/vnd.etsi.asic-e+zip"
+	manifestFile    = "META-INF/manifest.xml"
+	hashcodesSHA256 = "META-INF/hashcodes-sha256.xml"
+	hashcodesSHA512 = "META-INF/hashcodes-sha512.xml"
+)
+
+// dataFile is the metadata of a data file in a hashcode container.
+type dataFile struct {
+	Name   string `json:"fileName"`
+	SHA256 string `json:"fileHashSha256"`
+	SHA512 string `json:"fileHashSha512"`
+	Size   int64  `json:"fileSize"`
+
+	// MediaType is only known for data files of uploaded containers: like
+	// SiGa, the API has no media type field, so created data files are
+	// listed as application/octet-stream in the manifest.
+	MediaType string `json:"-"`
+}
+
+func (d dataFile) validate() error {
+	if d.Name == "" || strings.ContainsAny(d.Name, "/\\") {
+		return validationError("invalid file name: " + d.Name)
+	}
+	if hash, err := base64.StdEncoding.DecodeString(d.SHA256); err != nil || len(hash) != sha256.Size {
+		return validationError("invalid SHA-256 hash of " + d.Name)
+	}
+	if hash, err := base64.StdEncoding.DecodeString(d.SHA512); err != nil || len(hash) != sha512.Size {
+		return validationError("invalid SHA-512 hash of " + d.Name)
+	}
+	if d.Size < 0 {
+		return validationError("invalid size of " + d.Name)
+	}
+	return nil
+}
+
+// metaFile is a file in the META-INF directory of a container, other than
+// the manifest and hashcode files, e.g., a signature.
+type metaFile struct {
+	name     string
+	contents []byte
+}
+
+// container is a hashcode container stored in the emulator.
+type container struct {
+	dataFiles []dataFile
+	files     []metaFile
+	signing   map[string]*pendingSignature // By signature identifier.
+}
+
+// pendingSignature is a signature which has been started, but not finished.
+type pendingSignature struct {
+	cert       *x509.Certificate // nil for Mobile-ID signatures.
+	signedInfo []byte
+}
+
+// signatures returns the number of signatures in c.
+func (c *container) signatures() int {
+	var n int
+	for _, file := range c.files {
+		if strings.Contains(path.Base(file.name), "signature") {
+			n++
+		}
+	}
+	return n
+}
+
+// startSigning starts a new signature over the data files of c.
+func (c *container) startSigning(cert *x509.Certificate) (string, *pendingSignature) {
+	id := "S" + newID()
+	pending := &pendingSignature{cert: cert, signedInfo: c.signedInfo(cert)}
+	c.signing[id] = pending
+	return id, pending
+}
+
+// signedInfo returns the XML-DSig SignedInfo element to be signed.
+func (c *container) signedInfo(cert *x509.Certificate) []byte {
+	method := "http://www.w3.org/2001/04/xmldsig-more#ecdsa-sha512"
+	if cert != nil && cert.PublicKeyAlgorithm == x509.RSA {
+		method = "http://www.w3.org/2001/04/xmldsig-more#rsa-sha512"
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString(`<ds:SignedInfo xmlns:ds="http://www.w3.org/2000/09/xmldsig#">`)
+	buf.WriteString(`<ds:CanonicalizationMethod Algorithm="http://www.w3.org/2006/12/xml-c14n11"></ds:CanonicalizationMethod>`)
+	fmt.Fprintf(&buf, `<ds:SignatureMethod Algorithm="%s"></ds:SignatureMethod>`, method)
+	for i, datafile := range c.dataFiles {
+		fmt.Fprintf(&buf, `<ds:Reference Id="r-%d" URI="%s">`, i, html.EscapeString(url.PathEscape(datafile.Name)))
+		buf.WriteString(`<ds:DigestMethod Algorithm="http://www.w3.org/2001/04/xmlenc#sha512"></ds:DigestMethod>`)
+		fmt.Fprintf(&buf, `<ds:DigestValue>%s</ds:DigestValue>`, datafile.SHA512)
+		buf.WriteString(`</ds:Reference>`)
+	}
+	buf.WriteString(`</ds:SignedInfo>`)
+	return buf.Bytes()
+}
+
+// finishSigning adds the signature with the given identifier and value to
+// c as a new signature file.
+func (c *container) finishSigning(id string, value []byte) error {
+	pending, ok := c.signing[id]
+	if !ok {
+		return notFoundError("signature not found: " + id)
+	}
+	delete(c.signing, id)
+
+	var buf bytes.Buffer
+	buf.WriteString(`<?xml version="1.0" encoding="UTF-8" standalone="no"?>`)
+	buf.WriteString(`<asic:XAdESSignatures xmlns:asic="http://uri.etsi.org/02918/v1.2.1#" ` +
+		`xmlns:ds="http://www.w3.org/2000/09/xmldsig#">`)
+	fmt.Fprintf(&buf, `<ds:Signature Id="%s">`, id)
+	buf.Write(pending.signedInfo)
+	fmt.Fprintf(&buf, `<ds:SignatureValue>%s</ds:SignatureValue>`, base64.StdEncoding.EncodeToString(value))
+	if pending.cert != nil {
+		fmt.Fprintf(&buf, `<ds:KeyInfo><ds:X509Data><ds:X509Certificate>%s</ds:X509Certificate></ds:X509Data></ds:KeyInfo>`,
+			base64.StdEncoding.EncodeToString(pending.cert.Raw))
+	}
+	buf.WriteString(`</ds:Signature></asic:XAdESSignatures>`)
+
+	c.files = append(c.files, metaFile{
+		name:     fmt.Sprintf("META-INF/signatures%d.xml", c.signatures()),
+		contents: buf.Bytes(),
+	})
+	return nil
+}
+
+// hashcodeContainer writes c as a hashcode form ASiC-E container.
+func (c *container) hashcodeContainer() ([]byte, error) {
+	var buf bytes.Buffer
+	writer := zip.NewWriter(&buf)
+	write := func(header *zip.FileHeader, contents []byte) error {
+		w, err := writer.CreateHeader(header)
+		if err == nil {
+			_, err = w.Write(contents)
+		}
+		return errors.Wrapf(err, "write %s", header.Name)
+	}
+
+	if err := write(&zip.FileHeader{Name: "mimetype", Method: zip.Store}, []byte(asiceMimetype)); err != nil {
+		return nil, err
+	}
+	if err := write(&zip.FileHeader{Name: manifestFile, Method: zip.Deflate}, c.manifest()); err != nil {
+		return nil, err
+	}
+	if err := write(&zip.FileHeader{Name: hashcodesSHA256, Method: zip.Deflate}, c.hashcodes(false)); err != nil {
+		return nil, err
+	}
+	if err := write(&zip.FileHeader{Name: hashcodesSHA512, Method: zip.Deflate}, c.hashcodes(true)); err != nil {
+		return nil, err
+	}
+	for _, file := range c.files {
+		if err := write(&zip.FileHeader{Name: file.name, Method: zip.Deflate}, file.contents); err != nil {
+			return nil, err
+		}
+	}
+	if err := writer.Close(); err != nil {
+		return nil, errors.Wrap(err, "close zip")
+	}
+	return buf.Bytes(), nil
+}
+
+func (c *container) manifest() []byte {
+	var buf bytes.Buffer
+	buf.WriteString(`<?xml version="1.0" encoding="UTF-8" standalone="no" ?>` + "\n")
+	buf.WriteString(`<manifest:manifest xmlns:manifest="urn:oasis:names:tc:opendocument:xmlns:manifest:1.0" ` +
+		`manifest:version="1.2">` + "\n")
+	fmt.Fprintf(&buf, `<manifest:file-entry manifest:full-path="/" manifest:media-type="%s"/>`+"\n", asiceMimetype)
+	for _, datafile := range c.dataFiles {
+		mediaType := datafile.MediaType
+		if mediaType == "" {
+			mediaType = "application/octet-stream"
+		}
+		fmt.Fprintf(&buf, `<manifest:file-entry manifest:full-path="%s" manifest:media-type="%s"/>`+"\n",
+			html.EscapeString(datafile.Name), html.EscapeString(mediaType))
+	}
+	buf.WriteString(`</manifest:manifest>`)
+	return buf.Bytes()
+}
+
+// hashcodes is the structure of the hashcode files.
+type hashcodes struct {
+	XMLName     xml.Name `xml:"hashcodes"`
+	FileEntries []struct {
+		FullPath string `xml:"full-path,attr"`
+		Hash     string `xml:"hash,attr"`
+		Size     int64  `xml:"size,attr"`
+	} `xml:"file-entry"`
+}
+
+func (c *container) hashcodes(sha512 bool) []byte {
+	var buf bytes.Buffer
+	buf.WriteString(`<?xml version="1.0" encoding="UTF-8" standalone="no"?><hashcodes>`)
+	for _, datafile := range c.dataFiles {
+		hash := datafile.SHA256
+		if sha512 {
+			hash = datafile.SHA512
+		}
+		fmt.Fprintf(&buf, `<file-entry full-path="%s" hash="%s" size="%d"/>`,
+			html.EscapeString(datafile.Name), hash, datafile.Size)
+	}
+	buf.WriteString(`</hashcodes>`)
+	return buf.Bytes()
+}
+
+// parseContainer parses an uploaded hashcode form container.
+func parseContainer(data []byte) (*container, error) {
+	reader, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, validationError("invalid container: " + err.Error())
+	}
+	if len(reader.File) == 0 || reader.File[0].Name != "mimetype" {
+		return nil, validationError("invalid container: mimetype not first")
+	}
+
+	c := &container{signing: make(map[string]*pendingSignature)}
+	var sha256Entries, sha512Entries *hashcodes
+	mediaTypes := make(map[string]string)
+	for _, file := range reader.File {
+		contents, err := readFile(file)
+		if err != nil {
+			return nil, err
+		}
+
+		switch {
+		case file.Name == "mimetype":
+			if string(contents) != asiceMimetype {
+				return nil, validationError("invalid container mimetype: " + string(contents))
+			}
+		case file.Name == hashcodesSHA256:
+			if sha256Entries, err = parseHashcodes(file.Name, contents); err != nil {
+				return nil, err
+			}
+		case file.Name == hashcodesSHA512:
+			if sha512Entries, err = parseHashcodes(file.Name, contents); err != nil {
+				return nil, err
+			}
+		case file.Name == manifestFile:
+			var parsed struct {
+				FileEntries []struct {
+					FullPath  string `xml:"full-path,attr"`
+					MediaType string `xml:"media-type,attr"`
+				} `xml:"file-entry"`
+			}
+			if err := xml.Unmarshal(contents, &parsed); err != nil {
+				return nil, validationError("invalid manifest: " + err.Error())
+			}
+			for _, entry := range parsed.FileEntries {
+				mediaTypes[entry.FullPath] = entry.MediaType
+			}
+		case strings.HasPrefix(file.Name, "META-INF/"):
+			c.files = append(c.files, metaFile{name: file.Name, contents: contents})
+		default:
+			return nil, validationError("data file in hashcode container: " + file.Name)
+		}
+	}
+	if sha256Entries == nil || sha512Entries == nil {
+		return nil, validationError("invalid container: missing hashcode files")
+	}
+	if len(sha256Entries.FileEntries) != len(sha512Entries.FileEntries) {
+		return nil, validationError("invalid container: mismatching hashcode files")
+	}
+
+	for i, entry := range sha256Entries.FileEntries {
+		other := sha512Entries.FileEntries[i]
+		if other.FullPath != entry.FullPath || other.Size != entry.Size {
+			return nil, validationError("invalid container: mismatching hashcode files")
+		}
+		datafile := dataFile{
+			Name:      entry.FullPath,
+			SHA256:    entry.Hash,
+			SHA512:    other.Hash,
+			Size:      entry.Size,
+			MediaType: mediaTypes[entry.FullPath],
+		}
+		if err := datafile.validate(); err != nil {
+			return nil, err
+		}
+		c.dataFiles = append(c.dataFiles, datafile)
+	}
+	return c, nil
+}
+
+func readFile(file *zip.File) ([]byte, error) {
+	r, err := file.Open()
+	if err != nil {
+		return nil, validationError("invalid container: " + err.Error())
+	}
+	defer r.Close()
+	contents, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, validationError("invalid container: " + err.Error())
+	}
+	return contents, nil
+}
+
+func parseHashcodes(name string, contents []byte) (*hashcodes, error) {
+	var parsed hashcodes
+	if err := xml.Unmarshal(contents, &parsed); err != nil {
+		return nil, validationError("invalid " + name + ": " + err.Error())
+	}
+	return &parsed, nil
+}
+
+// parseCertificate parses a Base64-encoded DER or PEM certificate.
+func parseCertificate(encoded string) (*x509.Certificate, error) {
+	der, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, validationError("invalid signing certificate encoding")
+	}
+	if block, _ := pem.Decode(der); block != nil {
+		der = block.Bytes
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, validationError("invalid signing certificate: " + err.Error())
+	}
+	return cert, nil
+}
+
+// verifySignature verifies that value is a signature of the SHA-512 hash
+// of data made with the key of cert. ECDSA signatures can be either in the
+// XML-DSig (r || s) or ASN.1 format.
+func verifySignature(cert *x509.Certificate, data, value []byte) error {
+	hash := sha512.Sum512(data)
+	switch pub := cert.PublicKey.(type) {
+	case *rsa.PublicKey:
+		return errors.WithStack(rsa.VerifyPKCS1v15(pub, crypto.SHA512, hash[:], value))
+	case *ecdsa.PublicKey:
+		var r, s *big.Int
+		size := (pub.Curve.Params().BitSize + 7) / 8
+		if len(value) == 2*size {
+			r = new(big.Int).SetBytes(value[:size])
+			s = new(big.Int).SetBytes(value[size:])
+		} else {
+			var parsed struct{ R, S *big.Int }
+			if _, err := asn1.Unmarshal(value, &parsed); err != nil {
+				return errors.New("invalid ECDSA signature encoding")
+			}
+			r, s = parsed.R, parsed.S
+		}
+		if !ecdsa.Verify(pub, hash[:], r, s) {
+			return errors.New("invalid ECDSA signature")
+		}
+		return nil
+	default:
+		return errors.Errorf("unsupported public key algorithm: %v", cert.PublicKeyAlgorithm)
+	}
+}
@@ -0,0 +1,448 @@
+/*
+Package sigatest provides an in-process emulator of the SiGa hashcode
+container API for testing SiGa clients without access to the real service.
+
+The emulator implements the hashcode container creation, upload, download and
+deletion, remote signing, and Mobile-ID signing endpoints. It verifies the
+HMAC authorization headers of each request and produces structurally valid
+hashcode containers with test signatures. The signatures are not legally
+binding and cannot be validated as qualified signatures.
+*/
+package sigatest
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/e-gov/SiGa-Go/confutil"
+	"github.com/e-gov/SiGa-Go/https"
+	"github.com/e-gov/SiGa-Go/siga"
+)
+
+// Default credentials accepted by a Server.
+const (
+	DefaultServiceIdentifier = "a7fd7728-a3ea-4975-bfab-f240a67e894f"
+	DefaultServiceKey        = "746573745365637265744b6579303031"
+)
+
+// DefaultMaxClockSkew is the default maximum difference between the
+// authorization timestamp of a request and the clock of the Server.
+const DefaultMaxClockSkew = 5 * time.Minute
+
+// Server is a SiGa emulator listening on a system-chosen port on the local
+// loopback interface using TLS.
+type Server struct {
+	// Server is the underlying test server. Its URL is the base URL of
+	// the emulated SiGa service.
+	*httptest.Server
+
+	mu         sync.Mutex
+	keys       map[string]string // Service identifier to service key.
+	containers map[string]*container
+	skew       time.Duration
+	pollsLeft  map[string]int // Mobile-ID signature ID to remaining polls.
+
+	// MaxClockSkew is the maximum accepted difference between the
+	// authorization timestamp of a request and the clock of the server.
+	MaxClockSkew time.Duration
+
+	// MobileIDPolls is the number of status requests answered with
+	// OUTSTANDING_TRANSACTION before a Mobile-ID signing completes.
+	MobileIDPolls int
+
+	// MobileIDStatus is the final status of Mobile-ID signing sessions.
+	// If it is empty or "SIGNATURE", then signing succeeds and a test
+	// signature is added to the container.
+	MobileIDStatus string
+}
+
+// NewServer starts and returns a new Server which accepts requests
+// authorized with DefaultServiceIdentifier and DefaultServiceKey. The
+// caller should call Close when finished, to shut it down.
+func NewServer() *Server {
+	s := &Server{
+		keys:         map[string]string{DefaultServiceIdentifier: DefaultServiceKey},
+		containers:   make(map[string]*container),
+		pollsLeft:    make(map[string]int),
+		MaxClockSkew: DefaultMaxClockSkew,
+	}
+	s.Server = httptest.NewTLSServer(http.HandlerFunc(s.serveHTTP))
+	return s
+}
+
+// Conf returns a SiGa client configuration for connecting to s with the
+// default credentials.
+func (s *Server) Conf() siga.Conf {
+	parsed, err := url.Parse(s.URL)
+	if err != nil {
+		panic(err) // The test server always has a valid URL.
+	}
+	roots := x509.NewCertPool()
+	roots.AddCert(s.Certificate())
+	return siga.Conf{
+		ClientConf: https.ClientConf{
+			URL:     confutil.URL{Raw: s.URL, URL: parsed},
+			RootCAs: (*confutil.CertPool)(roots),
+		},
+		ServiceIdentifier: DefaultServiceIdentifier,
+		ServiceKey:        DefaultServiceKey,
+	}
+}
+
+// AddServiceKey adds credentials accepted by s. If identifier is already
+// known, then its key is replaced.
+func (s *Server) AddServiceKey(identifier, key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.keys[identifier] = key
+}
+
+// SetClockSkew sets the offset of the clock of s from the local clock. It
+// affects authorization timestamp checks and the Date response header.
+func (s *Server) SetClockSkew(skew time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.skew = skew
+}
+
+// Containers returns the number of containers stored in s.
+func (s *Server) Containers() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.containers)
+}
+
+func (s *Server) now() time.Time {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return time.Now().Add(s.skew)
+}
+
+// Error codes returned by the SiGa service.
+const (
+	codeAuthorization     = "AUTHORIZATION_ERROR"
+	codeRequestValidation = "REQUEST_VALIDATION_EXCEPTION"
+	codeResourceNotFound  = "RESOURCE_NOT_FOUND_EXCEPTION"
+	codeInvalidSignature  = "INVALID_SIGNATURE_EXCEPTION"
+)
+
+// sigaError is an error response of the SiGa service.
+type sigaError struct {
+	status  int
+	Code    string `json:"errorCode"`
+	Message string `json:"errorMessage"`
+}
+
+func (e *sigaError) Error() string {
+	return e.Code + ": " + e.Message
+}
+
+func validationError(message string) *sigaError {
+	return &sigaError{http.StatusBadRequest, codeRequestValidation, message}
+}
+
+func notFoundError(message string) *sigaError {
+	return &sigaError{http.StatusNotFound, codeResourceNotFound, message}
+}
+
+// serveHTTP authorizes the request, routes it to the endpoint handler and
+// writes the JSON response.
+func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Date", s.now().UTC().Format(http.TimeFormat))
+
+	var resp interface{}
+	body, err := s.authorize(r)
+	if err == nil {
+		resp, err = s.route(r.Method, strings.Split(strings.Trim(r.URL.Path, "/"), "/"), body)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err != nil {
+		sigaErr, ok := err.(*sigaError)
+		if !ok {
+			sigaErr = &sigaError{http.StatusInternalServerError, "INTERNAL_SERVER_EXCEPTION", err.Error()}
+		}
+		w.WriteHeader(sigaErr.status)
+		json.NewEncoder(w).Encode(sigaErr)
+		return
+	}
+	json.NewEncoder(w).Encode(resp)
+}
+
+// route calls the handler for the endpoint identified by the method and
+// path segments.
+func (s *Server) route(method string, path []string, body []byte) (interface{}, error) {
+	switch {
+	case method == http.MethodPost && match(path, "hashcodecontainers"):
+		return s.createContainer(body)
+	case method == http.MethodPost && match(path, "upload", "hashcodecontainers"):
+		return s.uploadContainer(body)
+	case method == http.MethodGet && match(path, "hashcodecontainers", "*"):
+		return s.getContainer(path[1])
+	case method == http.MethodDelete && match(path, "hashcodecontainers", "*"):
+		return s.deleteContainer(path[1])
+	case method == http.MethodPost && match(path, "hashcodecontainers", "*", "remotesigning"):
+		return s.startRemoteSigning(path[1], body)
+	case method == http.MethodPut && match(path, "hashcodecontainers", "*", "remotesigning", "*"):
+		return s.finalizeRemoteSigning(path[1], path[3], body)
+	case method == http.MethodPost && match(path, "hashcodecontainers", "*", "mobileidsigning"):
+		return s.startMobileIDSigning(path[1], body)
+	case method == http.MethodGet && match(path, "hashcodecontainers", "*", "mobileidsigning", "*", "status"):
+		return s.mobileIDSigningStatus(path[1], path[3])
+	default:
+		return nil, notFoundError("unknown endpoint: " + method + " /" + strings.Join(path, "/"))
+	}
+}
+
+// match reports if path matches pattern, where "*" matches any segment.
+func match(path []string, pattern ...string) bool {
+	if len(path) != len(pattern) {
+		return false
+	}
+	for i := range path {
+		if pattern[i] != "*" && pattern[i] != path[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// decode decodes the JSON request body into v. Fields which are not part of
+// the SiGa API are rejected, so that tests catch clients relying on them.
+func decode(body []byte, v interface{}) error {
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(v); err != nil {
+		return validationError("invalid request body: " + err.Error())
+	}
+	return nil
+}
+
+// newID returns a new random identifier.
+func newID() string {
+	var id [16]byte
+	rand.Read(id[:]) // Never fails.
+	return hex.EncodeToString(id[:])
+}
+
+// getLocked returns the container with the given identifier. s.mu must be
+// held.
+func (s *Server) getLocked(id string) (*container, error) {
+	c, ok := s.containers[id]
+	if !ok {
+		return nil, notFoundError("container not found: " + id)
+	}
+	return c, nil
+}
+
+func (s *Server) createContainer(body []byte) (interface{}, error) {
+	var req struct {
+		DataFiles []dataFile `json:"dataFiles"`
+	}
+	if err := decode(body, &req); err != nil {
+		return nil, err
+	}
+	if len(req.DataFiles) == 0 {
+		return nil, validationError("must contain at least one data file")
+	}
+	for _, datafile := range req.DataFiles {
+		if err := datafile.validate(); err != nil {
+			return nil, err
+		}
+	}
+
+	id := newID()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.containers[id] = &container{
+		dataFiles: req.DataFiles,
+		signing:   make(map[string]*pendingSignature),
+	}
+	return map[string]string{"containerId": id}, nil
+}
+
+func (s *Server) uploadContainer(body []byte) (interface{}, error) {
+	var req struct {
+		Container []byte `json:"container"`
+	}
+	if err := decode(body, &req); err != nil {
+		return nil, err
+	}
+	c, err := parseContainer(req.Container)
+	if err != nil {
+		return nil, err
+	}
+
+	id := newID()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.containers[id] = c
+	return map[string]string{"containerId": id}, nil
+}
+
+func (s *Server) getContainer(id string) (interface{}, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c, err := s.getLocked(id)
+	if err != nil {
+		return nil, err
+	}
+	data, err := c.hashcodeContainer()
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{"containerId": id, "container": data}, nil
+}
+
+func (s *Server) deleteContainer(id string) (interface{}, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, err := s.getLocked(id); err != nil {
+		return nil, err
+	}
+	delete(s.containers, id)
+	return map[string]string{"result": "OK"}, nil
+}
+
+func (s *Server) startRemoteSigning(id string, body []byte) (interface{}, error) {
+	var req struct {
+		SigningCertificate string `json:"signingCertificate"`
+		SignatureProfile   string `json:"signatureProfile"`
+	}
+	if err := decode(body, &req); err != nil {
+		return nil, err
+	}
+	cert, err := parseCertificate(req.SigningCertificate)
+	if err != nil {
+		return nil, err
+	}
+	if err := validateProfile(req.SignatureProfile); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c, err := s.getLocked(id)
+	if err != nil {
+		return nil, err
+	}
+	signatureID, pending := c.startSigning(cert)
+	return map[string]interface{}{
+		"dataToSign":           pending.signedInfo,
+		"digestAlgorithm":      "SHA512",
+		"generatedSignatureId": signatureID,
+	}, nil
+}
+
+func (s *Server) finalizeRemoteSigning(id, signatureID string, body []byte) (interface{}, error) {
+	var req struct {
+		SignatureValue []byte `json:"signatureValue"`
+	}
+	if err := decode(body, &req); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c, err := s.getLocked(id)
+	if err != nil {
+		return nil, err
+	}
+	pending, ok := c.signing[signatureID]
+	if !ok || pending.cert == nil {
+		return nil, notFoundError("remote signing not found: " + signatureID)
+	}
+	if err := verifySignature(pending.cert, pending.signedInfo, req.SignatureValue); err != nil {
+		return nil, &sigaError{http.StatusBadRequest, codeInvalidSignature, err.Error()}
+	}
+	if err := c.finishSigning(signatureID, req.SignatureValue); err != nil {
+		return nil, err
+	}
+	return map[string]string{"result": "OK"}, nil
+}
+
+func (s *Server) startMobileIDSigning(id string, body []byte) (interface{}, error) {
+	var req struct {
+		PersonIdentifier string `json:"personIdentifier"`
+		PhoneNo          string `json:"phoneNo"`
+		Language         string `json:"language"`
+		SignatureProfile string `json:"signatureProfile"`
+		MessageToDisplay string `json:"messageToDisplay"`
+	}
+	if err := decode(body, &req); err != nil {
+		return nil, err
+	}
+	switch {
+	case len(req.PersonIdentifier) != 11:
+		return nil, validationError("invalid person identifier")
+	case !strings.HasPrefix(req.PhoneNo, "+"):
+		return nil, validationError("invalid international calling code")
+	case req.Language == "":
+		return nil, validationError("invalid language")
+	}
+	if err := validateProfile(req.SignatureProfile); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c, err := s.getLocked(id)
+	if err != nil {
+		return nil, err
+	}
+	signatureID, _ := c.startSigning(nil)
+	s.pollsLeft[signatureID] = s.MobileIDPolls
+	return map[string]string{
+		"challengeId":          "0000",
+		"generatedSignatureId": signatureID,
+	}, nil
+}
+
+func (s *Server) mobileIDSigningStatus(id, signatureID string) (interface{}, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c, err := s.getLocked(id)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := c.signing[signatureID]; !ok {
+		return nil, notFoundError("Mobile-ID signing not found: " + signatureID)
+	}
+
+	status := "OUTSTANDING_TRANSACTION"
+	if s.pollsLeft[signatureID] > 0 {
+		s.pollsLeft[signatureID]--
+	} else {
+		delete(s.pollsLeft, signatureID)
+		status = s.MobileIDStatus
+		if status == "" || status == "SIGNATURE" {
+			status = "SIGNATURE"
+			var value [64]byte
+			rand.Read(value[:]) // Never fails.
+			if err := c.finishSigning(signatureID, value[:]); err != nil {
+				return nil, err
+			}
+		} else {
+			delete(c.signing, signatureID)
+		}
+	}
+	return map[string]string{"midStatus": status}, nil
+}
+
+func validateProfile(profile string) error {
+	switch profile {
+	case "LT", "LT_TM", "LTA":
+		return nil
+	default:
+		return validationError("invalid signature profile: " + profile)
+	}
+}
@@ -1,90 +1,109 @@
 package main
 
 import (
-	"context"
-	"encoding/json"
+	"crypto/x509"
 	"encoding/base64"
-	"io/ioutil"
 	"log"
 	"net/http"
-	"os"
-	"strings"
-
-	"github.com/e-gov/SiGa-Go/siga"
+	"net/url"
 )
 
-// p1Handler võtab vastu sirvikust saadetud allkirjastatava teksti ja serdi
-// ning moodustab (SiGa poole pöördumisega) konteineri. 
-func p1Handler(w http.ResponseWriter, req *http.Request) {
+// p1Request on ID-kaardiga allkirjastamise alustamise päringu keha.
+type p1Request struct {
+	Tekst     string `json:"tekst"`
+	Konteiner bool   `json:"konteiner"`
+	Sert      string `json:"sert"`
 
-	w.Header().Set("Content-Type", "application/json")
+	cert *x509.Certificate
+}
 
-	// Vastuvõetava päringu keha struktuur.
-	type req_struct struct {
-		Tekst string
-		Sert string
-	}
+func (r *p1Request) text() string { return r.Tekst }
 
-	log.Println("p1Handler: Alustan päringu töötlemist")
+func (r *p1Request) existing() bool { return r.Konteiner }
 
-	// Loe päringu keha sisse.
-	body, err := ioutil.ReadAll(req.Body)
-	if err != nil {
-		log.Fatal("p1Handler: Päringu keha lugemine ebaõnnestus: ", err)
+func (r *p1Request) setForm(form url.Values) { r.Sert = form.Get("sert") }
+
+func (r *p1Request) validate() error {
+	var err error
+	r.cert, err = parseSignerCertificate(r.Sert)
+	return err
+}
+
+// p2Request on ID-kaardiga allkirjastamise lõpetamise päringu keha.
+type p2Request struct {
+	// Allkiri on Base64-kujul allkirjaväärtus.
+	Allkiri string `json:"allkiri"`
+
+	signature []byte
+}
+
+func (r *p2Request) validate() error {
+	var err error
+	r.signature, err = base64.StdEncoding.DecodeString(r.Allkiri)
+	if err != nil || len(r.signature) == 0 {
+		return newAPIError(http.StatusBadRequest, codeInvalidSignature).withCause(err)
 	}
-	// log.Println("p1Handler: Päringu keha: ", string(body))
+	return nil
+}
+
+// p1Handler võtab vastu sirvikust saadetud allkirjastatava teksti või failid
+// ja serdi ning moodustab (SiGa poole pöördumisega) konteineri. Failid
+// saadetakse multipart/form-data vormina (vt readUpload), ainult tekst ka
+// JSON-na. Üles laaditud konteinerile allkirja lisamisel laaditakse SiGa-sse
+// see konteiner (vt prepareContainer).
+func p1Handler(w http.ResponseWriter, req *http.Request) {
 
-	// Parsi JSON.
-	var t req_struct
-	err = json.Unmarshal(body, &t)
+	log.Println("p1Handler: Alustan päringu töötlemist")
+
+	// Loe ja kontrolli päring, moodusta allkirjakonteinerisse pandavad
+	// failid koos metaandmetega.
+	var t p1Request
+	datafiles, err := readSignRequest(w, req, &t)
 	if err != nil {
-		log.Fatal("p1Handler: Päringu keha parsimine ebaõnnestus: ", err)
+		writeError(w, req, "p1Handler", err)
+		return
 	}
+	// Sert on readSignRequest-is juba kontrollitud ja parsitud.
 	log.Println("p1Handler: Saadud sirvikupoolelt:")
-	log.Println("    allkirjastatav tekst: ", t.Tekst)
-	log.Println("    sert: ", t.Sert[:40] + "...")
+	log.Println("    allkirjastatavaid faile: ", len(datafiles))
+	log.Println("    sert: ", t.cert.Subject.CommonName)
 
-	// Tühi tekst?
-	if len(t.Tekst) == 0 {
-		log.Println("p1Handler: Tühja teksti ei saa allkirjastada")
-		return
-	}
+	ctx := req.Context()
+	sess := sessionFromContext(ctx)
+	session := sess.sigaSession("idcard")
 
-	// Moodusta DataFile (allkirjakonteinerisse pandav fail koos metaandmetega)
-	datafile, err := siga.NewDataFile("fail.txt", strings.NewReader(t.Tekst))
-	if err != nil {
-		log.Println("p1Handler: Allkirjakonteinerisse pandava fail moodustamine ebaõnnestus")
+	// Uus konteiner asendab seansi eelmise allkirjastatud konteineri.
+	if err := sessions.beginSigning(sess, "idcard"); err != nil {
+		writeError(w, req, "p1Handler", err)
 		return
 	}
-	log.Println("p1Handler: Allkirjakonteinerisse pandav fail moodustatud")
-
-	ctx := context.Background()
 
 	// Koosta konteiner, pöördumisega SiGa poole.
-	if err = sigaClient.CreateContainer(ctx, isession, datafile); err != nil {
-		log.Fatal("p1Handler: ", err)
+	if err := prepareContainer(ctx, sess, "idcard", datafiles); err != nil {
+		sess.setState("idcard", signingNone)
+		writeError(w, req, "p1Handler", err)
+		return
 	}
 	log.Println("p1Handler: Konteiner SiGa-s loodud")
 
-	// Saada sertifikaat SiGa-le.
-	hash, algo, err := sigaClient.StartRemoteSigning(ctx, isession, []byte(t.Sert))
+	// Saada sertifikaat SiGa-le DER-kujul.
+	hash, algo, err := sigaClient.StartRemoteSigning(ctx, session, t.cert.Raw)
 	if err != nil {
-		log.Println("Example_IDCardSigning: StartRemoteSigning: ", err)
-		// TODO Veakäsitlus lõpuni
+		sess.setState("idcard", signingNone)
+		writeError(w, req, "p1Handler", sigaError(err))
+		return
 	}
 	log.Println("p1Handler: Sert SiGa-le saadetud")
-	log.Println("p1Handler: Saadud SiGa-lt räsi: ", string(hash))
 	log.Println("p1Handler: Saadud SiGa-lt algo: ", algo)
 
 	// Saada räsi ja algoritm sirvikupoolele
 	var resp struct {
-		Hash      []byte `json:"hash"`
+		Hash []byte `json:"hash"`
 		Algo string `json:"algo"`
 	}
 	resp.Hash = hash
 	resp.Algo = algo
-
-	json.NewEncoder(w).Encode(resp)
+	writeJSON(w, http.StatusOK, resp)
 
 	log.Println("p1Handler: Päringu vastus saadetud sirvikusse")
 }
@@ -93,85 +112,44 @@ func p1Handler(w http.ResponseWriter, req *http.Request) {
 // lõpule.
 func p2Handler(w http.ResponseWriter, req *http.Request) {
 
-	w.Header().Set("Content-Type", "application/json")
-
-	// Vastuvõetava päringu keha struktuur.
-	type req_struct struct {
-		Allkiri string
-	}
-
-	// Vastuse struktuur
-	var resp struct {
-		Error      string `json:"error"`
-		SignedFile  string `json:"signedfile"`
-	}
-
-	log.Println("Alustan P2 töötlemist")
+	log.Println("p2Handler: Alustan päringu töötlemist")
 
-	// Loe päringu keha sisse.
-	body, err := ioutil.ReadAll(req.Body)
-	if err != nil {
-		log.Fatal("p2Handler: Päringu keha lugemine ebaõnnestus: ", err)
+	// Loe ja kontrolli päring; allkirjaväärtus dekodeeritakse Base64-st.
+	var t p2Request
+	err := requireMethod(w, req, http.MethodPost)
+	if err == nil {
+		err = decodeJSON(w, req, &t)
 	}
-	log.Println("p2Handler: Päringu keha: ", string(body))
-
-	// Parsi JSON.
-	var t req_struct
-	err = json.Unmarshal(body, &t)
 	if err != nil {
-		log.Fatal("p2Handler: Päringu keha parsimine ebaõnnestus: ", err)
+		writeError(w, req, "p2Handler", err)
+		return
 	}
-	// log.Println("p2Handler: Allkiri (Base64): ", t.Allkiri)
 
-	// Dekodeeri Base64 -> []byte
-	a, err := base64.StdEncoding.DecodeString(t.Allkiri)
-	if err != nil {
-		log.Println("p2Handler: Allkirja dekodeerimine ebaõnnestus: ", err)
+	ctx := req.Context()
+	sess := sessionFromContext(ctx)
+	if sess.state("idcard") != signingPending {
+		writeError(w, req, "p2Handler", newAPIError(http.StatusConflict, codeNotStarted))
+		return
 	}
-	// log.Printf("Allkiri: %v", a)
 
 	// FinalizeRemoteSigning()
-	ctx := context.Background()
-	err = sigaClient.FinalizeRemoteSigning(ctx, isession, a)
-	if err != nil {
-		log.Println("p2Handler: FinalizeRemoteSigning: ", err)
-		// Saada veateade sirvikupoolele
-		resp.Error = err.Error()
-		json.NewEncoder(w).Encode(resp)
-		log.Println("p2Handler: Päringu vastus saadetud sirvikusse")
+	if err := sigaClient.FinalizeRemoteSigning(ctx, sess.sigaSession("idcard"), t.signature); err != nil {
+		sess.setState("idcard", signingNone)
+		writeError(w, req, "p2Handler", sigaError(err))
 		return
 	}
 	log.Println("p2Handler: FinalizeRemoteSigning: edukas")
 
-	// TODO: Lae räsikonteiner SiGa-st alla
-	// Ava fail kirjutamiseks.
-	f, err := os.Create("allkirjad/proov.asice")
-	defer f.Close()
-	if err != nil {
-		log.Println("p2Handler: Faili ei saa avada: ", err)
-		// Saada veateade sirvikupoolele
-		resp.Error = err.Error()
-		json.NewEncoder(w).Encode(resp)
-		log.Println("p2Handler: Päringu vastus saadetud sirvikusse")
-		return
-	}
+	// Allkirjastatud konteiner jääb SiGa seansi, kust kasutaja saab selle
+	// alla laadida (vt downloadHandler), ja salvestatakse arhiivi.
+	sess.setState("idcard", signingSigned)
 
-	err = sigaClient.WriteContainer(ctx, isession, f)
-	if err != nil {
-		log.Println("p2Handler: WriteContainer: ", err)
-		// Saada veateade sirvikupoolele
-		resp.Error = err.Error()
-		json.NewEncoder(w).Encode(resp)
-		log.Println("p2Handler: Päringu vastus saadetud sirvikusse")
-		return
+	var resp struct {
+		SignedFile string `json:"signedfile"`
+		Archived   string `json:"archived,omitempty"`
 	}
-
-	// siga.WriteContainer
-	// Salvesta faili sisaldav konteiner kettale (või saada sirvikupoolele
-	// kasutajale allalaadimiseks)
-	// Saada sirvikupoolele teade edu kohta.
-
-	json.NewEncoder(w).Encode(resp)
+	resp.SignedFile = downloadURL("idcard")
+	resp.Archived = archiveSigned(ctx, sess, "idcard")
+	writeJSON(w, http.StatusOK, resp)
 	log.Println("p2Handler: Päringu vastus saadetud sirvikusse")
 }
-
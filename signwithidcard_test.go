@@ -0,0 +1,65 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// testCertificate tagastab DER-kujul isesigneeritud serdi nimega cn.
+func testCertificate(t *testing.T, cn string) []byte {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return der
+}
+
+func TestP1Handler_PEMCertificate_DERSentToSiGa(t *testing.T) {
+	// given
+	client := &fakeClient{}
+	defer withSigaClient(client)()
+	store := newSessionStore(time.Minute, nil)
+	sess, _ := store.get("")
+	der := testCertificate(t, "MÄNNIK,MARI-LIIS,61709210125")
+	body, _ := json.Marshal(p1Request{
+		Tekst: "Tere",
+		Sert:  string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})),
+	})
+	req := httptest.NewRequest(http.MethodPost, "/p1", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+
+	// when
+	rec := sessionRequest(store, sess, http.HandlerFunc(p1Handler), req)
+
+	// then
+	if rec.Code != http.StatusOK {
+		t.Fatalf("unexpected status: %d %s", rec.Code, rec.Body)
+	}
+	if !bytes.Equal(client.cert, der) {
+		t.Error("certificate not sent to SiGa in DER form")
+	}
+	if sess.state("idcard") != signingPending {
+		t.Error("signing not pending")
+	}
+}
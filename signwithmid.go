@@ -1,141 +1,195 @@
 package main
 
 import (
-	"context"
-	"encoding/json"
-	"io/ioutil"
 	"log"
 	"net/http"
-	"os"
+	"net/url"
+	"regexp"
 	"strings"
-	"time"
-
-	"github.com/e-gov/SiGa-Go/siga"
 )
 
-// Example_MobileIDSigning teeb m-ID-ga näidisallkirjastamise.
-// Voog:
-// 1) moodustab Riigi allkirjastamisteenuse (SiGa) poole pöördumise
-// HTTPS kliendi (CreateSIGAClient)
-// 2) alustab SiGa-ga seanssi (session)
-// 3) valib allkirjastatava faili (allkirjad/example_datafile.txt)
-// 4) koostab konteineri (CreateContainer)
-// 5) teeb m-ID-ga allkirjastamise alustamise päringu
-// (StartMobileIDSigning). SiGa demo vahendab m-ID allkirjastamise testteenust.
-// 6) teeb m-ID-ga allkirjastamise seisundipäringud
-// (RequestMobileIDSigningStatus)
-// 7) salvestab konteineri (WriteContainer), faili
-// allkirjad/mobile-id.asice
-// 8) kustutab konteineri SiGa-st
-// 9) suleb HTTPS kliendi (Close).
-func midHandler(w http.ResponseWriter, req *http.Request) {
+// midMessage on m-ID allkirjastamisel kasutaja telefonis kuvatav tekst.
+const midMessage = "SiGa-Go"
 
-	w.Header().Set("Content-Type", "application/json")
+// m-ID olekupäringu vastuse olekud.
+const (
+	midOutstanding = "OUTSTANDING"
+	midSigned      = "SIGNED"
+	midFailed      = "FAILED"
+)
 
-	// Vastuvõetava päringu keha struktuur.
-	type req_struct struct {
-		Isikukood string `json:"isikukood"`
-		Nr string `json:"nr"`
-		Tekst string `json:"tekst"`
-	}
+var (
+	// isikukoodMuster vastab Eesti isikukoodile.
+	isikukoodMuster = regexp.MustCompile(`^[1-6][0-9]{10}$`)
+	// mobiilinumbriMuster vastab rahvusvahelisele telefoninumbrile.
+	mobiilinumbriMuster = regexp.MustCompile(`^\+[0-9]{7,15}$`)
+)
 
-	// Vastuse struktuur
-	var resp struct {
-		Error      string `json:"error"`
-		SignedFile  string `json:"signedfile"`
+// normalizePhone eemaldab telefoninumbrist tühikud ja lisab vajadusel
+// rahvusvahelise eesliite "+".
+func normalizePhone(nr string) string {
+	nr = strings.Replace(nr, " ", "", -1)
+	if nr != "" && !strings.HasPrefix(nr, "+") {
+		nr = "+" + nr
 	}
+	return nr
+}
 
-	log.Println("midHandler: Alustan päringu töötlemist")
+// midStartRequest on m-ID-ga allkirjastamise alustamise päringu keha.
+type midStartRequest struct {
+	Isikukood string `json:"isikukood"`
+	Nr        string `json:"nr"`
+	Tekst     string `json:"tekst"`
+	Konteiner bool   `json:"konteiner"`
+}
 
-	// Loe päringu keha sisse.
-	body, err := ioutil.ReadAll(req.Body)
-	if err != nil {
-		log.Fatal("midHandler: Päringu keha lugemine ebaõnnestus: ", err)
-	}
-	// log.Println("midHandler: Päringu keha: ", string(body))
+func (r *midStartRequest) text() string { return r.Tekst }
 
-	// Parsi JSON.
-	var t req_struct
-	err = json.Unmarshal(body, &t)
-	if err != nil {
-		log.Fatal("midHandler: Päringu keha parsimine ebaõnnestus: ", err)
+func (r *midStartRequest) existing() bool { return r.Konteiner }
+
+func (r *midStartRequest) setForm(form url.Values) {
+	r.Isikukood = form.Get("isikukood")
+	r.Nr = form.Get("nr")
+}
+
+// validate kontrollib allkirjastaja isikutunnuseid ja viib need
+// SiGa-le saadetavale kujule.
+func (r *midStartRequest) validate() error {
+	r.Isikukood = strings.TrimSpace(r.Isikukood)
+	r.Nr = normalizePhone(r.Nr)
+	if !isikukoodMuster.MatchString(r.Isikukood) {
+		return newAPIError(http.StatusBadRequest, codeInvalidPersonalCode)
+	}
+	if !mobiilinumbriMuster.MatchString(r.Nr) {
+		return newAPIError(http.StatusBadRequest, codeInvalidPhone)
 	}
-	log.Println("midHandler: Isikukood: ", t.Isikukood)
-	log.Println("midHandler: Mobiilinumber: ", t.Nr)
-	log.Println("midHandler: Allkirjastatav tekst: ", t.Tekst)
+	return nil
+}
+
+// midHandler alustab m-ID-ga allkirjastamist ega jää allkirjastamise lõppu
+// ootama.
+// Voog:
+// 1) võtab sirvikust vastu allkirjastaja isikukoodi ja mobiilinumbri
+// 2) moodustab allkirjastatavad failid sirvikust saadetud tekstist või
+// üles laaditud failidest (vt readSignRequest)
+// 3) koostab konteineri sirvikuseansi SiGa seansis (CreateContainer) või
+// laadib sinna sirvikuseansis üles laaditud konteineri (UploadContainer), vt
+// prepareContainer
+// 4) teeb m-ID-ga allkirjastamise alustamise päringu
+// (StartMobileIDSigning). SiGa demo vahendab m-ID allkirjastamise testteenust.
+// 5) saadab sirvikule kontrollkoodi, mida kasutaja võrdleb telefonis
+// kuvatuga.
+// Allkirjastamise olekut pärib sirvik seejärel midStatusHandler-ilt.
+func midHandler(w http.ResponseWriter, req *http.Request) {
 
-	ctx := context.Background()
+	log.Println("midHandler: Alustan päringu töötlemist")
 
-	// Loe sisse andmefail.
-	datafile, err := siga.NewDataFile("fail.txt", strings.NewReader(t.Tekst))
+	// Loe ja kontrolli päring, moodusta allkirjakonteinerisse pandavad
+	// failid koos metaandmetega.
+	var t midStartRequest
+	datafiles, err := readSignRequest(w, req, &t)
 	if err != nil {
-		log.Println("midHandler: Viga faili moodustamisel: ", err)
+		writeError(w, req, "midHandler", err)
+		return
 	}
+	log.Println("midHandler: Allkirjastatavaid faile: ", len(datafiles))
 
-	// Määra allkirjastaja isikutunnused.
-	const person = "60001019906"
-	const phone = "+37200000766"
-	const message = "Automated testing"
+	ctx := req.Context()
+	sess := sessionFromContext(ctx)
+	session := sess.sigaSession("mid")
 
-	// Alusta väljundfail (allkirjastatud fail).
-	output, err := os.Create("allkirjad/mobile-id.asice")
-	if err != nil {
-		log.Println("midHandler: ", err)
-		// Saada veateade sirvikupoolele.
-		resp.Error = err.Error()
-		json.NewEncoder(w).Encode(resp)
+	// Uus konteiner asendab seansi eelmise allkirjastatud konteineri.
+	// Allkirjastamine on pooleli juba konteineri koostamise ajal, et töö
+	// lõpetamisel seda konteinerit ei suletaks (vt drain).
+	if err := sessions.beginSigning(sess, "mid"); err != nil {
+		writeError(w, req, "midHandler", err)
 		return
 	}
-	defer output.Close()
 
 	// Koosta konteiner, pöördumisega SiGa poole.
-	if err = sigaClient.CreateContainer(ctx, msession, datafile); err != nil {
-		log.Println("midHandler: ", err)
-		// Saada veateade sirvikupoolele.
-		resp.Error = err.Error()
-		json.NewEncoder(w).Encode(resp)
+	if err := prepareContainer(ctx, sess, "mid", datafiles); err != nil {
+		sess.setState("mid", signingNone)
+		writeError(w, req, "midHandler", err)
 		return
 	}
-	// Ajata konteineri sulgemine.
-	defer sigaClient.CloseContainer(ctx, msession)
 
 	// Alusta m-ID allkirjastamissuhtlust SiGa-ga (alustuspäringu saatmine).
-	if _, err = sigaClient.StartMobileIDSigning(ctx, msession, person, phone, message); err != nil {
-		log.Println("midHandler: ", err)
+	challenge, err := sigaClient.StartMobileIDSigning(ctx, session, t.Isikukood, t.Nr, midMessage)
+	if err != nil {
+		sess.setState("mid", signingNone)
+		writeError(w, req, "midHandler", sigaError(err))
+		return
 	}
 
-	// Olekupäringute ajaintervall (5 s).
-	ticker := time.NewTicker(5 * time.Second)
-	defer ticker.Stop()
-	for range ticker.C {
-		// Tee m-ID allkirjastamise olekupäring.
-		done, err := sigaClient.RequestMobileIDSigningStatus(ctx, msession)
-		if err != nil {
-			log.Println("midHandler: ", err)
-			// Saada veateade sirvikupoolele.
-			resp.Error = err.Error()
-			json.NewEncoder(w).Encode(resp)
-			return
-		}
-		if done {
-			break
-		}
+	// Saada kontrollkood sirvikupoolele.
+	var resp struct {
+		Challenge string `json:"challenge"`
+		Status    string `json:"status"`
 	}
+	resp.Challenge = challenge
+	resp.Status = midOutstanding
+	writeJSON(w, http.StatusOK, resp)
+
+	log.Println("midHandler: m-ID allkirjastamine alustatud, kontrollkood: ", challenge)
+}
 
-	// Päri allkirja sisaldav konteiner SiGa-st ja lisa sinna andmefailid.
-	if err = sigaClient.WriteContainer(ctx, msession, output); err != nil {
-		log.Println("midHandler: ", err)
-		// Saada veateade sirvikupoolele.
-		resp.Error = err.Error()
-		json.NewEncoder(w).Encode(resp)
+// midStatusHandler teeb sirvikuseansis alustatud m-ID allkirjastamise kohta
+// ühe olekupäringu SiGa poole ja saadab sirvikule oleku: OUTSTANDING
+// (kasutaja ei ole veel allkirjastanud), SIGNED (konteiner on allkirjastatud
+// ja allalaaditav) või FAILED (allkirjastamine ebaõnnestus, vastuses on ka
+// viga).
+func midStatusHandler(w http.ResponseWriter, req *http.Request) {
+
+	w.Header().Set("Cache-Control", "no-store")
+	if err := requireMethod(w, req, http.MethodGet); err != nil {
+		writeError(w, req, "midStatusHandler", err)
 		return
 	}
 
-	// Saada edukas vastus sirvikupoolele.
-	json.NewEncoder(w).Encode(resp)
+	// Vastuse struktuur
+	var resp struct {
+		Status     string     `json:"status"`
+		SignedFile string     `json:"signedfile,omitempty"`
+		Archived   string     `json:"archived,omitempty"`
+		Error      *errorBody `json:"error,omitempty"`
+	}
+
+	ctx := req.Context()
+	sess := sessionFromContext(ctx)
 
-	log.Println("midHandler: Allkiri moodustatud")
+	// Sama seansi olekupäringud tehakse SiGa poole järjest.
+	sess.poll.Lock()
+	defer sess.poll.Unlock()
 
-	// The file written to allkirjad/mobile-id.asice should be externally
-	// validated using e.g. DigiDoc4 Client.
+	switch sess.state("mid") {
+	case signingSigned:
+		resp.Status = midSigned
+		resp.SignedFile = downloadURL("mid")
+		writeJSON(w, http.StatusOK, resp)
+		return
+	case signingNone:
+		writeError(w, req, "midStatusHandler", newAPIError(http.StatusNotFound, codeNotStarted))
+		return
+	}
+
+	// Tee m-ID allkirjastamise olekupäring.
+	done, err := sigaClient.RequestMobileIDSigningStatus(ctx, sess.sigaSession("mid"))
+	switch {
+	case err != nil:
+		log.Println("midStatusHandler: ", err)
+		sess.setState("mid", signingNone)
+		resp.Status = midFailed
+		resp.Error = localize(req, newAPIError(http.StatusOK, codeSigningFailed).withCause(err))
+	case done:
+		// Allkirja sisaldava konteineri saab nüüd alla laadida; see
+		// salvestatakse ka arhiivi.
+		sess.setState("mid", signingSigned)
+		resp.Status = midSigned
+		resp.SignedFile = downloadURL("mid")
+		resp.Archived = archiveSigned(ctx, sess, "mid")
+		log.Println("midStatusHandler: Allkiri moodustatud")
+	default:
+		resp.Status = midOutstanding
+	}
+	writeJSON(w, http.StatusOK, resp)
 }
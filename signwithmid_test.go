@@ -0,0 +1,95 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// midResponse on m-ID päringute vastuste ühendatud kuju.
+type midResponse struct {
+	Challenge  string     `json:"challenge"`
+	Status     string     `json:"status"`
+	SignedFile string     `json:"signedfile"`
+	Error      *errorBody `json:"error"`
+}
+
+// midRequest teeb m-ID päringu seansi sess nimel ja dekodeerib vastuse.
+func midRequest(t *testing.T, store *sessionStore, sess *session, h http.HandlerFunc,
+	method, body string) (int, midResponse) {
+
+	req := httptest.NewRequest(method, "/mid", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	rec := sessionRequest(store, sess, h, req)
+	var resp midResponse
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	return rec.Code, resp
+}
+
+func TestMidHandler_UserValues_ChallengeAndStatus(t *testing.T) {
+	// given
+	client := &fakeClient{polls: 2}
+	defer withSigaClient(client)()
+	store := newSessionStore(time.Minute, nil)
+	sess, _ := store.get("")
+
+	// when
+	code, start := midRequest(t, store, sess, midHandler, http.MethodPost,
+		`{"isikukood": "60001019906", "nr": "372 0000 0766", "tekst": "Tere"}`)
+	_, first := midRequest(t, store, sess, midStatusHandler, http.MethodGet, "")
+	_, second := midRequest(t, store, sess, midStatusHandler, http.MethodGet, "")
+
+	// then
+	if code != http.StatusOK || start.Challenge != "1234" {
+		t.Fatalf("unexpected start response: %d %v", code, start)
+	}
+	if client.person != "60001019906" || client.phone != "+37200000766" {
+		t.Errorf("unexpected signer: %s %s", client.person, client.phone)
+	}
+	if first.Status != midOutstanding {
+		t.Errorf("unexpected first status: %v", first)
+	}
+	if second.Status != midSigned || second.SignedFile != downloadURL("mid") {
+		t.Errorf("unexpected second status: %v", second)
+	}
+	if sess.state("mid") != signingSigned {
+		t.Error("session container not marked signed")
+	}
+}
+
+func TestMidHandler_InvalidIsikukood_BadRequest(t *testing.T) {
+	// given
+	defer withSigaClient(&fakeClient{})()
+	store := newSessionStore(time.Minute, nil)
+	sess, _ := store.get("")
+
+	// when
+	code, resp := midRequest(t, store, sess, midHandler, http.MethodPost,
+		`{"isikukood": "123", "nr": "+37200000766", "tekst": "Tere"}`)
+
+	// then
+	if code != http.StatusBadRequest || resp.Error == nil ||
+		resp.Error.Code != codeInvalidPersonalCode {
+		t.Errorf("unexpected response: %d %v", code, resp)
+	}
+}
+
+func TestMidStatusHandler_NotStarted_NotFound(t *testing.T) {
+	// given
+	defer withSigaClient(&fakeClient{})()
+	store := newSessionStore(time.Minute, nil)
+	sess, _ := store.get("")
+
+	// when
+	code, resp := midRequest(t, store, sess, midStatusHandler, http.MethodGet, "")
+
+	// then
+	if code != http.StatusNotFound || resp.Error == nil || resp.Error.Code != codeNotStarted {
+		t.Errorf("unexpected response: %d %v", code, resp)
+	}
+}
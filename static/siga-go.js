@@ -5,6 +5,7 @@ function init() {
 
   seaTeabepaanideKasitlejad();
   seaNupukasitlejad();
+  seaKonteineriKasitleja();
 
 }
 
@@ -28,6 +29,137 @@ function seaTeabepaanideKasitlejad() {
 // XXX: Õige viis serdi edasiandmiseks
 var certToUse;
 
+// juhuslikHex tagastab n juhuslikust baidist koosneva kuueteistkümnendsõne.
+function juhuslikHex(n) {
+  var baidid = new Uint8Array(n);
+  window.crypto.getRandomValues(baidid);
+  return Array.from(baidid, (b) => b.toString(16).padStart(2, '0')).join('');
+}
+
+// uusJalg tagastab uue jälje ID. Ühe allkirjastamise kõik päringud
+// serveripoolele kuuluvad samasse jälge.
+function uusJalg() {
+  return juhuslikHex(16);
+}
+
+// traceparent moodustab W3C Trace Context päise väärtuse, millega server
+// seob oma pöördumised SiGa poole sirvikupoolse jäljega.
+function traceparent(jalg) {
+  return '00-' + jalg + '-' + juhuslikHex(8) + '-01';
+}
+
+// paringuKeha moodustab allkirjastamise alustamise päringu keha ja päised.
+// Kui kasutaja on valinud failid, siis saadetakse need koos teksti ja
+// väljadega multipart vormina (FormData), muidu JSON-na. Kui kasutaja on
+// üles laadinud konteineri, siis lisatakse allkiri sellele.
+function paringuKeha(valjad, jalg) {
+  var tekst = document.getElementById("Tekstisisestusala").innerText;
+  var failid = document.getElementById("Failid").files;
+  var paised = { 'traceparent': traceparent(jalg) };
+  if (konteinerValitud) {
+    valjad.konteiner = true;
+    paised['content-type'] = 'application/json';
+    return { headers: paised, body: JSON.stringify(valjad) };
+  }
+  if (failid.length == 0) {
+    valjad.tekst = tekst;
+    paised['content-type'] = 'application/json';
+    return { headers: paised, body: JSON.stringify(valjad) };
+  }
+  // FormData korral seab sirvik content-type päise (koos piiriga) ise.
+  var vorm = new FormData();
+  Object.keys(valjad).forEach((k) => vorm.append(k, valjad[k]));
+  if (tekst.trim().length > 0) {
+    vorm.append('tekst', tekst);
+  }
+  Array.from(failid).forEach((f) => vorm.append('failid', f, f.name));
+  return { headers: paised, body: vorm };
+}
+
+// allkirjastatavOlemas teatab, kas kasutaja on sisestanud teksti, valinud
+// faili või üles laadinud konteineri.
+function allkirjastatavOlemas() {
+  return document.getElementById("Tekstisisestusala").innerText.length > 0 ||
+    document.getElementById("Failid").files.length > 0 || konteinerValitud;
+}
+
+// konteinerValitud: kasutaja on üles laadinud olemasoleva konteineri, millele
+// allkiri lisatakse.
+var konteinerValitud = false;
+
+// seaKonteineriKasitleja määrab konteineri valimise käitumise: valitud
+// konteiner laaditakse serveripoolele üles ja selle sisu kuvatakse.
+function seaKonteineriKasitleja() {
+  $('#Konteiner').change(() => {
+    var konteiner = document.getElementById("Konteiner").files[0];
+    kuvaKonteiner(null);
+    if (!konteiner) {
+      // Valik tühistati, eemalda konteiner ka serveripoolelt.
+      fetch('/container', { method: 'DELETE' });
+      return;
+    }
+    var vorm = new FormData();
+    vorm.append('konteiner', konteiner, konteiner.name);
+    fetch('/container', {
+      method: 'POST',
+      body: vorm
+    })
+      .then(loeVastus)
+      .then(kuvaKonteiner)
+      .catch(err => {
+        console.log("Viga konteineri üleslaadimisel: ", err)
+        document.getElementById("Konteiner").value = '';
+        kuvaTeade(err.message, true)
+      })
+  });
+}
+
+// kuvaKonteiner kuvab üles laaditud konteineri andmefailid ja allkirjastajad.
+// null väärtuse korral peidetakse konteineri sisu.
+function kuvaKonteiner(sisu) {
+  konteinerValitud = sisu !== null;
+  if (!sisu) {
+    $('#KonteineriSisu').addClass('peidetud');
+    return;
+  }
+  $('#KonteineriFailid').empty();
+  sisu.dataFiles.forEach((nimi) => {
+    $('#KonteineriFailid').append($('<li>').text(nimi));
+  });
+  $('#KonteineriAllkirjastajad').empty();
+  if (sisu.signers.length == 0) {
+    $('#KonteineriAllkirjastajad').append($('<li>').text('Allkirju ei ole'));
+  }
+  sisu.signers.forEach((allkirjastaja) => {
+    $('#KonteineriAllkirjastajad').append($('<li>').text(allkirjastaja.commonName));
+  });
+  $('#KonteineriSisu').removeClass('peidetud');
+}
+
+// loeVastus loeb serveripoole JSON vastuse. Vea korral on vastuses ümbrik
+// { error: { code, message } }, mille teade on sirviku keeles.
+function loeVastus(response) {
+  return response.json().then(data => {
+    console.log("Vastuse keha: ", data);
+    if (data.error) {
+      throw new Error(data.error.message);
+    }
+    return data;
+  });
+}
+
+// Viimati allkirjastatud konteineri allalaadimise aadress.
+var allalaadimiseAadress;
+
+// lubaAllalaadimine teeb allalaadimisnupu kasutatavaks.
+function lubaAllalaadimine(aadress) {
+  allalaadimiseAadress = aadress;
+  $('#laeAllaNupp').removeClass('disabled');
+}
+
+// ID-kaardiga allkirjastamise jälg, mis on ühine päringutele /p1 ja /p2.
+var idkaardiJalg;
+
 // seaNupukasitlejad määrab "Allkirjasta ID-kaardiga" ja "Allkirjasta m-ID-ga"
 // käitumise.
 function seaNupukasitlejad() {
@@ -35,8 +167,8 @@ function seaNupukasitlejad() {
   $('#IDkaartNupp').click(() => {
 
     // Tühja teksti ei saa allkirjastada.
-    if (document.getElementById("Tekstisisestusala").innerText.length == 0) {
-      kuvaTeade("Tühja teksti ei saa allkirjastada.", true);
+    if (!allkirjastatavOlemas()) {
+      kuvaTeade("Sisesta allkirjastatav tekst, vali fail või konteiner.", true);
       return
     } 
 
@@ -60,28 +192,22 @@ function seaNupukasitlejad() {
           kuvaTeade('Loetud sert:\n' + certPEM.substr(0, 40) + '...', false);
 
           // Saada allkirjastatav tekst ja sert serveripoolele.
-          fetch('https://localhost:8080/p1', {
+          idkaardiJalg = uusJalg();
+          var p1 = paringuKeha({ sert: certPEM }, idkaardiJalg);
+          fetch('/p1', {
             method: 'POST',
-            headers: {
-              'content-type': 'application/json'
-            },
-            body: JSON.stringify({
-              tekst: document.getElementById("Tekstisisestusala").innerText,
-              sert: certPEM 
-            })
+            headers: p1.headers,
+            body: p1.body
           })
             // Loe vastus sisse, JSON-na
-            .then(response => { 
-              console.log("Vastus saadud!");
-              response.json() 
-              .then(data => { 
-                console.log("Vastuse keha: ", data);
-                // IDkaardiga2 teeb allkirjastamise teise osa: PIN2 küsimine jne.
-                IDkaardiga2(data.hash, data.algo)
-              })
+            .then(loeVastus)
+            .then(data => {
+              // IDkaardiga2 teeb allkirjastamise teise osa: PIN2 küsimine jne.
+              IDkaardiga2(data.hash, data.algo)
             })
             .catch(err => {
               console.log("ID-kaardiga: Viga P1 saatmisel: ", err)
+              kuvaTeade(err.message, true)
             })
         },
         function (err) {
@@ -96,39 +222,86 @@ function seaNupukasitlejad() {
   });
 
   $('#mIDNupp').click(() => {
-    // Saada allkirjastatav tekst, isikukood ja mobiilinr serveripoolele.
-    fetch('https://localhost:8080/mid', {
+
+    // Tühja teksti ei saa allkirjastada.
+    if (!allkirjastatavOlemas()) {
+      kuvaTeade("Sisesta allkirjastatav tekst, vali fail või konteiner.", true);
+      return
+    }
+
+    // Saada allkirjastatav tekst või failid, isikukood ja mobiilinr
+    // serveripoolele.
+    var jalg = uusJalg();
+    var mid = paringuKeha({
+      isikukood: document.getElementById("Isikukood").value,
+      nr: document.getElementById("Mobiilinumber").value
+    }, jalg);
+    $('#mIDNupp').addClass('disabled');
+    fetch('/mid', {
       method: 'POST',
-      headers: {
-        'content-type': 'application/json'
-      },
-      body: JSON.stringify({
-        isikukood: "60001019906",
-        nr: "37200000766",
-        tekst: document.getElementById("Tekstisisestusala").innerText
-      })
+      headers: mid.headers,
+      body: mid.body
     })
       // Loe vastus sisse, JSON-na
-      .then(response => { 
-        console.log("Vastus saadud!");
-        response.json() 
-        .then(data => { 
-          console.log("Vastuse keha: ", data);
-          kuvaTeade("Allkirjastamine edukas", false)
-        })
+      .then(loeVastus)
+      .then(data => {
+        kuvaTeade("Kontrollkood: " + data.challenge +
+          ". Kontrolli, et telefonis kuvatakse sama kood, ja sisesta PIN2.", false);
+        midOlek(jalg);
       })
       .catch(err => {
-        console.log("m-ID-ga: Viga P1 saatmisel: ", err)
-        kuvaTeade("Päring ebaõnnestus", true)
+        console.log("m-ID-ga: Viga päringu saatmisel: ", err)
+        $('#mIDNupp').removeClass('disabled');
+        kuvaTeade(err.message, true)
       })
 
   });
 
+  // Lae seansis allkirjastatud konteiner alla. Server saadab selle
+  // Content-Disposition: attachment päisega, nii et leht jääb avatuks.
   $('#laeAllaNupp').click(() => {
-
+    if (!allalaadimiseAadress) {
+      return;
+    }
+    window.location.href = allalaadimiseAadress;
   });
 }
 
+// midOlekuIntervall on m-ID allkirjastamise olekupäringute vahe (ms).
+const midOlekuIntervall = 3000;
+
+// midOlek pärib serveripoolelt m-ID allkirjastamise olekut, kuni
+// allkirjastamine on lõppenud.
+function midOlek(jalg) {
+  fetch('/mid/status', {
+    headers: { 'traceparent': traceparent(jalg) }
+  })
+    .then(response => response.json())
+    .then(data => {
+      console.log("m-ID olek: ", data);
+      if (data.error && !data.status) {
+        throw new Error(data.error.message);
+      }
+      switch (data.status) {
+        case 'OUTSTANDING':
+          setTimeout(() => midOlek(jalg), midOlekuIntervall);
+          return;
+        case 'SIGNED':
+          kuvaTeade("Allkirjastamine edukas", false);
+          lubaAllalaadimine(data.signedfile);
+          break;
+        default:
+          kuvaTeade(data.error ? data.error.message : "Allkirjastamine ebaõnnestus", true);
+      }
+      $('#mIDNupp').removeClass('disabled');
+    })
+    .catch(err => {
+      console.log("m-ID-ga: Viga olekupäringu saatmisel: ", err)
+      $('#mIDNupp').removeClass('disabled');
+      kuvaTeade(err.message, true)
+    })
+}
+
 // IDkaardiga2 teeb allkirjastamise teise osa: PIN2 küsimine jne.
 function IDkaardiga2(hash, algo) {
   console.log("Alustan allkirjastamise 2. osa")
@@ -154,32 +327,25 @@ function IDkaardiga2(hash, algo) {
 function saadaAllkiri(signatureValue) {
   // Teisenda Base64 kujule
   var s = base64js.fromByteArray(signatureValue);
-  fetch('https://localhost:8080/p2', {
+  fetch('/p2', {
     method: 'POST',
     headers: {
-      'content-type': 'application/json'
+      'content-type': 'application/json',
+      'traceparent': traceparent(idkaardiJalg)
     },
     body: JSON.stringify({
       allkiri: s
     })
   })
     // Loe vastus sisse, JSON-na
-    .then(response => { 
-      console.log("P2 vastus saadud!");
-      response.json() 
-      .then(data => { 
-        console.log("P2 Vastuse keha: ", data);
-        // Töötle vastus
-        if (data.error !== "") {
-          kuvaTeade(data.error, true);
-        } else {
-          kuvaTeade("Allkiri edukalt antud", false);
-          $('#laeAllaNupp').removeClass('disabled');
-        }
-      })
+    .then(loeVastus)
+    .then(data => {
+      kuvaTeade("Allkiri edukalt antud", false);
+      lubaAllalaadimine(data.signedfile);
     })
     .catch(err => {
       console.log("ID-kaardiga: Viga P2 saatmisel: ", err)
+      kuvaTeade(err.message, true)
     })
 }
 
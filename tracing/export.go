@@ -0,0 +1,182 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// spanJSON is the JSON representation of an exported span.
+type spanJSON struct {
+	TraceID    string                 `json:"traceId"`
+	SpanID     string                 `json:"spanId"`
+	ParentID   string                 `json:"parentSpanId,omitempty"`
+	Name       string                 `json:"name"`
+	Start      time.Time              `json:"start"`
+	End        time.Time              `json:"end"`
+	Duration   float64                `json:"durationSeconds"`
+	Attributes map[string]interface{} `json:"attributes,omitempty"`
+	Error      string                 `json:"error,omitempty"`
+}
+
+// MarshalJSON encodes s as a JSON object with hex-encoded identifiers.
+func (s *Span) MarshalJSON() ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := spanJSON{
+		TraceID:    hex.EncodeToString(s.Context.TraceID[:]),
+		SpanID:     hex.EncodeToString(s.Context.SpanID[:]),
+		Name:       s.Name,
+		Start:      s.Start,
+		End:        s.End,
+		Duration:   s.End.Sub(s.Start).Seconds(),
+		Attributes: s.Attributes,
+		Error:      s.Error,
+	}
+	if s.ParentID != [8]byte{} {
+		out.ParentID = hex.EncodeToString(s.ParentID[:])
+	}
+	return json.Marshal(out)
+}
+
+// WriterExporter writes each span as a line of JSON to an io.Writer, e.g.,
+// an open file. Errors are passed to OnError, if set.
+type WriterExporter struct {
+	mu      sync.Mutex
+	w       io.Writer
+	OnError func(error)
+}
+
+// NewWriterExporter returns a WriterExporter which writes to w.
+func NewWriterExporter(w io.Writer) *WriterExporter {
+	return &WriterExporter{w: w}
+}
+
+// Export implements Exporter.
+func (e *WriterExporter) Export(span *Span) {
+	line, err := json.Marshal(span)
+	if err == nil {
+		e.mu.Lock()
+		_, err = e.w.Write(append(line, '\n'))
+		e.mu.Unlock()
+	}
+	if err != nil && e.OnError != nil {
+		e.OnError(errors.Wrap(err, "export span"))
+	}
+}
+
+// CollectorExporter sends spans as JSON arrays with HTTP POST requests to a
+// collector. Spans are buffered and sent in the background when BatchSize
+// spans have been collected or Interval has passed.
+type CollectorExporter struct {
+	url     string
+	client  *http.Client
+	onError func(error)
+
+	spans chan *Span
+	done  chan struct{}
+}
+
+// Default CollectorExporter parameters.
+const (
+	DefaultBatchSize     = 100
+	DefaultFlushInterval = 5 * time.Second
+)
+
+// NewCollectorExporter starts a CollectorExporter which sends spans to url
+// using client. If client is nil, then http.DefaultClient is used. Errors
+// are passed to onError, if not nil. Shutdown must be called to flush the
+// remaining spans.
+func NewCollectorExporter(url string, client *http.Client, onError func(error)) *CollectorExporter {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	e := &CollectorExporter{
+		url:     url,
+		client:  client,
+		onError: onError,
+		spans:   make(chan *Span, DefaultBatchSize),
+		done:    make(chan struct{}),
+	}
+	go e.run()
+	return e
+}
+
+// Export implements Exporter. It does not block: if the buffer is full, then
+// the span is dropped.
+func (e *CollectorExporter) Export(span *Span) {
+	select {
+	case e.spans <- span:
+	default:
+		e.error(errors.New("export span: buffer full, span dropped"))
+	}
+}
+
+// Shutdown stops the exporter and sends the buffered spans. Export must not
+// be called after Shutdown.
+func (e *CollectorExporter) Shutdown(ctx context.Context) error {
+	close(e.spans)
+	select {
+	case <-e.done:
+		return nil
+	case <-ctx.Done():
+		return errors.WithStack(ctx.Err())
+	}
+}
+
+func (e *CollectorExporter) run() {
+	defer close(e.done)
+	ticker := time.NewTicker(DefaultFlushInterval)
+	defer ticker.Stop()
+
+	batch := make([]*Span, 0, DefaultBatchSize)
+	for {
+		select {
+		case span, ok := <-e.spans:
+			if !ok {
+				e.send(batch)
+				return
+			}
+			if batch = append(batch, span); len(batch) >= DefaultBatchSize {
+				e.send(batch)
+				batch = batch[:0]
+			}
+		case <-ticker.C:
+			e.send(batch)
+			batch = batch[:0]
+		}
+	}
+}
+
+func (e *CollectorExporter) send(batch []*Span) {
+	if len(batch) == 0 {
+		return
+	}
+	body, err := json.Marshal(batch)
+	if err != nil {
+		e.error(errors.Wrap(err, "export spans"))
+		return
+	}
+	resp, err := e.client.Post(e.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		e.error(errors.Wrap(err, "export spans"))
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		e.error(errors.Errorf("export spans: collector responded %s", resp.Status))
+	}
+}
+
+func (e *CollectorExporter) error(err error) {
+	if e.onError != nil {
+		e.onError(err)
+	}
+}
@@ -0,0 +1,242 @@
+/*
+Package tracing provides minimal distributed tracing with W3C Trace Context
+(traceparent header) propagation.
+
+Spans are carried in context.Context. A nil *Tracer and nil *Span are valid
+and discard everything, so instrumented code does not need to check whether
+tracing is enabled.
+*/
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// TraceparentHeader is the name of the W3C Trace Context header.
+const TraceparentHeader = "traceparent"
+
+// SpanContext identifies a span and the trace it belongs to.
+type SpanContext struct {
+	TraceID [16]byte
+	SpanID  [8]byte
+	Flags   byte
+}
+
+// SampledFlag is the trace flag indicating that the caller recorded the trace.
+const SampledFlag = 0x01
+
+// ParseTraceparent parses a SpanContext from a version 00 traceparent header
+// value.
+func ParseTraceparent(value string) (SpanContext, error) {
+	var sc SpanContext
+	parts := strings.Split(strings.TrimSpace(value), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" {
+		return sc, errors.Errorf("invalid traceparent: %q", value)
+	}
+	if parts[0] == "00" && len(parts) != 4 {
+		return sc, errors.Errorf("invalid traceparent: %q", value)
+	}
+	if err := decodeHex(sc.TraceID[:], parts[1]); err != nil {
+		return sc, errors.WithMessage(err, "invalid trace-id")
+	}
+	if err := decodeHex(sc.SpanID[:], parts[2]); err != nil {
+		return sc, errors.WithMessage(err, "invalid parent-id")
+	}
+	var flags [1]byte
+	if err := decodeHex(flags[:], parts[3]); err != nil {
+		return sc, errors.WithMessage(err, "invalid trace-flags")
+	}
+	sc.Flags = flags[0]
+	if !sc.IsValid() {
+		return sc, errors.Errorf("invalid traceparent: %q", value)
+	}
+	return sc, nil
+}
+
+func decodeHex(dst []byte, s string) error {
+	if len(s) != hex.EncodedLen(len(dst)) || strings.ToLower(s) != s {
+		return errors.Errorf("malformed %q", s)
+	}
+	_, err := hex.Decode(dst, []byte(s))
+	return errors.WithStack(err)
+}
+
+// IsValid reports if sc has non-zero trace and span identifiers.
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID != [16]byte{} && sc.SpanID != [8]byte{}
+}
+
+// Traceparent formats sc as a version 00 traceparent header value.
+func (sc SpanContext) Traceparent() string {
+	return "00-" + hex.EncodeToString(sc.TraceID[:]) + "-" +
+		hex.EncodeToString(sc.SpanID[:]) + "-" + hex.EncodeToString([]byte{sc.Flags})
+}
+
+// Span is a timed operation in a trace.
+type Span struct {
+	tracer *Tracer
+	mu     sync.Mutex
+	ended  bool
+
+	Name       string
+	Context    SpanContext
+	ParentID   [8]byte // Zero if the span is the root of the trace.
+	Start      time.Time
+	End        time.Time
+	Attributes map[string]interface{}
+	Error      string
+}
+
+// SetAttribute sets an attribute of s.
+func (s *Span) SetAttribute(key string, value interface{}) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Attributes[key] = value
+}
+
+// Finish ends s, recording err if not nil, and exports it. Only the first
+// call has any effect.
+func (s *Span) Finish(err error) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	s.End = s.tracer.now()
+	if err != nil {
+		s.Error = err.Error()
+	}
+	s.mu.Unlock()
+
+	s.tracer.exporter.Export(s)
+}
+
+// Exporter receives finished spans.
+type Exporter interface {
+	Export(span *Span)
+}
+
+// Tracer creates spans and passes them to an Exporter when finished.
+type Tracer struct {
+	exporter Exporter
+	now      func() time.Time
+}
+
+// NewTracer creates a Tracer which exports spans to exporter.
+func NewTracer(exporter Exporter) *Tracer {
+	return &Tracer{exporter: exporter, now: time.Now}
+}
+
+type spanKey struct{}
+
+// FromContext returns the span carried by ctx or nil.
+func FromContext(ctx context.Context) *Span {
+	span, _ := ctx.Value(spanKey{}).(*Span)
+	return span
+}
+
+type remoteKey struct{}
+
+// WithRemoteParent returns a copy of ctx which carries a span context
+// received from a remote caller. Spans started from the returned context are
+// children of the remote span.
+func WithRemoteParent(ctx context.Context, parent SpanContext) context.Context {
+	return context.WithValue(ctx, remoteKey{}, parent)
+}
+
+// StartSpan starts a new span with the given name. If ctx carries a span or a
+// remote parent, then the new span is its child, otherwise it starts a new
+// trace. The returned context carries the new span.
+func (t *Tracer) StartSpan(ctx context.Context, name string) (context.Context, *Span) {
+	if t == nil {
+		return ctx, nil
+	}
+
+	span := &Span{
+		tracer:     t,
+		Name:       name,
+		Start:      t.now(),
+		Attributes: make(map[string]interface{}),
+	}
+	if parent := FromContext(ctx); parent != nil {
+		span.Context.TraceID = parent.Context.TraceID
+		span.Context.Flags = parent.Context.Flags
+		span.ParentID = parent.Context.SpanID
+	} else if remote, ok := ctx.Value(remoteKey{}).(SpanContext); ok && remote.IsValid() {
+		span.Context.TraceID = remote.TraceID
+		span.Context.Flags = remote.Flags
+		span.ParentID = remote.SpanID
+	} else {
+		rand.Read(span.Context.TraceID[:]) // Never fails.
+		span.Context.Flags = SampledFlag
+	}
+	rand.Read(span.Context.SpanID[:]) // Never fails.
+	return context.WithValue(ctx, spanKey{}, span), span
+}
+
+// Inject sets the traceparent header of an outgoing request to the span
+// carried by ctx. If ctx carries no span, then headers are not modified.
+func Inject(ctx context.Context, headers http.Header) {
+	if span := FromContext(ctx); span != nil {
+		headers.Set(TraceparentHeader, span.Context.Traceparent())
+	}
+}
+
+// Handler wraps h with a handler which starts a server span with the given
+// name for each request. The span is a child of the span in the traceparent
+// header of the request, if it is present and valid. The request context
+// passed to h carries the span.
+func Handler(t *Tracer, name string, h http.Handler) http.Handler {
+	if t == nil {
+		return h
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		if parent, err := ParseTraceparent(r.Header.Get(TraceparentHeader)); err == nil {
+			ctx = WithRemoteParent(ctx, parent)
+		}
+		ctx, span := t.StartSpan(ctx, name)
+		span.SetAttribute("http.method", r.Method)
+		span.SetAttribute("http.target", r.URL.Path)
+
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		defer func() {
+			span.SetAttribute("http.status_code", rec.status)
+			span.Finish(nil)
+		}()
+		h.ServeHTTP(rec, r.WithContext(ctx))
+	})
+}
+
+// statusRecorder records the status code written to a http.ResponseWriter.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (s *statusRecorder) WriteHeader(status int) {
+	s.status = status
+	s.ResponseWriter.WriteHeader(status)
+}
+
+// Flush implements http.Flusher if the wrapped http.ResponseWriter does.
+func (s *statusRecorder) Flush() {
+	if flusher, ok := s.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
@@ -0,0 +1,178 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+// recorder is an Exporter which records exported spans.
+type recorder struct {
+	mu    sync.Mutex
+	spans []*Span
+}
+
+func (r *recorder) Export(span *Span) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.spans = append(r.spans, span)
+}
+
+func TestParseTraceparent_Valid_RoundTrips(t *testing.T) {
+	// given
+	const value = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+
+	// when
+	sc, err := ParseTraceparent(value)
+
+	// then
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sc.Flags != SampledFlag {
+		t.Errorf("unexpected flags: %x", sc.Flags)
+	}
+	if got := sc.Traceparent(); got != value {
+		t.Errorf("unexpected traceparent:\n     got: %s\nexpected: %s", got, value)
+	}
+}
+
+func TestParseTraceparent_Invalid_Error(t *testing.T) {
+	for _, value := range []string{
+		"",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7",
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01",
+		"00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra",
+		"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e47-00f067aa0ba902b7-01",
+	} {
+		if _, err := ParseTraceparent(value); err == nil {
+			t.Errorf("expected error for %q", value)
+		}
+	}
+}
+
+func TestHandler_Traceparent_ChildSpansExported(t *testing.T) {
+	// given
+	var rec recorder
+	tracer := NewTracer(&rec)
+	var outgoing http.Header
+	handler := Handler(tracer, "test", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, span := tracer.StartSpan(r.Context(), "child")
+		outgoing = make(http.Header)
+		Inject(ctx, outgoing)
+		span.Finish(nil)
+		w.WriteHeader(http.StatusTeapot)
+	}))
+	req := httptest.NewRequest(http.MethodPost, "/p1", nil)
+	req.Header.Set(TraceparentHeader, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+
+	// when
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	// then
+	if len(rec.spans) != 2 {
+		t.Fatalf("unexpected number of spans: %d", len(rec.spans))
+	}
+	child, server := rec.spans[0], rec.spans[1]
+	remote, _ := ParseTraceparent(req.Header.Get(TraceparentHeader))
+	if server.Context.TraceID != remote.TraceID || server.ParentID != remote.SpanID {
+		t.Error("server span is not a child of the remote span")
+	}
+	if child.Context.TraceID != remote.TraceID || child.ParentID != server.Context.SpanID {
+		t.Error("child span is not a child of the server span")
+	}
+	if got := outgoing.Get(TraceparentHeader); got != child.Context.Traceparent() {
+		t.Errorf("unexpected outgoing traceparent: %s", got)
+	}
+	if status := server.Attributes["http.status_code"]; status != http.StatusTeapot {
+		t.Errorf("unexpected status code: %v", status)
+	}
+}
+
+func TestStartSpan_NoParent_NewTrace(t *testing.T) {
+	// given
+	var rec recorder
+	tracer := NewTracer(&rec)
+
+	// when
+	_, span := tracer.StartSpan(context.Background(), "root")
+	span.Finish(nil)
+	span.Finish(nil)
+
+	// then
+	if !span.Context.IsValid() || span.ParentID != [8]byte{} {
+		t.Errorf("unexpected root span context: %s", span.Context.Traceparent())
+	}
+	if len(rec.spans) != 1 {
+		t.Errorf("span exported %d times", len(rec.spans))
+	}
+}
+
+func TestStartSpan_NilTracer_Discarded(t *testing.T) {
+	var tracer *Tracer
+	ctx, span := tracer.StartSpan(context.Background(), "discarded")
+	span.SetAttribute("key", "value")
+	span.Finish(nil)
+	if FromContext(ctx) != nil {
+		t.Error("unexpected span in context")
+	}
+}
+
+func TestWriterExporter_Span_JSONLine(t *testing.T) {
+	// given
+	var buf bytes.Buffer
+	tracer := NewTracer(NewWriterExporter(&buf))
+	_, span := tracer.StartSpan(context.Background(), "write")
+	span.SetAttribute("key", "value")
+
+	// when
+	span.Finish(nil)
+
+	// then
+	var decoded map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if decoded["name"] != "write" || decoded["traceId"] == "" {
+		t.Errorf("unexpected span: %s", buf.String())
+	}
+	if _, ok := decoded["parentSpanId"]; ok {
+		t.Errorf("unexpected parent span: %s", buf.String())
+	}
+}
+
+func TestCollectorExporter_Shutdown_SpansSent(t *testing.T) {
+	// given
+	received := make(chan []map[string]interface{}, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var spans []map[string]interface{}
+		if err := json.NewDecoder(r.Body).Decode(&spans); err != nil {
+			t.Error(err)
+		}
+		received <- spans
+	}))
+	defer srv.Close()
+	exporter := NewCollectorExporter(srv.URL, srv.Client(), func(err error) { t.Error(err) })
+	tracer := NewTracer(exporter)
+	for i := 0; i < 3; i++ {
+		_, span := tracer.StartSpan(context.Background(), "collect")
+		span.Finish(nil)
+	}
+
+	// when
+	if err := exporter.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	// then
+	if spans := <-received; len(spans) != 3 {
+		t.Errorf("unexpected number of spans: %d", len(spans))
+	}
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+
+	"github.com/e-gov/SiGa-Go/siga"
+)
+
+// Sirvikust üles laaditavate failide piirangud.
+const (
+	// maxUploadFiles on ühes päringus üles laaditavate failide suurim arv.
+	maxUploadFiles = 10
+	// maxUploadFileSize on ühe üles laaditava faili suurim suurus baitides.
+	maxUploadFileSize = 10 << 20
+	// maxUploadSize on multipart päringu keha suurim suurus baitides.
+	maxUploadSize = 25 << 20
+	// uploadMemory on päringu keha osa, mida hoitakse mälus; ülejäänu
+	// kirjutatakse ajutistesse failidesse.
+	uploadMemory = 1 << 20
+)
+
+// uploadField on allkirjastatavaid faile sisaldava multipart välja nimi.
+const uploadField = "failid"
+
+// textFileName on allkirjastatava teksti failinimi konteineris.
+const textFileName = "fail.txt"
+
+// isMultipart teatab, kas päringu keha on multipart/form-data vormingus.
+func isMultipart(req *http.Request) bool {
+	mediaType, _, err := mime.ParseMediaType(req.Header.Get("Content-Type"))
+	return err == nil && mediaType == "multipart/form-data"
+}
+
+// readUpload loeb multipart/form-data päringust vormi väljad ja
+// allkirjastatavad failid. Failid moodustatakse väljas uploadField
+// saadetud osadest, algse failinimega. Kui vormis on mittetühi väli "tekst",
+// siis lisatakse see failina textFileName.
+func readUpload(w http.ResponseWriter, req *http.Request) (url.Values, []*siga.DataFile, error) {
+	if err := parseMultipart(w, req); err != nil {
+		return nil, nil, err
+	}
+	defer req.MultipartForm.RemoveAll()
+
+	form := url.Values(req.MultipartForm.Value)
+	files := req.MultipartForm.File[uploadField]
+	if len(files) > maxUploadFiles {
+		return nil, nil, newAPIError(http.StatusRequestEntityTooLarge,
+			codeTooManyFiles, len(files), maxUploadFiles)
+	}
+
+	var datafiles []*siga.DataFile
+	names := make(map[string]bool, len(files)+1)
+	add := func(datafile *siga.DataFile, name string) error {
+		if names[name] {
+			return newAPIError(http.StatusBadRequest, codeDuplicateFileName, name)
+		}
+		names[name] = true
+		datafiles = append(datafiles, datafile)
+		return nil
+	}
+
+	if tekst := form.Get("tekst"); tekst != "" {
+		datafile, err := siga.NewDataFile(textFileName, strings.NewReader(tekst))
+		if err != nil {
+			return nil, nil, err
+		}
+		if err := add(datafile, textFileName); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	for _, fh := range files {
+		name := uploadFileName(fh.Filename)
+		if name == "" {
+			return nil, nil, newAPIError(http.StatusBadRequest,
+				codeInvalidFileName, fh.Filename)
+		}
+		if fh.Size > maxUploadFileSize {
+			return nil, nil, newAPIError(http.StatusRequestEntityTooLarge,
+				codeFileTooLarge, name, fh.Size, maxUploadFileSize)
+		}
+		f, err := fh.Open()
+		if err != nil {
+			return nil, nil, err
+		}
+		datafile, err := siga.NewDataFile(name, f)
+		f.Close()
+		if err != nil {
+			return nil, nil, newAPIError(http.StatusBadRequest,
+				codeInvalidFile, name).withCause(err)
+		}
+		if err := add(datafile, name); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	if len(datafiles) == 0 {
+		return nil, nil, newAPIError(http.StatusBadRequest, codeNothingToSign)
+	}
+	return form, datafiles, nil
+}
+
+// parseMultipart loeb multipart/form-data päringu keha, kuni maxUploadSize
+// baiti. Kutsuja peab ajutised failid eemaldama (req.MultipartForm.RemoveAll).
+func parseMultipart(w http.ResponseWriter, req *http.Request) error {
+	req.Body = http.MaxBytesReader(w, req.Body, maxUploadSize)
+	if err := req.ParseMultipartForm(uploadMemory); err != nil {
+		if isTooLarge(err) {
+			return newAPIError(http.StatusRequestEntityTooLarge,
+				codeBodyTooLarge, maxUploadSize).withCause(err)
+		}
+		return newAPIError(http.StatusBadRequest, codeInvalidForm).withCause(err)
+	}
+	return nil
+}
+
+// uploadFileName tagastab sirviku saadetud failinimest kataloogideta
+// failinime. Mõned sirvikud saadavad kogu failitee, ka Windowsi kujul.
+func uploadFileName(filename string) string {
+	name := path.Base(strings.Replace(filename, `\`, "/", -1))
+	switch name {
+	case ".", "..", "/":
+		return ""
+	}
+	return name
+}
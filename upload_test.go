@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bytes"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// uploadRequest moodustab multipart/form-data päringu antud väljade ja
+// failidega (failinimi -> sisu).
+func uploadRequest(t *testing.T, fields map[string]string, files ...[2]string) *http.Request {
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	for name, value := range fields {
+		if err := mw.WriteField(name, value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, file := range files {
+		fw, err := mw.CreateFormFile(uploadField, file[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		fw.Write([]byte(file[1]))
+	}
+	if err := mw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest(http.MethodPost, "/p1", &body)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	return req
+}
+
+func TestReadUpload_FilesAndText_DataFilesWithOriginalNames(t *testing.T) {
+	// given
+	req := uploadRequest(t,
+		map[string]string{"tekst": "Tere", "sert": "PEM"},
+		[2]string{"leping.pdf", "%PDF-1.4"},
+		[2]string{`C:\Users\kasutaja\lisa.txt`, "lisa"})
+
+	// when
+	form, datafiles, err := readUpload(httptest.NewRecorder(), req)
+
+	// then
+	if err != nil {
+		t.Fatal(err)
+	}
+	if form.Get("sert") != "PEM" {
+		t.Errorf("unexpected sert: %q", form.Get("sert"))
+	}
+	if len(datafiles) != 3 {
+		t.Fatalf("unexpected number of datafiles: %d", len(datafiles))
+	}
+	var names []string
+	for _, datafile := range datafiles {
+		names = append(names, datafile.Name())
+	}
+	expected := []string{textFileName, "leping.pdf", "lisa.txt"}
+	for i := range expected {
+		if names[i] != expected[i] {
+			t.Errorf("unexpected names: %v, expected %v", names, expected)
+			break
+		}
+	}
+}
+
+func TestReadUpload_DuplicateNames_BadRequest(t *testing.T) {
+	// given
+	req := uploadRequest(t, nil,
+		[2]string{"a.txt", "1"},
+		[2]string{"kataloog/a.txt", "2"})
+
+	// when
+	_, _, err := readUpload(httptest.NewRecorder(), req)
+
+	// then
+	if status := errorStatus(err); status != http.StatusBadRequest {
+		t.Errorf("unexpected status: %d, error: %v", status, err)
+	}
+}
+
+func TestReadUpload_TooManyFiles_TooLarge(t *testing.T) {
+	// given
+	var files [][2]string
+	for i := 0; i <= maxUploadFiles; i++ {
+		files = append(files, [2]string{string('a'+rune(i)) + ".txt", "x"})
+	}
+	req := uploadRequest(t, nil, files...)
+
+	// when
+	_, _, err := readUpload(httptest.NewRecorder(), req)
+
+	// then
+	if status := errorStatus(err); status != http.StatusRequestEntityTooLarge {
+		t.Errorf("unexpected status: %d, error: %v", status, err)
+	}
+}
+
+func TestReadUpload_FileTooLarge_TooLarge(t *testing.T) {
+	// given
+	req := uploadRequest(t, nil,
+		[2]string{"suur.bin", string(make([]byte, maxUploadFileSize+1))})
+
+	// when
+	_, _, err := readUpload(httptest.NewRecorder(), req)
+
+	// then
+	if status := errorStatus(err); status != http.StatusRequestEntityTooLarge {
+		t.Errorf("unexpected status: %d, error: %v", status, err)
+	}
+}
+
+func TestReadUpload_Empty_BadRequest(t *testing.T) {
+	// given
+	req := uploadRequest(t, map[string]string{"sert": "PEM"})
+
+	// when
+	_, _, err := readUpload(httptest.NewRecorder(), req)
+
+	// then
+	if status := errorStatus(err); status != http.StatusBadRequest {
+		t.Errorf("unexpected status: %d, error: %v", status, err)
+	}
+}